@@ -0,0 +1,124 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/lietu/godometer"
+)
+
+const defaultTimeout = 10 * time.Second
+
+// Pushes data points to a godometer server
+type Client struct {
+	BaseUrl    string
+	Auth       string
+	HTTPClient *http.Client
+	// How many times to retry on network errors and 5xx responses
+	Retries    int
+	RetryDelay time.Duration
+	// Split up pushes into requests of at most this many data points, 0 for no limit
+	MaxBatchSize int
+}
+
+type StatusError struct {
+	Url        string
+	StatusCode int
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("%s returned status %d", e.Url, e.StatusCode)
+}
+
+// Server errors might go away by themselves, client errors won't
+func (e *StatusError) Temporary() bool {
+	return e.StatusCode >= 500 || e.StatusCode == http.StatusTooManyRequests
+}
+
+func NewClient(baseUrl string, auth string) *Client {
+	return &Client{
+		BaseUrl:    baseUrl,
+		Auth:       auth,
+		HTTPClient: &http.Client{Timeout: defaultTimeout},
+		Retries:    0,
+		RetryDelay: time.Second,
+	}
+}
+
+func (c *Client) Push(ctx context.Context, dataPoints []godometer.UpdateDataPoint) error {
+	batchSize := len(dataPoints)
+	if c.MaxBatchSize > 0 && c.MaxBatchSize < batchSize {
+		batchSize = c.MaxBatchSize
+	}
+
+	for start := 0; start < len(dataPoints); start += batchSize {
+		end := start + batchSize
+		if end > len(dataPoints) {
+			end = len(dataPoints)
+		}
+
+		err := c.pushWithRetries(ctx, dataPoints[start:end])
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (c *Client) pushWithRetries(ctx context.Context, dataPoints []godometer.UpdateDataPoint) error {
+	var err error
+	for attempt := 0; attempt <= c.Retries; attempt++ {
+		if attempt > 0 {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(c.RetryDelay):
+			}
+		}
+
+		err = c.push(ctx, dataPoints)
+		if err == nil {
+			return nil
+		}
+
+		if se, ok := err.(*StatusError); ok && !se.Temporary() {
+			return err
+		}
+	}
+
+	return err
+}
+
+func (c *Client) push(ctx context.Context, dataPoints []godometer.UpdateDataPoint) error {
+	payload := godometer.UpdateStatsRequest{DataPoints: dataPoints}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("failed to marshal request: %w", err)
+	}
+
+	url := fmt.Sprintf("%s/api/v1/updateStats", c.BaseUrl)
+	request, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewBuffer(body))
+	if err != nil {
+		return fmt.Errorf("failed to initialize request: %w", err)
+	}
+
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set("Authorization", c.Auth)
+
+	resp, err := c.HTTPClient.Do(request)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return &StatusError{Url: url, StatusCode: resp.StatusCode}
+	}
+
+	return nil
+}
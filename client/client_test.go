@@ -0,0 +1,127 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync"
+	"testing"
+
+	"github.com/lietu/godometer"
+)
+
+var testDataPoints = []godometer.UpdateDataPoint{
+	{Timestamp: "2024-03-06 12:29", Meters: 100, MetersPerSecond: 1.7, KilometersPerHour: 6},
+	{Timestamp: "2024-03-06 12:30", Meters: 120, MetersPerSecond: 2, KilometersPerHour: 7.2},
+}
+
+// Server answering with the given statuses in order, repeating the last one
+type testServer struct {
+	*httptest.Server
+	mutex    sync.Mutex
+	statuses []int
+	requests []godometer.UpdateStatsRequest
+}
+
+func newTestServer(t *testing.T, statuses ...int) *testServer {
+	ts := &testServer{statuses: statuses}
+	ts.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v1/updateStats" || r.Header.Get("Authorization") != "secret" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+
+		req := godometer.UpdateStatsRequest{}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Errorf("Failed to parse request: %s", err)
+		}
+
+		ts.mutex.Lock()
+		defer ts.mutex.Unlock()
+		ts.requests = append(ts.requests, req)
+		status := ts.statuses[0]
+		if len(ts.statuses) > 1 {
+			ts.statuses = ts.statuses[1:]
+		}
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(ts.Close)
+	return ts
+}
+
+func TestPush(t *testing.T) {
+	ts := newTestServer(t, http.StatusOK)
+	c := NewClient(ts.URL, "secret")
+
+	if err := c.Push(context.Background(), testDataPoints); err != nil {
+		t.Fatalf("Push failed: %s", err)
+	}
+
+	if len(ts.requests) != 1 || !reflect.DeepEqual(ts.requests[0].DataPoints, testDataPoints) {
+		t.Errorf("Server got %+v", ts.requests)
+	}
+}
+
+func TestPushBatches(t *testing.T) {
+	ts := newTestServer(t, http.StatusOK)
+	c := NewClient(ts.URL, "secret")
+	c.MaxBatchSize = 1
+
+	if err := c.Push(context.Background(), testDataPoints); err != nil {
+		t.Fatalf("Push failed: %s", err)
+	}
+
+	if len(ts.requests) != 2 || len(ts.requests[1].DataPoints) != 1 {
+		t.Errorf("Expected two requests of one data point, got %+v", ts.requests)
+	}
+}
+
+func TestPushAuthFailure(t *testing.T) {
+	ts := newTestServer(t, http.StatusOK)
+	c := NewClient(ts.URL, "wrong")
+	c.Retries = 3
+	c.RetryDelay = 0
+
+	err := c.Push(context.Background(), testDataPoints)
+	var se *StatusError
+	if !errors.As(err, &se) || se.StatusCode != http.StatusForbidden {
+		t.Fatalf("Expected a 403 StatusError, got %v", err)
+	}
+	if se.Temporary() {
+		t.Errorf("Auth failure shouldn't be temporary")
+	}
+}
+
+func TestPushRetriesTransientError(t *testing.T) {
+	ts := newTestServer(t, http.StatusServiceUnavailable, http.StatusOK)
+	c := NewClient(ts.URL, "secret")
+	c.Retries = 2
+	c.RetryDelay = 0
+
+	if err := c.Push(context.Background(), testDataPoints); err != nil {
+		t.Fatalf("Push failed: %s", err)
+	}
+
+	if len(ts.requests) != 2 {
+		t.Errorf("Expected the request to be retried once, got %d requests", len(ts.requests))
+	}
+}
+
+func TestPushGivesUpAfterRetries(t *testing.T) {
+	ts := newTestServer(t, http.StatusServiceUnavailable)
+	c := NewClient(ts.URL, "secret")
+	c.Retries = 2
+	c.RetryDelay = 0
+
+	err := c.Push(context.Background(), testDataPoints)
+	var se *StatusError
+	if !errors.As(err, &se) || se.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("Expected a 503 StatusError, got %v", err)
+	}
+	if len(ts.requests) != 3 {
+		t.Errorf("Expected 3 attempts, got %d", len(ts.requests))
+	}
+}
@@ -1,11 +1,15 @@
 package main
 
 import (
+	"encoding/json"
 	"flag"
 	"fmt"
+	"io/ioutil"
 	"log"
 	"os"
 	"strconv"
+	"strings"
+	"time"
 
 	"cloud.google.com/go/compute/metadata"
 	"github.com/lietu/godometer/server"
@@ -14,22 +18,79 @@ import (
 const fakeProjectId = "some-fake-project-id"
 
 var (
-	fakeData  = flag.Bool("fakeData", false, "Generate fake data, for testing frontend. Optionally use the FAKE_DATA environment variable.")
-	dev       = flag.Bool("dev", false, "Development mode (allow insecure traffic). Optionally use the DEV environment variable.")
-	host      = flag.String("host", "0.0.0.0", "Which TCP address to listen on, 0.0.0.0 for all. Optionally use the HOST environment variable.")
-	port      = flag.Int("port", 8080, "Which TCP port to listen to. Optionally use the PORT environment variable.")
-	apiAuth   = flag.String("apiAuth", "", "Password for API. Optionally use the API_AUTH environment variable.")
-	projectId = flag.String("projectId", fakeProjectId, "Google Cloud Project ID for Firestore access. Optionally use the PROJECT_ID environment variable.")
+	fakeData         = flag.Bool("fakeData", false, "Generate fake data, for testing frontend. Optionally use the FAKE_DATA environment variable.")
+	fakeDataInterval = flag.Duration("fakeDataInterval", time.Minute, "How often to generate a fake event. Optionally use the FAKE_DATA_INTERVAL environment variable.")
+	dev              = flag.Bool("dev", false, "Development mode (allow insecure traffic). Optionally use the DEV environment variable.")
+	host             = flag.String("host", "0.0.0.0", "Which TCP address to listen on, 0.0.0.0 for all. Optionally use the HOST environment variable.")
+	port             = flag.Int("port", 8080, "Which TCP port to listen to. Optionally use the PORT environment variable.")
+	apiAuth          = flag.String("apiAuth", "", "Password for API. Optionally use the API_AUTH environment variable.")
+	projectId        = flag.String("projectId", fakeProjectId, "Google Cloud Project ID for Firestore access. Optionally use the PROJECT_ID environment variable.")
+	bufferInterval   = flag.Duration("bufferInterval", 0, "Buffer updates and save them on this interval, e.g. 30s. 0 saves on every update. Optionally use the BUFFER_INTERVAL environment variable.")
+	bufferSize       = flag.Int("bufferSize", 100, "Save buffered updates early once this many are queued. Optionally use the BUFFER_SIZE environment variable.")
+	coalesce         = flag.Bool("coalesce", false, "Add up the updates of each minute before adding them to the records, with bufferInterval over the whole interval. Optionally use the COALESCE environment variable.")
+	alertSpeed       = flag.Float64("alertSpeed", 0, "Notify alertWebhook when going faster than this many km/h, 0 to disable. Optionally use the ALERT_SPEED environment variable.")
+	alertWebhook     = flag.String("alertWebhook", "", "URL to POST speed alerts to. Optionally use the ALERT_WEBHOOK environment variable.")
+	adminAuth        = flag.String("adminAuth", "", "Password for the admin API, which is disabled when not set. Optionally use the ADMIN_AUTH environment variable.")
+	logLevel         = flag.String("logLevel", "info", "Log level, one of debug, info, warn or error. Optionally use the LOG_LEVEL environment variable.")
+	minuteDetail     = flag.Bool("minuteDetail", false, "Keep a per-hour breakdown of minutes once they're older than an hour. Optionally use the MINUTE_DETAIL environment variable.")
+	readChunkSize    = flag.Int("readChunkSize", 300, "How many records to fetch from Firestore per request, at most 500. Optionally use the READ_CHUNK_SIZE environment variable.")
+	integerStorage   = flag.Bool("integerStorage", false, "Store distance and speed as integer millimeters instead of floats. Optionally use the INTEGER_STORAGE environment variable.")
+	tenantsFile      = flag.String("tenantsFile", "", "JSON file listing the host, projectId, apiAuth and optional mirrorProjectIds of each tenant, to serve several projects at once. Optionally use the TENANTS_FILE environment variable.")
+	maxTenants       = flag.Int("maxTenants", 10, "How many tenants to keep loaded at once. Optionally use the MAX_TENANTS environment variable.")
+	refreshInterval  = flag.Duration("refreshInterval", 0, "Reload the records from Firestore on this interval, e.g. 10m. 0 disables it. Optionally use the REFRESH_INTERVAL environment variable.")
+	breakerThreshold = flag.Int("breakerThreshold", 0, "Reject updates with a 503 after this many failed commits in a row, 0 disables it. Optionally use the BREAKER_THRESHOLD environment variable.")
+	metersPrecision  = flag.Int("metersPrecision", -1, "Round distances to this many decimals in responses, -1 to not round. Optionally use the METERS_PRECISION environment variable.")
+	speedPrecision   = flag.Int("speedPrecision", -1, "Round speeds to this many decimals in responses, -1 to not round. Optionally use the SPEED_PRECISION environment variable.")
+	minSampleMeters  = flag.Float64("minSampleMeters", 0, "Only count updates with more distance than this in the speed averages. Optionally use the MIN_SAMPLE_METERS environment variable.")
+	minSampleSpeed   = flag.Float64("minSampleSpeed", 0, "Only count updates faster than this many m/s in the speed averages, negative to count standing still too. Optionally use the MIN_SAMPLE_SPEED environment variable.")
+	strictDecoding   = flag.Bool("strictDecoding", false, "Fail reads of records that can't be decoded instead of reading them as zeroes. Optionally use the STRICT_DECODING environment variable.")
+	derivedUnit      = flag.String("derivedUnit", "", "Also report distances in this unit, e.g. steps, calculated as meters * derivedFactor + derivedOffset. Optionally use the DERIVED_UNIT environment variable.")
+	derivedFactor    = flag.Float64("derivedFactor", 1, "How many of derivedUnit there are in a meter. Optionally use the DERIVED_FACTOR environment variable.")
+	derivedOffset    = flag.Float64("derivedOffset", 0, "Added to derivedUnit values after multiplying. Optionally use the DERIVED_OFFSET environment variable.")
+	mirrorProjectIds = flag.String("mirrorProjectIds", "", "Comma separated Firestore project IDs to also write all the records to, e.g. while migrating. With a tenants file set them for each tenant instead. Optionally use the MIRROR_PROJECT_IDS environment variable.")
+	strictMirroring  = flag.Bool("strictMirroring", false, "Respond with an error when writing to a mirror fails instead of only logging it, the data is still saved. Optionally use the STRICT_MIRRORING environment variable.")
+	displayTimezone  = flag.String("displayTimezone", "UTC", "Timezone to group activity patterns by hour of day and weekday in, e.g. Europe/Helsinki. Optionally use the DISPLAY_TIMEZONE environment variable.")
+	maxLimit         = flag.Int("maxLimit", 1000, "Lower ?limit= on the read endpoints to at most this. Optionally use the MAX_LIMIT environment variable.")
+	signedMeters     = flag.Bool("signedMeters", false, "Treat negative meters as reverse movement and subtract them from the totals. Optionally use the SIGNED_METERS environment variable.")
 )
 
 type Config struct {
-	dev        bool
-	fakeData   bool
-	host       string
-	projectId  string
-	port       int
-	apiAuth    string
-	inCloudRun bool
+	dev              bool
+	fakeData         bool
+	host             string
+	projectId        string
+	port             int
+	apiAuth          string
+	inCloudRun       bool
+	bufferInterval   time.Duration
+	bufferSize       int
+	coalesce         bool
+	alertSpeed       float64
+	alertWebhook     string
+	signedMeters     bool
+	adminAuth        string
+	fakeDataInterval time.Duration
+	logLevel         string
+	minuteDetail     bool
+	readChunkSize    int
+	integerStorage   bool
+	tenantsFile      string
+	maxTenants       int
+	refreshInterval  time.Duration
+	breakerThreshold int
+	metersPrecision  int
+	speedPrecision   int
+	minSampleMeters  float64
+	minSampleSpeed   float64
+	strictDecoding   bool
+	derivedUnit      string
+	derivedFactor    float64
+	derivedOffset    float64
+	mirrorProjectIds []string
+	strictMirroring  bool
+	displayTimezone  string
+	displayLocation  *time.Location
+	maxLimit         int
 }
 
 func (c *Config) loadMetadata() {
@@ -41,17 +102,57 @@ func (c *Config) loadMetadata() {
 	}
 }
 
+// Splits a comma separated list, leaving out empty items
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		item = strings.TrimSpace(item)
+		if item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
 func parseConfig() Config {
 	flag.Parse()
 
 	c := Config{
-		fakeData:   *fakeData,
-		dev:        *dev,
-		host:       *host,
-		projectId:  *projectId,
-		port:       *port,
-		apiAuth:    *apiAuth,
-		inCloudRun: false,
+		fakeData:         *fakeData,
+		dev:              *dev,
+		host:             *host,
+		projectId:        *projectId,
+		port:             *port,
+		apiAuth:          *apiAuth,
+		inCloudRun:       false,
+		bufferInterval:   *bufferInterval,
+		bufferSize:       *bufferSize,
+		coalesce:         *coalesce,
+		alertSpeed:       *alertSpeed,
+		alertWebhook:     *alertWebhook,
+		signedMeters:     *signedMeters,
+		adminAuth:        *adminAuth,
+		fakeDataInterval: *fakeDataInterval,
+		logLevel:         *logLevel,
+		minuteDetail:     *minuteDetail,
+		readChunkSize:    *readChunkSize,
+		integerStorage:   *integerStorage,
+		tenantsFile:      *tenantsFile,
+		maxTenants:       *maxTenants,
+		refreshInterval:  *refreshInterval,
+		breakerThreshold: *breakerThreshold,
+		metersPrecision:  *metersPrecision,
+		speedPrecision:   *speedPrecision,
+		minSampleMeters:  *minSampleMeters,
+		minSampleSpeed:   *minSampleSpeed,
+		strictDecoding:   *strictDecoding,
+		derivedUnit:      *derivedUnit,
+		derivedFactor:    *derivedFactor,
+		derivedOffset:    *derivedOffset,
+		mirrorProjectIds: splitList(*mirrorProjectIds),
+		strictMirroring:  *strictMirroring,
+		displayTimezone:  *displayTimezone,
+		maxLimit:         *maxLimit,
 	}
 
 	if e := os.Getenv("DEV"); e != "" {
@@ -70,6 +171,15 @@ func parseConfig() Config {
 		}
 	}
 
+	if e := os.Getenv("FAKE_DATA_INTERVAL"); e != "" {
+		d, err := time.ParseDuration(e)
+		if err != nil {
+			log.Printf("Could not parse FAKE_DATA_INTERVAL environment variable: %s", err)
+		} else {
+			c.fakeDataInterval = d
+		}
+	}
+
 	if e := os.Getenv("HOST"); e != "" {
 		c.host = e
 	}
@@ -91,6 +201,208 @@ func parseConfig() Config {
 		c.projectId = e
 	}
 
+	if e := os.Getenv("BUFFER_INTERVAL"); e != "" {
+		d, err := time.ParseDuration(e)
+		if err != nil {
+			log.Printf("Could not parse BUFFER_INTERVAL environment variable: %s", err)
+		} else {
+			c.bufferInterval = d
+		}
+	}
+
+	if e := os.Getenv("BUFFER_SIZE"); e != "" {
+		i, err := strconv.Atoi(e)
+		if err != nil {
+			log.Printf("Could not parse BUFFER_SIZE environment variable: %s", err)
+		} else {
+			c.bufferSize = i
+		}
+	}
+
+	if e := os.Getenv("COALESCE"); e != "" {
+		if e == "1" || e == "yes" || e == "true" {
+			c.coalesce = true
+		} else {
+			c.coalesce = false
+		}
+	}
+
+	if e := os.Getenv("ALERT_SPEED"); e != "" {
+		f, err := strconv.ParseFloat(e, 64)
+		if err != nil {
+			log.Printf("Could not parse ALERT_SPEED environment variable: %s", err)
+		} else {
+			c.alertSpeed = f
+		}
+	}
+
+	if e := os.Getenv("ALERT_WEBHOOK"); e != "" {
+		c.alertWebhook = e
+	}
+
+	if e := os.Getenv("ADMIN_AUTH"); e != "" {
+		c.adminAuth = e
+	}
+
+	if e := os.Getenv("LOG_LEVEL"); e != "" {
+		c.logLevel = e
+	}
+
+	if e := os.Getenv("SIGNED_METERS"); e != "" {
+		if e == "1" || e == "yes" || e == "true" {
+			c.signedMeters = true
+		} else {
+			c.signedMeters = false
+		}
+	}
+
+	if e := os.Getenv("MINUTE_DETAIL"); e != "" {
+		if e == "1" || e == "yes" || e == "true" {
+			c.minuteDetail = true
+		} else {
+			c.minuteDetail = false
+		}
+	}
+
+	if e := os.Getenv("READ_CHUNK_SIZE"); e != "" {
+		i, err := strconv.Atoi(e)
+		if err != nil {
+			log.Printf("Could not parse READ_CHUNK_SIZE environment variable: %s", err)
+		} else {
+			c.readChunkSize = i
+		}
+	}
+
+	if e := os.Getenv("INTEGER_STORAGE"); e != "" {
+		if e == "1" || e == "yes" || e == "true" {
+			c.integerStorage = true
+		} else {
+			c.integerStorage = false
+		}
+	}
+
+	if e := os.Getenv("TENANTS_FILE"); e != "" {
+		c.tenantsFile = e
+	}
+
+	if e := os.Getenv("MAX_TENANTS"); e != "" {
+		i, err := strconv.Atoi(e)
+		if err != nil {
+			log.Printf("Could not parse MAX_TENANTS environment variable: %s", err)
+		} else {
+			c.maxTenants = i
+		}
+	}
+
+	if e := os.Getenv("REFRESH_INTERVAL"); e != "" {
+		d, err := time.ParseDuration(e)
+		if err != nil {
+			log.Printf("Could not parse REFRESH_INTERVAL environment variable: %s", err)
+		} else {
+			c.refreshInterval = d
+		}
+	}
+
+	if e := os.Getenv("BREAKER_THRESHOLD"); e != "" {
+		i, err := strconv.Atoi(e)
+		if err != nil {
+			log.Printf("Could not parse BREAKER_THRESHOLD environment variable: %s", err)
+		} else {
+			c.breakerThreshold = i
+		}
+	}
+
+	if e := os.Getenv("METERS_PRECISION"); e != "" {
+		i, err := strconv.Atoi(e)
+		if err != nil {
+			log.Printf("Could not parse METERS_PRECISION environment variable: %s", err)
+		} else {
+			c.metersPrecision = i
+		}
+	}
+
+	if e := os.Getenv("SPEED_PRECISION"); e != "" {
+		i, err := strconv.Atoi(e)
+		if err != nil {
+			log.Printf("Could not parse SPEED_PRECISION environment variable: %s", err)
+		} else {
+			c.speedPrecision = i
+		}
+	}
+
+	if e := os.Getenv("MIN_SAMPLE_METERS"); e != "" {
+		f, err := strconv.ParseFloat(e, 64)
+		if err != nil {
+			log.Printf("Could not parse MIN_SAMPLE_METERS environment variable: %s", err)
+		} else {
+			c.minSampleMeters = f
+		}
+	}
+
+	if e := os.Getenv("MIN_SAMPLE_SPEED"); e != "" {
+		f, err := strconv.ParseFloat(e, 64)
+		if err != nil {
+			log.Printf("Could not parse MIN_SAMPLE_SPEED environment variable: %s", err)
+		} else {
+			c.minSampleSpeed = f
+		}
+	}
+
+	if e := os.Getenv("STRICT_DECODING"); e != "" {
+		if e == "1" || e == "yes" || e == "true" {
+			c.strictDecoding = true
+		} else {
+			c.strictDecoding = false
+		}
+	}
+
+	if e := os.Getenv("DERIVED_UNIT"); e != "" {
+		c.derivedUnit = e
+	}
+
+	if e := os.Getenv("DERIVED_FACTOR"); e != "" {
+		f, err := strconv.ParseFloat(e, 64)
+		if err != nil {
+			log.Printf("Could not parse DERIVED_FACTOR environment variable: %s", err)
+		} else {
+			c.derivedFactor = f
+		}
+	}
+
+	if e := os.Getenv("DERIVED_OFFSET"); e != "" {
+		f, err := strconv.ParseFloat(e, 64)
+		if err != nil {
+			log.Printf("Could not parse DERIVED_OFFSET environment variable: %s", err)
+		} else {
+			c.derivedOffset = f
+		}
+	}
+
+	if e := os.Getenv("MIRROR_PROJECT_IDS"); e != "" {
+		c.mirrorProjectIds = splitList(e)
+	}
+
+	if e := os.Getenv("STRICT_MIRRORING"); e != "" {
+		if e == "1" || e == "yes" || e == "true" {
+			c.strictMirroring = true
+		} else {
+			c.strictMirroring = false
+		}
+	}
+
+	if e := os.Getenv("DISPLAY_TIMEZONE"); e != "" {
+		c.displayTimezone = e
+	}
+
+	if e := os.Getenv("MAX_LIMIT"); e != "" {
+		i, err := strconv.Atoi(e)
+		if err != nil {
+			log.Printf("Could not parse MAX_LIMIT environment variable: %s", err)
+		} else {
+			c.maxLimit = i
+		}
+	}
+
 	// Try to automatically determine project ID when necessary
 	if c.projectId == fakeProjectId {
 		if e := os.Getenv("PORT"); e != "" {
@@ -119,11 +431,107 @@ func (c Config) Print() {
 	log.Printf("Listen port:  %d", c.port)
 	log.Printf("Project ID:   %s", c.projectId)
 	log.Printf("API password: %s", pwd)
+	log.Printf("Buffering:    %s", c.bufferInterval)
+	log.Printf("Log level:    %s", c.logLevel)
+	if len(c.mirrorProjectIds) > 0 {
+		log.Printf("Mirrors:      %s", strings.Join(c.mirrorProjectIds, ", "))
+	}
+}
+
+// Reads the tenants from a JSON file with a list of {"host", "projectId", "apiAuth", "mirrorProjectIds"} objects, the
+// mirrors being optional
+func loadTenants(path string) ([]server.Tenant, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var tenants []server.Tenant
+	err = json.Unmarshal(data, &tenants)
+	if err != nil {
+		return nil, err
+	}
+
+	return tenants, nil
+}
+
+func (c Config) configure(srv *server.Server) {
+	if c.bufferInterval > 0 {
+		srv.EnableBuffering(c.bufferInterval, c.bufferSize)
+	}
+	if c.coalesce {
+		srv.EnableCoalescing()
+	}
+	srv.SetSignedMeters(c.signedMeters)
+	srv.SetSampleThreshold(float32(c.minSampleMeters), float32(c.minSampleSpeed))
+	srv.SetStrictDecoding(c.strictDecoding)
+	srv.SetDerivedUnit(c.derivedUnit, float32(c.derivedFactor), float32(c.derivedOffset))
+	srv.SetStrictMirroring(c.strictMirroring)
+	srv.SetMaxLimit(c.maxLimit)
+	srv.SetDisplayLocation(c.displayLocation)
+	srv.SetReadChunkSize(c.readChunkSize)
+	srv.SetIntegerStorage(c.integerStorage)
+	srv.SetFakeDataInterval(c.fakeDataInterval)
+	srv.EnableAdmin(c.adminAuth)
+	if c.minuteDetail {
+		srv.EnableMinuteDetail()
+	}
+	if c.refreshInterval > 0 {
+		srv.EnableRefresh(c.refreshInterval)
+	}
+	if c.breakerThreshold > 0 {
+		srv.EnableCircuitBreaker(c.breakerThreshold)
+	}
+	if c.metersPrecision >= 0 || c.speedPrecision >= 0 {
+		srv.SetPrecision(c.metersPrecision, c.speedPrecision)
+	}
+	if c.alertSpeed > 0 && c.alertWebhook != "" {
+		srv.EnableSpeedAlerts(float32(c.alertSpeed), c.alertWebhook)
+	}
 }
 
 func main() {
 	config := parseConfig()
 
+	err := server.SetLogLevel(config.logLevel)
+	if err != nil {
+		log.Printf("Invalid log level %s: %s", config.logLevel, err)
+	}
+
+	config.displayLocation, err = time.LoadLocation(config.displayTimezone)
+	if err != nil {
+		print(fmt.Sprintf("Could not load display timezone %q: %s. Aborting.", config.displayTimezone, err))
+		os.Exit(1)
+	}
+
+	listenAddr := fmt.Sprintf("%s:%d", config.host, config.port)
+
+	if config.tenantsFile != "" {
+		tenants, err := loadTenants(config.tenantsFile)
+		if err != nil {
+			print(fmt.Sprintf("Could not load tenants from %s: %s. Aborting.", config.tenantsFile, err))
+			os.Exit(1)
+		}
+
+		if !config.dev {
+			for _, t := range tenants {
+				if t.ApiAuth == "" {
+					print(fmt.Sprintf("Not in development mode and no API password set for %s. Aborting.", t.Host))
+					os.Exit(1)
+				}
+			}
+		}
+
+		if len(config.mirrorProjectIds) > 0 {
+			print("Mirrors are set for each tenant with mirrorProjectIds in the tenants file. Aborting.")
+			os.Exit(1)
+		}
+
+		ms := server.NewMultiServer(config.dev, tenants, config.maxTenants, config.configure)
+		ms.Run(listenAddr, config.fakeData)
+		return
+	}
+
 	if !config.dev {
 		if config.apiAuth == "" {
 			print("Not in development mode and no API password set. Aborting.")
@@ -136,5 +544,9 @@ func main() {
 	}
 
 	srv := server.NewServer(config.dev, config.projectId, config.apiAuth)
-	srv.Run(fmt.Sprintf("%s:%d", config.host, config.port), config.fakeData)
+	config.configure(srv)
+	for _, projectId := range config.mirrorProjectIds {
+		srv.AddMirror(projectId)
+	}
+	srv.Run(listenAddr, config.fakeData)
 }
@@ -2,6 +2,9 @@ package godometer
 
 const APITimeLayout = "2006-01-02 15:04"
 
+// The JSON keys are the wire format used by the monitor, the API and the frontend, so they must not change even if
+// the fields are renamed: ts is the minute in APITimeLayout, m the distance in meters, mps the speed in meters per
+// second and kph the speed in kilometers per hour.
 type UpdateDataPoint struct {
 	Timestamp         string  `json:"ts"`
 	Meters            float32 `json:"m"`
@@ -9,9 +9,14 @@ require (
 	github.com/gin-contrib/pprof v1.3.0
 	github.com/gin-contrib/zap v0.0.1
 	github.com/gin-gonic/gin v1.6.3
+	github.com/golang/protobuf v1.4.2
 	github.com/tommy351/zap-stackdriver v0.1.4
 	github.com/unrolled/secure v1.0.8
 	github.com/warthog618/gpiod v0.5.0
+	go.opentelemetry.io/otel v0.11.0
 	go.uber.org/zap v1.15.0
+	google.golang.org/api v0.30.0
+	google.golang.org/genproto v0.0.0-20200815001618-f69a88009b70
+	google.golang.org/grpc v1.31.0
 	golang.org/x/sys v0.0.0-20200819171115-d785dc25833f // indirect
 )
@@ -127,7 +127,7 @@ func (gm *GPIOMonitor) Monitor(exit chan bool) {
 	defer func() {
 		err := l.Close()
 		if err != nil {
-			log.Printf("Error closing chip %s pin %s: %s", gm.device, gm.pin, err)
+			log.Printf("Error closing chip %s pin %d: %s", gm.device, gm.pin, err)
 		}
 	}()
 
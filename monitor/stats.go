@@ -2,17 +2,16 @@ package monitor
 
 import (
 	"bufio"
-	"bytes"
+	"context"
 	"encoding/json"
-	"fmt"
 	"io/ioutil"
 	"log"
-	"net/http"
 	"os"
 	"sync"
 	"time"
 
 	"github.com/lietu/godometer"
+	"github.com/lietu/godometer/client"
 )
 
 const statsDebug = false
@@ -23,7 +22,8 @@ const averageOverMeasurements = 3
 // Store and send this many dataPoints to ensure they get eventually delivered
 const keepPastDataPoints = 5
 
-var utc, _ = time.LoadLocation("UTC")
+// The built-in UTC, unlike time.LoadLocation it doesn't need tzdata so it works in stripped down images too
+var utc = time.UTC
 
 type FileDataPoint struct {
 	Timestamp         string  `json:"ts"`
@@ -58,6 +58,7 @@ type StatsMonitor struct {
 	results             chan GPIORecord
 	apiBaseUrl          string
 	apiAuth             string
+	client              *client.Client
 	dbPath              string
 	metersTraveled      float64
 	totalMetersTraveled float64
@@ -74,6 +75,7 @@ func NewStatsMonitor(results chan GPIORecord, dbPath string, apiBaseUrl string,
 	sm.dbPath = dbPath
 	sm.apiBaseUrl = apiBaseUrl
 	sm.apiAuth = apiAuth
+	sm.client = client.NewClient(apiBaseUrl, apiAuth)
 	sm.metersTraveled = 0.0
 	sm.totalMetersTraveled = 0.0
 	sm.currentMPS = 0.0
@@ -235,25 +237,9 @@ func (sm *StatsMonitor) saveStats() {
 		KilometersPerHour: float32(avgKPH),
 	}
 
-	latestAdded := false
-	dataPoints := []FileDataPoint{}
-	for _, r := range sm.stats.dataPoints {
-		if r.Timestamp == latest.Timestamp {
-			// Same timestamp from past records, update instead of creating a new one.
-			// Can easily happen if restarting quickly.
-			r.Meters = r.Meters + latest.Meters
-			r.MetersPerSecond = (r.MetersPerSecond + latest.MetersPerSecond) / 2
-			r.KilometersPerHour = (r.KilometersPerHour + latest.KilometersPerHour) / 2
-			latestAdded = true
-			dataPoints = append(dataPoints, r)
-		} else {
-			dataPoints = append(dataPoints, r)
-		}
-	}
-
-	if !latestAdded {
-		dataPoints = append(dataPoints, latest)
-	}
+	// A minute that already has a data point, e.g. after restarting quickly, gets another one. The server adds up
+	// different data points for the same minute, while resending a changed one would count it twice.
+	dataPoints := append(sm.stats.dataPoints, latest)
 
 	sm.stats = NewStatsData()
 	keepFrom := 0
@@ -285,36 +271,14 @@ func (sm *StatsMonitor) reportStats(fdps []FileDataPoint) {
 		adps = append(adps, fdp.toAPIDataPoint())
 	}
 
-	payload := godometer.UpdateStatsRequest{DataPoints: adps}
-	body, err := json.Marshal(payload)
-	if err != nil {
-		log.Printf("Failed to marshal request POST data: %s. Could not report stats.", err)
-		return
-	}
-
-	url := fmt.Sprintf("%s/api/v1/updateStats", sm.apiBaseUrl)
-	request, err := http.NewRequest("POST", url, bytes.NewBuffer(body))
+	err := sm.client.Push(context.Background(), adps)
 	if err != nil {
-		log.Printf("Failed to initialize POST request: %s. Could not report stats.", err)
+		log.Printf("API error reporting stats to %s: %s", sm.apiBaseUrl, err)
 		return
 	}
 
-	request.Header.Set("Content-Type", "application/json")
-	request.Header.Set("Authorization", sm.apiAuth)
-
-	client := http.Client{Timeout: 10 * time.Second}
-	resp, err := client.Do(request)
-	if err != nil {
-		log.Printf("API error reporting stats to %s: %s", url, err)
-		return
-	}
-
-	if resp.StatusCode != 200 {
-		log.Printf("API returned status %d reporting stats to %s. This is likely not a good sign.", resp.StatusCode, url)
-	}
-
 	if statsDebug {
-		log.Printf("Updated %d dataPoints of data to %s", len(fdps), url)
+		log.Printf("Updated %d dataPoints of data to %s", len(fdps), sm.apiBaseUrl)
 	}
 }
 
@@ -0,0 +1,109 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+	"google.golang.org/api/iterator"
+)
+
+type ResetResponse struct {
+	Deleted int `json:"deleted"`
+}
+
+// Admin endpoints are only available with a separate password
+func (s *Server) EnableAdmin(adminAuth string) {
+	if adminAuth == "" {
+		return
+	}
+
+	admin := s.engine.Group("/api/v1/admin", AuthRequired(adminAuth))
+	admin.POST("/reset", s.resetStats)
+	admin.POST("/import", s.importStats)
+	admin.POST("/reload", s.reloadStats)
+	admin.DELETE("/records/:period", s.deleteRecordRange)
+	admin.DELETE("/records/:period/:id", s.deleteRecord)
+}
+
+func (s *Server) resetStats(c *gin.Context) {
+	// Make it a bit harder to do by accident
+	if c.Query("confirm") != "yes" {
+		err := fmt.Errorf("resetting all stats requires ?confirm=yes")
+		_ = c.AbortWithError(http.StatusBadRequest, err)
+		return
+	}
+
+	deleted, err := s.reset(c.Request.Context())
+	if err != nil {
+		logger.Warn("Failed to reset stats", zap.Int("deleted", deleted), zap.Error(err))
+		_ = c.AbortWithError(errorStatus(err), err)
+		return
+	}
+
+	c.JSON(200, ResetResponse{Deleted: deleted})
+}
+
+// Deletes all the records, events, minute details and idempotency keys from the DB, and clears them from memory
+func (s *Server) reset(ctx context.Context) (int, error) {
+	s.writeMutex.Lock()
+	defer s.writeMutex.Unlock()
+
+	collections := append([]string{}, periods...)
+	collections = append(collections, "events", "minutedetail", "idempotency")
+
+	deleted := 0
+	for _, collection := range collections {
+		iter := s.db.Collection(collectionName(collection)).DocumentRefs(ctx)
+
+		batch := s.batch()
+		batchRecords := 0
+		for {
+			ref, err := iter.Next()
+			if err == iterator.Done {
+				break
+			}
+			if err != nil {
+				return deleted, storageError(err)
+			}
+
+			batch.Delete(ref)
+			batchRecords += 1
+			if batchRecords == maxBatchWrites {
+				_, err := batch.Commit(ctx)
+				if err != nil {
+					return deleted, storageError(err)
+				}
+				deleted += batchRecords
+				batch = s.batch()
+				batchRecords = 0
+			}
+		}
+
+		if batchRecords > 0 {
+			_, err := batch.Commit(ctx)
+			if err != nil {
+				return deleted, storageError(err)
+			}
+			deleted += batchRecords
+		}
+	}
+
+	if s.buffer != nil {
+		s.buffer.mutex.Lock()
+		s.buffer.dataPoints = nil
+		s.buffer.mutex.Unlock()
+	}
+
+	s.idempotency.mutex.Lock()
+	s.idempotency.keys = map[string]IdempotencyRecord{}
+	s.idempotency.mutex.Unlock()
+
+	s.initRecords(s.clock.Now())
+	s.lastEvents = []ResponseDataPoint{}
+
+	logger.Info("Reset all stats", zap.Int("deleted", deleted))
+	return deleted, nil
+}
@@ -0,0 +1,68 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/lietu/godometer"
+)
+
+const testAdminAuth = "admin-secret"
+
+func adminRequest(srv *Server, method string, path string, body interface{}) int {
+	return request(srv, method, path, body, map[string]string{"Authorization": testAdminAuth}).Code
+}
+
+func TestReset(t *testing.T) {
+	srv, fake, _ := newTestServer(t, testNow)
+	srv.EnableAdmin(testAdminAuth)
+	srv.EnableMinuteDetail()
+
+	dp := godometer.UpdateDataPoint{Timestamp: testMinute(testNow), Meters: 100, MetersPerSecond: 2, KilometersPerHour: 7.2}
+	if status, _ := postStatsWithKey(srv, "before-reset", dp); status != http.StatusOK {
+		t.Fatalf("Update failed with %d", status)
+	}
+	// Something aged out of memory into the minute detail
+	if err := srv.compactMinutes(context.Background(), map[string]DBDataPoint{"2024-03-06 10:00": {Counter: 1, Meters: 10}}); err != nil {
+		t.Fatalf("Compacting failed: %s", err)
+	}
+
+	if status := request(srv, http.MethodPost, "/api/v1/admin/reset?confirm=yes", nil, nil).Code; status != http.StatusForbidden {
+		t.Errorf("Reset without admin auth returned %d, expected 403", status)
+	}
+	if status := adminRequest(srv, http.MethodPost, "/api/v1/admin/reset", nil); status != http.StatusBadRequest {
+		t.Errorf("Reset without confirmation returned %d, expected 400", status)
+	}
+	if status := adminRequest(srv, http.MethodPost, "/api/v1/admin/reset?confirm=yes", nil); status != http.StatusOK {
+		t.Fatalf("Reset failed with %d", status)
+	}
+
+	for _, collection := range append(periods, "events", "minutedetail", "idempotency") {
+		if ids := fake.ids(collection); len(ids) != 0 {
+			t.Errorf("%s still has %v", collection, ids)
+		}
+	}
+
+	response := StatsResponse{}
+	getJSON(t, srv, "/api/v1/stats/hours", &response)
+	for _, rdp := range response.DataPoints {
+		if rdp.Meters != 0 {
+			t.Errorf("Hour %s wasn't reset: %+v", rdp.Timestamp, rdp)
+		}
+	}
+
+	events := EventsResponse{}
+	getJSON(t, srv, "/api/v1/stats/events", &events)
+	if len(events.Events) != 0 {
+		t.Errorf("Events weren't reset: %v", events.Events)
+	}
+
+	// The same request is new again
+	if status, replayed := postStatsWithKey(srv, "before-reset", dp); status != http.StatusOK || replayed {
+		t.Errorf("Request after reset returned %d replayed %v, expected it processed", status, replayed)
+	}
+	if row := srv.hours["2024-03-06 12"]; row.Meters != 100 {
+		t.Errorf("Hour is %s after the reset, expected 100m", recordStr(row))
+	}
+}
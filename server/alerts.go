@@ -0,0 +1,97 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/lietu/godometer"
+)
+
+// A sustained sprint only alerts once, and even then at most this often
+const alertInterval = time.Minute
+
+const alertTimeout = 10 * time.Second
+
+type SpeedAlert struct {
+	Timestamp         string  `json:"ts"`
+	Meters            float32 `json:"m"`
+	KilometersPerHour float32 `json:"kph"`
+	Threshold         float32 `json:"threshold"`
+}
+
+type speedAlerts struct {
+	thresholdKPH float32
+	webhookUrl   string
+	client       http.Client
+	above        bool
+	lastSent     time.Time
+}
+
+// POSTs a SpeedAlert to the webhook when an update goes over the given speed
+func (s *Server) EnableSpeedAlerts(thresholdKPH float32, webhookUrl string) {
+	s.alerts = &speedAlerts{
+		thresholdKPH: thresholdKPH,
+		webhookUrl:   webhookUrl,
+		client:       http.Client{Timeout: alertTimeout},
+	}
+}
+
+// Called from writeStats, so no need for extra locking
+func (s *Server) checkSpeedAlert(udp godometer.UpdateDataPoint) {
+	a := s.alerts
+	if a == nil {
+		return
+	}
+
+	if udp.KilometersPerHour <= a.thresholdKPH {
+		a.above = false
+		return
+	}
+
+	if a.above {
+		return
+	}
+	a.above = true
+
+	now := s.clock.Now()
+	if !a.lastSent.IsZero() && now.Sub(a.lastSent) < alertInterval {
+		return
+	}
+	a.lastSent = now
+
+	alert := SpeedAlert{
+		Timestamp:         udp.Timestamp,
+		Meters:            udp.Meters,
+		KilometersPerHour: udp.KilometersPerHour,
+		Threshold:         a.thresholdKPH,
+	}
+
+	// Don't hold up saving the stats for this
+	go a.send(alert)
+}
+
+func (a *speedAlerts) send(alert SpeedAlert) {
+	body, err := json.Marshal(alert)
+	if err != nil {
+		logger.Warn("Failed to marshal speed alert", zap.Error(err))
+		return
+	}
+
+	resp, err := a.client.Post(a.webhookUrl, "application/json", bytes.NewBuffer(body))
+	if err != nil {
+		logger.Warn("Failed to send speed alert", zap.String("url", a.webhookUrl), zap.Error(err))
+		return
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		logger.Warn("Speed alert webhook returned an error", zap.String("url", a.webhookUrl), zap.Int("status", resp.StatusCode))
+		return
+	}
+
+	logger.Info("Sent speed alert", zap.String("timestamp", alert.Timestamp), zap.Float32("kph", alert.KilometersPerHour))
+}
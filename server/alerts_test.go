@@ -0,0 +1,95 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/lietu/godometer"
+)
+
+func TestSpeedAlertSentOnceForSustainedSprint(t *testing.T) {
+	alerts := make(chan SpeedAlert, 10)
+	webhook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		alert := SpeedAlert{}
+		if err := json.NewDecoder(r.Body).Decode(&alert); err != nil {
+			t.Errorf("Failed to parse alert: %s", err)
+		}
+		alerts <- alert
+	}))
+	defer webhook.Close()
+
+	srv, _, _ := newTestServer(t, testNow)
+	srv.EnableSpeedAlerts(15, webhook.URL)
+
+	err := srv.writeStats(context.Background(), []godometer.UpdateDataPoint{
+		{Timestamp: "2024-03-06 12:26", Meters: 100, MetersPerSecond: 1.7, KilometersPerHour: 6},
+		{Timestamp: "2024-03-06 12:27", Meters: 300, MetersPerSecond: 5, KilometersPerHour: 18},
+		{Timestamp: "2024-03-06 12:28", Meters: 320, MetersPerSecond: 5.3, KilometersPerHour: 19.2},
+		{Timestamp: "2024-03-06 12:29", Meters: 310, MetersPerSecond: 5.2, KilometersPerHour: 18.6},
+	})
+	if err != nil {
+		t.Fatalf("writeStats failed: %s", err)
+	}
+
+	select {
+	case alert := <-alerts:
+		expected := SpeedAlert{Timestamp: "2024-03-06 12:27", Meters: 300, KilometersPerHour: 18, Threshold: 15}
+		if alert != expected {
+			t.Errorf("Got alert %+v, expected %+v", alert, expected)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("No alert was sent")
+	}
+
+	select {
+	case alert := <-alerts:
+		t.Errorf("Got a second alert for the same sprint: %+v", alert)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestSpeedAlertOnlyAfterCommit(t *testing.T) {
+	alerts := make(chan SpeedAlert, 10)
+	webhook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		alert := SpeedAlert{}
+		if err := json.NewDecoder(r.Body).Decode(&alert); err != nil {
+			t.Errorf("Failed to parse alert: %s", err)
+		}
+		alerts <- alert
+	}))
+	defer webhook.Close()
+
+	srv, fake, _ := newTestServer(t, testNow)
+	srv.EnableSpeedAlerts(15, webhook.URL)
+	sprint := []godometer.UpdateDataPoint{{Timestamp: "2024-03-06 12:27", Meters: 300, MetersPerSecond: 5, KilometersPerHour: 18}}
+
+	fake.fail("2024-03-06 12:27")
+	if err := srv.writeStats(context.Background(), sprint); err == nil {
+		t.Fatalf("Expected the commit to fail")
+	}
+	select {
+	case alert := <-alerts:
+		t.Fatalf("Got an alert for a failed commit: %+v", alert)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	// The retry is the first time it's saved, and the only alert
+	fake.recover()
+	if err := srv.writeStats(context.Background(), sprint); err != nil {
+		t.Fatalf("Retry failed: %s", err)
+	}
+	select {
+	case <-alerts:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("No alert was sent after the retry")
+	}
+	select {
+	case alert := <-alerts:
+		t.Errorf("Got a second alert for the retried sprint: %+v", alert)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
@@ -2,12 +2,19 @@ package server
 
 import (
 	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"log"
 	"math"
 	"net/http"
+	"os"
+	"os/signal"
 	"path/filepath"
+	"sync"
+	"syscall"
 	"time"
 
 	stackdriver "github.com/tommy351/zap-stackdriver"
@@ -18,18 +25,38 @@ import (
 	ginzap "github.com/gin-contrib/zap"
 	"go.uber.org/zap"
 
+	"cloud.google.com/go/firestore"
 	"github.com/gin-gonic/gin"
 	"github.com/lietu/godometer"
+	"go.opentelemetry.io/otel/api/global"
+	"go.opentelemetry.io/otel/api/propagation"
+	"go.opentelemetry.io/otel/api/trace"
 )
 
 const frontend = "../../frontend/public"
 
-var prevFakeMeters = 0.0
-
 const maxFakeMeters = 175.0
 
+const defaultEventHistoryLimit = 20
+
+const shutdownTimeout = 10 * time.Second
+
+// Log the first 10 identical commit messages each second, then every 100th
+const (
+	commitLogFirst      = 10
+	commitLogThereafter = 100
+)
+
+// Can be changed at runtime with SetLogLevel
+var logLevel = zap.NewAtomicLevelAt(zapcore.InfoLevel)
+
 var logger = getLogger()
 
+// For messages logged on every commit, so bursts of updates don't flood the logs
+var commitLogger = logger.WithOptions(zap.WrapCore(func(core zapcore.Core) zapcore.Core {
+	return zapcore.NewSampler(core, time.Second, commitLogFirst, commitLogThereafter)
+}))
+
 // YYYY-MM-DD HH:MM - we mostly want per minute precision
 const (
 	minuteLayout = godometer.APITimeLayout
@@ -39,12 +66,23 @@ const (
 	yearLayout   = "2006"
 )
 
-// Timestamp is key, need counter for updating averages
+// Timestamp is key, need counter for updating averages. Distance is added up in Millimeters so the total
+// doesn't drift, Meters is derived from it. The original fields are stored under their Go names, as that's what the
+// documents written before the struct tags worked have.
 type DBDataPoint struct {
-	Counter           int64   `json:"c",firestore:"counter"`
-	Meters            float32 `json:"m",firestore:"meters"`
-	MetersPerSecond   float32 `json:"mps",firestore:"mps"`
-	KilometersPerHour float32 `json:"kph",firestore:"kph"`
+	SchemaVersion        int     `json:"-" firestore:"schemaVersion"`
+	Counter              int64   `json:"c" firestore:"Counter"`
+	Meters               float32 `json:"m" firestore:"Meters"`
+	MetersPerSecond      float32 `json:"mps" firestore:"MetersPerSecond"`
+	KilometersPerHour    float32 `json:"kph" firestore:"KilometersPerHour"`
+	MovingSeconds        float32 `json:"-" firestore:"movingSeconds"`
+	Millimeters          int64   `json:"-" firestore:"mm"`
+	MillimetersPerSecond int64   `json:"-" firestore:"mmps"`
+	Integer              bool    `json:"-" firestore:"int,omitempty"`
+	// Comma separated ids of the events added to a minute record, so resends are recognized after a restart too
+	Events string `json:"-" firestore:"events,omitempty"`
+	// Whether there's a document for the record, zero-filled ones don't have one yet
+	Exists bool `json:"-" firestore:"-"`
 }
 
 func (ddp *DBDataPoint) toResponseDataPoint(ts string) ResponseDataPoint {
@@ -54,41 +92,109 @@ func (ddp *DBDataPoint) toResponseDataPoint(ts string) ResponseDataPoint {
 		Meters:            ddp.Meters,
 		MetersPerSecond:   ddp.MetersPerSecond,
 		KilometersPerHour: ddp.KilometersPerHour,
-	}
+	}.withPace()
 }
 
+// Same keys as godometer.UpdateDataPoint, plus c for how many updates the bucket has. The derived pace,
+// interpolated and the configured derived unit are left out when they don't apply.
 type ResponseDataPoint struct {
-	Counter           int64   `json:"c"`
-	Timestamp         string  `json:"ts"`
-	Meters            float32 `json:"m"`
-	MetersPerSecond   float32 `json:"mps"`
-	KilometersPerHour float32 `json:"kph"`
+	Counter           int64         `json:"c"`
+	Timestamp         string        `json:"ts"`
+	Meters            float32       `json:"m"`
+	MetersPerSecond   float32       `json:"mps"`
+	KilometersPerHour float32       `json:"kph"`
+	PaceMinPerKm      *float32      `json:"pace,omitempty" firestore:"-"`
+	Interpolated      bool          `json:"interpolated,omitempty" firestore:"-"`
+	Derived           *DerivedValue `json:"derived,omitempty" firestore:"-"`
+}
+
+// Pace is derived on the fly, standing still has no pace so it's left out
+func (rdp ResponseDataPoint) withPace() ResponseDataPoint {
+	rdp.PaceMinPerKm = paceMinPerKm(rdp.MetersPerSecond)
+	return rdp
+}
+
+func paceMinPerKm(mps float32) *float32 {
+	if mps <= 0 || math.IsNaN(float64(mps)) || math.IsInf(float64(mps), 0) {
+		return nil
+	}
+
+	pace := 1000.0 / mps / 60.0
+	return &pace
 }
 
 type EventsResponse struct {
 	Events []ResponseDataPoint `json:"events"`
 }
 
+type EventHistoryResponse struct {
+	Events []ResponseDataPoint `json:"events"`
+	Next   string              `json:"next"`
+}
+
 type StatsResponse struct {
 	EventTimestamps []string            `json:"eventTimestamps"`
 	DataPoints      []ResponseDataPoint `json:"dataPoints"`
+	Activity        BucketActivity      `json:"activity"`
+	ReadOnly        bool                `json:"readOnly"`
+}
+
+// How many of the buckets in the period had any updates, e.g. 14 of 24 hours
+type BucketActivity struct {
+	Active int `json:"active"`
+	Total  int `json:"total"`
 }
 
 type Server struct {
-	projectId  string
-	lastEvents []ResponseDataPoint
-	minutes    map[string]DBDataPoint
-	hours      map[string]DBDataPoint
-	days       map[string]DBDataPoint
-	weeks      map[string]DBDataPoint
-	months     map[string]DBDataPoint
-	years      map[string]DBDataPoint
-	engine     *gin.Engine
+	projectId        string
+	db               *firestore.Client
+	tracer           trace.Tracer
+	clock            Clock
+	lastEvents       []ResponseDataPoint
+	minutes          map[string]DBDataPoint
+	hours            map[string]DBDataPoint
+	days             map[string]DBDataPoint
+	weeks            map[string]DBDataPoint
+	months           map[string]DBDataPoint
+	years            map[string]DBDataPoint
+	engine           *gin.Engine
+	httpServer       *http.Server
+	buffer           *eventBuffer
+	coalesce         bool
+	alerts           *speedAlerts
+	idempotency      *idempotencyStore
+	signedMeters     bool
+	minuteDetail     bool
+	readChunkSize    int
+	integerStorage   bool
+	metrics          *serverMetrics
+	modified         map[string]time.Time
+	refreshInterval  time.Duration
+	stopRefresh      context.CancelFunc
+	refreshDone      chan bool
+	breaker          *commitBreaker
+	stopWindows      context.CancelFunc
+	windowsDone      chan bool
+	precision        *precision
+	sampleThreshold  sampleThreshold
+	startedAt        time.Time
+	strictDecoding   bool
+	derivedUnit      *derivedUnit
+	mirrors          []*firestore.Client
+	newClient        func(ctx context.Context, projectId string) *firestore.Client
+	strictMirroring  bool
+	displayLocation  *time.Location
+	maxLimit         int
+	fakeDataInterval time.Duration
+	fakeMeters       float64
+	stopFakeData     context.CancelFunc
+	fakeDataDone     chan bool
+	writeMutex       sync.RWMutex
 }
 
 func getLogger() *zap.Logger {
 	config := &zap.Config{
-		Level:            zap.NewAtomicLevelAt(zapcore.DebugLevel),
+		Level:            logLevel,
 		Encoding:         "json",
 		EncoderConfig:    stackdriver.EncoderConfig,
 		OutputPaths:      []string{"stdout"},
@@ -102,7 +208,7 @@ func getLogger() *zap.Logger {
 	}), zap.Fields(
 		stackdriver.LogServiceContext(&stackdriver.ServiceContext{
 			Service: "foo",
-			Version: "bar",
+			Version: Version,
 		}),
 	))
 	if err != nil {
@@ -112,6 +218,23 @@ func getLogger() *zap.Logger {
 	return zapLogger
 }
 
+// One of debug, info, warn or error. Debug also dumps the records after every write.
+func SetLogLevel(level string) error {
+	return logLevel.UnmarshalText([]byte(level))
+}
+
+func errorStatus(err error) int {
+	if errors.Is(err, ErrNotFound) {
+		return http.StatusNotFound
+	}
+
+	if errors.Is(err, ErrStorageUnavailable) {
+		return http.StatusServiceUnavailable
+	}
+
+	return http.StatusInternalServerError
+}
+
 func weekFormat(ts time.Time) string {
 	year, week := ts.ISOWeek()
 	return fmt.Sprintf("%d week %d", year, week)
@@ -126,28 +249,78 @@ func (s *Server) updateStats(c *gin.Context) {
 		return
 	}
 
-	ctx := context.Background()
-	s.writeStats(ctx, req.DataPoints)
+	if !s.breaker.allow(s.clock.Now()) {
+		logger.Warn("Rejecting update, commits are failing")
+		c.Header("Retry-After", retryAfter())
+		_ = c.AbortWithError(http.StatusServiceUnavailable, ErrStorageUnavailable)
+		return
+	}
+
+	ctx := propagation.ExtractHTTP(c.Request.Context(), global.Propagators(), c.Request.Header)
+
+	key := c.GetHeader(idempotencyHeader)
+	if key != "" {
+		if !validIdempotencyKey(key) {
+			logger.Warn("Invalid idempotency key", zap.String("key", key))
+			c.AbortWithStatus(http.StatusBadRequest)
+			return
+		}
+
+		record, err := s.startIdempotent(ctx, key)
+		if err != nil {
+			logger.Warn("Failed to check idempotency key", zap.String("key", key), zap.Error(err))
+			_ = c.AbortWithError(errorStatus(err), err)
+			return
+		}
+
+		if record != nil {
+			if record.Status == 0 {
+				c.AbortWithStatus(http.StatusConflict)
+				return
+			}
+
+			logger.Info("Already processed request", zap.String("key", key))
+			c.Header("Idempotent-Replayed", "true")
+			c.Status(record.Status)
+			return
+		}
+	}
+
+	status := http.StatusOK
+	if s.buffer != nil {
+		s.bufferDataPoints(req.DataPoints)
+	} else {
+		err = s.writeStats(ctx, req.DataPoints)
+		if err != nil {
+			status = errorStatus(err)
+			_ = c.AbortWithError(status, err)
+		}
+	}
+
+	if key != "" {
+		s.finishIdempotent(ctx, key, status)
+	}
 }
 
-func getPeriodIds(period string) []string {
+func (s *Server) getPeriodIds(period string) []string {
+	now := s.clock.Now()
 	if period == "years" {
-		ids := Last4Years()
+		ids := Last4Years(now)
 		return ids[:]
 	} else if period == "months" {
-		ids := Last12Months()
+		ids := Last12Months(now)
 		return ids[:]
 	} else if period == "weeks" {
-		ids := Last5Weeks()
+		ids := Last5Weeks(now)
 		return ids[:]
 	} else if period == "days" {
-		ids := Last7Days()
+		ids := Last7Days(now)
 		return ids[:]
 	} else if period == "hours" {
-		ids := Last24Hours()
+		ids := Last24Hours(now)
 		return ids[:]
 	} else if period == "minutes" {
-		ids := Last60Minutes()
+		ids := Last60Minutes(now)
 		return ids[:]
 	}
 	logger.Warn("Invalid period", zap.String("period", period))
@@ -155,70 +328,160 @@ func getPeriodIds(period string) []string {
 }
 
 func (s *Server) returnEvents(c *gin.Context) {
+	events := []ResponseDataPoint{}
+	s.writeMutex.RLock()
+	for _, e := range s.lastEvents {
+		events = append(events, e.withPace())
+	}
+	s.writeMutex.RUnlock()
+
 	c.JSON(200, EventsResponse{
-		Events: s.lastEvents,
+		Events: s.formatResponses(events),
 	})
 }
 
-func (s *Server) returnRecords(period string) gin.HandlerFunc {
-	return func(c *gin.Context) {
-		var availableDataPoints map[string]DBDataPoint
-		if period == "years" {
-			availableDataPoints = s.years
-		} else if period == "months" {
-			availableDataPoints = s.months
-		} else if period == "weeks" {
-			availableDataPoints = s.weeks
-		} else if period == "days" {
-			availableDataPoints = s.days
-		} else if period == "hours" {
-			availableDataPoints = s.hours
-		} else if period == "minutes" {
-			availableDataPoints = s.minutes
-		} else {
-			logger.Warn("Invalid period", zap.String("period", period))
-			c.AbortWithStatus(http.StatusInternalServerError)
+func (s *Server) returnEventHistory(c *gin.Context) {
+	before := c.Query("before")
+	if before != "" {
+		_, err := time.Parse(minuteLayout, before)
+		if err != nil {
+			badRequest(c, fmt.Errorf("invalid before %q, expected %q", before, minuteLayout))
 			return
 		}
-		ids := getPeriodIds(period)
-
-		var events []ResponseDataPoint
-		for _, id := range ids {
-			var event ResponseDataPoint
-			adp, ok := availableDataPoints[id]
-			if ok {
-				event = ResponseDataPoint{
-					Counter:           1,
-					Timestamp:         id,
-					Meters:            adp.Meters,
-					MetersPerSecond:   adp.MetersPerSecond,
-					KilometersPerHour: adp.KilometersPerHour,
-				}
-			} else {
-				event = ResponseDataPoint{
-					Counter:           adp.Counter,
-					Timestamp:         id,
-					Meters:            0.0,
-					MetersPerSecond:   0.0,
-					KilometersPerHour: 0.0,
-				}
-			}
+	}
 
-			// Clean up in case broken data ends up in DB
-			if math.IsNaN(float64(event.Meters)) {
-				event.Meters = 0
-			}
+	limit, err := s.queryLimit(c, defaultEventHistoryLimit)
+	if err != nil {
+		badRequest(c, err)
+		return
+	}
 
-			if math.IsNaN(float64(event.MetersPerSecond)) {
-				event.MetersPerSecond = 0
-			}
+	events, err := s.readEventHistory(c.Request.Context(), before, limit)
+	if err != nil {
+		_ = c.AbortWithError(errorStatus(err), err)
+		return
+	}
+
+	next := ""
+	if len(events) == limit {
+		next = events[len(events)-1].Timestamp
+	}
+
+	c.JSON(200, EventHistoryResponse{
+		Events: s.formatResponses(events),
+		Next:   next,
+	})
+}
+
+// The records of the period in memory, not a copy. Caller must hold writeMutex.
+func (s *Server) periodRecords(period string) (map[string]DBDataPoint, bool) {
+	if period == "years" {
+		return s.years, true
+	} else if period == "months" {
+		return s.months, true
+	} else if period == "weeks" {
+		return s.weeks, true
+	} else if period == "days" {
+		return s.days, true
+	} else if period == "hours" {
+		return s.hours, true
+	} else if period == "minutes" {
+		return s.minutes, true
+	}
+	logger.Warn("Invalid period", zap.String("period", period))
+	return nil, false
+}
 
-			if math.IsNaN(float64(event.KilometersPerHour)) {
-				event.KilometersPerHour = 0
+// Data points for the whole period in ascending order. Caller must hold writeMutex.
+func (s *Server) periodDataPoints(period string) ([]ResponseDataPoint, bool) {
+	availableDataPoints, ok := s.periodRecords(period)
+	if !ok {
+		return nil, false
+	}
+	ids := s.getPeriodIds(period)
+
+	var events []ResponseDataPoint
+	for _, id := range ids {
+		var event ResponseDataPoint
+		adp, ok := availableDataPoints[id]
+		if ok {
+			event = ResponseDataPoint{
+				Counter:           adp.Counter,
+				Timestamp:         id,
+				Meters:            adp.Meters,
+				MetersPerSecond:   adp.MetersPerSecond,
+				KilometersPerHour: adp.KilometersPerHour,
+			}
+		} else {
+			event = ResponseDataPoint{
+				Counter:           adp.Counter,
+				Timestamp:         id,
+				Meters:            0.0,
+				MetersPerSecond:   0.0,
+				KilometersPerHour: 0.0,
 			}
+		}
+
+		// Clean up in case broken data ends up in DB
+		if math.IsNaN(float64(event.Meters)) {
+			event.Meters = 0
+		}
+
+		if math.IsNaN(float64(event.MetersPerSecond)) {
+			event.MetersPerSecond = 0
+		}
+
+		if math.IsNaN(float64(event.KilometersPerHour)) {
+			event.KilometersPerHour = 0
+		}
+
+		events = append(events, event.withPace())
+	}
+
+	return events, true
+}
 
-			events = append(events, event)
+// Caller must hold writeMutex
+func (s *Server) bucketActivity(period string) (BucketActivity, bool) {
+	records, ok := s.periodRecords(period)
+	if !ok {
+		return BucketActivity{}, false
+	}
+
+	var activity BucketActivity
+	for _, id := range s.getPeriodIds(period) {
+		activity.Total++
+		if records[id].Counter > 0 {
+			activity.Active++
 		}
+	}
+
+	return activity, true
+}
+
+func (s *Server) returnRecords(period string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		// Read everything under one lock, so the data points, activity and modification time all agree
+		s.writeMutex.RLock()
+		events, ok := s.periodDataPoints(period)
+		if !ok {
+			s.writeMutex.RUnlock()
+			c.AbortWithStatus(http.StatusInternalServerError)
+			return
+		}
+
+		events, err := s.maybeInterpolate(c, period, events)
+		if err != nil {
+			s.writeMutex.RUnlock()
+			badRequest(c, err)
+			return
+		}
+
+		activity, _ := s.bucketActivity(period)
+		lastModified := s.periodLastModified(period)
+		s.writeMutex.RUnlock()
+
+		events = s.formatResponses(events)
 
 		var timestamps []string
 		for _, e := range events {
@@ -228,24 +491,223 @@ func (s *Server) returnRecords(period string) gin.HandlerFunc {
 		response := StatsResponse{
 			EventTimestamps: timestamps,
 			DataPoints:      events,
+			Activity:        activity,
+			ReadOnly:        s.breaker.isOpen(),
+		}
+
+		conditionalJSON(c, lastModified, response)
+	}
+}
+
+// Writes one data point per line as JSON, or CSV with ?format=csv, flushing as we go
+func (s *Server) streamRecords(c *gin.Context) {
+	order, err := queryOrder(c)
+	if err != nil {
+		badRequest(c, err)
+		return
+	}
+
+	format := c.DefaultQuery("format", "json")
+	if format != "json" && format != "csv" {
+		badRequest(c, fmt.Errorf("format must be json or csv, got %q", format))
+		return
+	}
+
+	period := c.Param("period")
+	s.writeMutex.RLock()
+	events, ok := s.periodDataPoints(period)
+	if !ok {
+		s.writeMutex.RUnlock()
+		c.AbortWithStatus(http.StatusNotFound)
+		return
+	}
+
+	events, err = s.maybeInterpolate(c, period, events)
+	s.writeMutex.RUnlock()
+	if err != nil {
+		badRequest(c, err)
+		return
+	}
+	events = s.formatResponses(events)
+
+	if order == "desc" {
+		for i, j := 0, len(events)-1; i < j; i, j = i+1, j-1 {
+			events[i], events[j] = events[j], events[i]
+		}
+	}
+
+	if format == "csv" {
+		c.Header("Content-Type", "text/csv")
+		c.Status(200)
+
+		// Write errors are only reported once flushed
+		w := csv.NewWriter(c.Writer)
+		_ = writeCSVHeader(w)
+		for _, e := range events {
+			_ = writeCSVRow(w, e)
+			w.Flush()
+			if err := w.Error(); err != nil {
+				logger.Warn("Failed to stream data point", zap.Error(err))
+				return
+			}
+			c.Writer.Flush()
+		}
+		w.Flush()
+		return
+	}
+
+	c.Header("Content-Type", "application/x-ndjson")
+	c.Status(200)
+
+	enc := json.NewEncoder(c.Writer)
+	for _, e := range events {
+		err := enc.Encode(e)
+		if err != nil {
+			logger.Warn("Failed to stream data point", zap.Error(err))
+			return
 		}
+		c.Writer.Flush()
+	}
+}
+
+// How many documents to fetch per request when reading records. Applies to reads after the initial load. Migrated
+// records of a chunk are saved in one batch, so this can't be more than maxBatchWrites.
+func (s *Server) SetReadChunkSize(size int) {
+	if size <= 0 || size > maxBatchWrites {
+		logger.Warn("Ignoring invalid read chunk size", zap.Int("size", size))
+		return
+	}
+	s.readChunkSize = size
+}
+
+func (s *Server) SetSignedMeters(signed bool) {
+	s.signedMeters = signed
+}
 
-		c.JSON(200, response)
+// How often to generate fake events, when running with fake data
+func (s *Server) SetFakeDataInterval(interval time.Duration) {
+	if interval <= 0 {
+		logger.Warn("Ignoring invalid fake data interval", zap.Duration("interval", interval))
+		return
 	}
+	s.fakeDataInterval = interval
 }
 
+// Runs until SIGINT or SIGTERM, then shuts down gracefully
 func (s *Server) Run(listenAddr string, fakeData bool) {
-	if fakeData {
-		go s.generateFakeData()
+	s.start(fakeData)
+
+	s.httpServer = &http.Server{
+		Addr:    listenAddr,
+		Handler: s.engine,
+	}
+
+	go func() {
+		logger.Info("Listening", zap.String("address", listenAddr))
+		err := s.httpServer.ListenAndServe()
+		if err != nil && err != http.ErrServerClosed {
+			log.Panicf("Failed to run server: %s", err)
+		}
+	}()
+
+	waitForSignal()
+
+	logger.Info("Shutting down")
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+
+	err := s.Shutdown(ctx)
+	if err != nil {
+		logger.Warn("Error shutting down", zap.Error(err))
 	}
+}
 
-	err := s.engine.Run(listenAddr)
+func waitForSignal() {
+	quit := make(chan os.Signal, 1)
+	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
+	<-quit
+}
+
+// Loads the data and starts the background work, i.e. everything but serving requests. Loading waits until here so
+// that it's done with the server configured, e.g. with strict decoding.
+func (s *Server) start(fakeData bool) {
+	err := s.loadData(context.Background())
 	if err != nil {
-		log.Panic("Failed to run server: %s", err)
+		logger.Warn("Failed to load all data from DB, starting with what we have", zap.Error(err))
+	}
+
+	if fakeData {
+		ctx, cancel := context.WithCancel(context.Background())
+		s.stopFakeData = cancel
+		s.fakeDataDone = make(chan bool)
+		go func() {
+			s.generateFakeData(ctx, s.fakeDataInterval)
+			close(s.fakeDataDone)
+		}()
+	}
+
+	if s.buffer != nil {
+		s.buffer.done = make(chan bool)
+		go s.runBuffer()
+	}
+
+	windowsCtx, cancelWindows := context.WithCancel(context.Background())
+	s.stopWindows = cancelWindows
+	s.windowsDone = make(chan bool)
+	go func() {
+		s.runWindows(windowsCtx)
+		close(s.windowsDone)
+	}()
+
+	if s.refreshInterval > 0 {
+		ctx, cancel := context.WithCancel(context.Background())
+		s.stopRefresh = cancel
+		s.refreshDone = make(chan bool)
+		go func() {
+			s.runRefresh(ctx)
+			close(s.refreshDone)
+		}()
 	}
 }
 
+// Stops accepting requests, waits for the ongoing ones and saves any buffered data
+func (s *Server) Shutdown(ctx context.Context) error {
+	var err error
+	if s.httpServer != nil {
+		err = s.httpServer.Shutdown(ctx)
+	}
+
+	if s.stopFakeData != nil {
+		s.stopFakeData()
+		<-s.fakeDataDone
+	}
+
+	if s.stopRefresh != nil {
+		s.stopRefresh()
+		<-s.refreshDone
+	}
+
+	if s.stopWindows != nil {
+		s.stopWindows()
+		<-s.windowsDone
+	}
+
+	if s.buffer != nil {
+		s.stopBuffer()
+	}
+
+	return err
+}
+
 func NewServer(dev bool, projectId string, apiAuth string) *Server {
+	srv := NewServerWithClient(dev, NewClient(context.Background(), projectId), apiAuth)
+	srv.projectId = projectId
+	srv.serveFrontend(frontend)
+	return srv
+}
+
+// Like NewServer, but using the given Firestore client. The frontend isn't served.
+func NewServerWithClient(dev bool, db *firestore.Client, apiAuth string) *Server {
 	var router *gin.Engine
 	if dev {
 		router = gin.Default()
@@ -259,31 +721,56 @@ func NewServer(dev bool, projectId string, apiAuth string) *Server {
 		router.Use(ginzap.RecoveryWithZap(logger, true))
 	}
 	router.Use(SecurityMiddleware(dev))
-	// It's kind of important to have gzip enabled.
-	router.Use(gzip.Gzip(gzip.DefaultCompression))
+	// It's kind of important to have gzip enabled. Streams are left out as the gzip writer doesn't flush.
+	router.Use(gzip.Gzip(gzip.DefaultCompression, gzip.WithExcludedPathsRegexs([]string{"^/api/v1/stream/"})))
 
 	srv := &Server{}
-	srv.projectId = projectId
-	srv.loadData()
+	srv.db = db
+	srv.newClient = NewClient
+	srv.tracer = defaultTracer()
+	srv.clock = RealClock{}
+	srv.startedAt = srv.clock.Now()
+	srv.idempotency = newIdempotencyStore()
+	srv.fakeDataInterval = time.Minute
+	srv.metrics = newServerMetrics()
+	srv.initRecords(srv.clock.Now())
+
+	router.GET("/healthz", srv.returnHealth)
+	router.GET("/readyz", srv.returnReadiness)
+	router.GET("/metrics", srv.returnMetrics)
 
 	apiV1 := router.Group("/api/v1")
 	apiV1.POST("/updateStats", AuthRequired(apiAuth), srv.updateStats)
 	apiV1.GET("/stats/events", srv.returnEvents)
+	apiV1.GET("/events", srv.returnEventHistory)
 	apiV1.GET("/stats/minutes", srv.returnRecords("minutes"))
 	apiV1.GET("/stats/hours", srv.returnRecords("hours"))
 	apiV1.GET("/stats/days", srv.returnRecords("days"))
 	apiV1.GET("/stats/weeks", srv.returnRecords("weeks"))
 	apiV1.GET("/stats/months", srv.returnRecords("months"))
 	apiV1.GET("/stats/years", srv.returnRecords("years"))
+	apiV1.GET("/stream/:period", srv.streamRecords)
+	apiV1.GET("/total", srv.returnTotal)
+	apiV1.GET("/histogram/:period", srv.returnHistogram)
+	apiV1.GET("/compare/:period", srv.returnComparison)
+	apiV1.GET("/status", srv.returnStatus)
+	apiV1.GET("/snapshot", srv.returnSnapshot)
+	apiV1.GET("/record/:period/:id", srv.returnRecord)
+	apiV1.GET("/patterns", srv.returnPatterns)
+
+	srv.engine = router
+	return srv
+}
 
-	files, err := ioutil.ReadDir(frontend)
+func (s *Server) serveFrontend(dir string) {
+	files, err := ioutil.ReadDir(dir)
 	if err != nil {
 		log.Panicf("Failed to read frontend files: %s", err)
 	}
 
 	for _, f := range files {
 		fname := f.Name()
-		src := filepath.Join(frontend, fname)
+		src := filepath.Join(dir, fname)
 		path := fmt.Sprintf("/%s", fname)
 
 		if fname == "index.html" {
@@ -291,12 +778,9 @@ func NewServer(dev bool, projectId string, apiAuth string) *Server {
 		}
 
 		if f.IsDir() {
-			router.Static(path, src)
+			s.engine.Static(path, src)
 		} else {
-			router.StaticFile(path, src)
+			s.engine.StaticFile(path, src)
 		}
 	}
-
-	srv.engine = router
-	return srv
 }
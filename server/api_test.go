@@ -0,0 +1,256 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/lietu/godometer"
+)
+
+var testNow = time.Date(2024, 3, 6, 12, 30, 20, 0, time.UTC)
+
+func testMinute(t time.Time) string {
+	return t.UTC().Format(godometer.APITimeLayout)
+}
+
+func request(srv *Server, method string, path string, body interface{}, headers map[string]string) *httptest.ResponseRecorder {
+	var buf bytes.Buffer
+	if body != nil {
+		_ = json.NewEncoder(&buf).Encode(body)
+	}
+
+	req := httptest.NewRequest(method, path, &buf)
+	req.Header.Set("Content-Type", "application/json")
+	// As if behind the load balancer terminating TLS, so the server doesn't redirect
+	req.Header.Set("X-Forwarded-Proto", "https")
+	for key, value := range headers {
+		req.Header.Set(key, value)
+	}
+
+	rec := httptest.NewRecorder()
+	srv.engine.ServeHTTP(rec, req)
+	return rec
+}
+
+func postStats(t *testing.T, srv *Server, dataPoints ...godometer.UpdateDataPoint) *httptest.ResponseRecorder {
+	t.Helper()
+	return request(srv, http.MethodPost, "/api/v1/updateStats", godometer.UpdateStatsRequest{DataPoints: dataPoints}, nil)
+}
+
+func getJSON(t *testing.T, srv *Server, path string, response interface{}) *httptest.ResponseRecorder {
+	t.Helper()
+	rec := request(srv, http.MethodGet, path, nil, nil)
+	if rec.Code == http.StatusOK && response != nil {
+		if err := json.Unmarshal(rec.Body.Bytes(), response); err != nil {
+			t.Fatalf("Failed to parse response of %s: %s\n%s", path, err, rec.Body.String())
+		}
+	}
+	return rec
+}
+
+func TestServersWithClientsAreIsolated(t *testing.T) {
+	first, firstDb, _ := newTestServer(t, testNow)
+	second, secondDb, _ := newTestServer(t, testNow)
+
+	minute := testMinute(testNow)
+	rec := postStats(t, first, godometer.UpdateDataPoint{Timestamp: minute, Meters: 120, MetersPerSecond: 2, KilometersPerHour: 7.2})
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Update failed with %d: %s", rec.Code, rec.Body.String())
+	}
+
+	if got := first.minutes[minute].Meters; got != 120 {
+		t.Errorf("First server has %v meters for %s, expected 120", got, minute)
+	}
+	if got := second.minutes[minute].Meters; got != 0 {
+		t.Errorf("Second server has %v meters for %s, expected 0", got, minute)
+	}
+
+	if !firstDb.has("minutes", minute) {
+		t.Errorf("First server's DB is missing minute %s", minute)
+	}
+	if ids := secondDb.ids("minutes"); len(ids) != 0 {
+		t.Errorf("Second server's DB was written to: %v", ids)
+	}
+	if secondDb.commitCount() != 0 {
+		t.Errorf("Second server's DB got %d commits", secondDb.commitCount())
+	}
+}
+
+func TestPace(t *testing.T) {
+	ddp := DBDataPoint{Counter: 1, Meters: 150, MetersPerSecond: 2.5, KilometersPerHour: 9}
+	rdp := ddp.toResponseDataPoint("2024-03-06 12:30")
+	if rdp.PaceMinPerKm == nil {
+		t.Fatalf("No pace for %v m/s", ddp.MetersPerSecond)
+	}
+	if math.Abs(float64(*rdp.PaceMinPerKm)-6.6667) > 0.001 {
+		t.Errorf("Pace at 2.5 m/s is %v min/km, expected 6.667", *rdp.PaceMinPerKm)
+	}
+}
+
+func TestPaceStandingStill(t *testing.T) {
+	ddp := DBDataPoint{}
+	rdp := ddp.toResponseDataPoint("2024-03-06 12:30")
+	if rdp.PaceMinPerKm != nil {
+		t.Errorf("Expected no pace when standing still, got %v", *rdp.PaceMinPerKm)
+	}
+
+	encoded, err := json.Marshal(rdp)
+	if err != nil {
+		t.Fatalf("Failed to encode: %s", err)
+	}
+	if bytes.Contains(encoded, []byte("pace")) {
+		t.Errorf("Pace wasn't left out of %s", encoded)
+	}
+}
+
+func streamLines(t *testing.T, srv *Server, path string) []ResponseDataPoint {
+	t.Helper()
+	rec := request(srv, http.MethodGet, path, nil, nil)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("%s failed with %d: %s", path, rec.Code, rec.Body.String())
+	}
+	if ct := rec.Header().Get("Content-Type"); ct != "application/x-ndjson" {
+		t.Errorf("Content-Type is %q", ct)
+	}
+
+	var dataPoints []ResponseDataPoint
+	scanner := bufio.NewScanner(rec.Body)
+	for scanner.Scan() {
+		rdp := ResponseDataPoint{}
+		if err := json.Unmarshal(scanner.Bytes(), &rdp); err != nil {
+			t.Fatalf("Failed to parse line %q: %s", scanner.Text(), err)
+		}
+		dataPoints = append(dataPoints, rdp)
+	}
+	return dataPoints
+}
+
+func TestStreamRecords(t *testing.T) {
+	srv, _, _ := newTestServer(t, testNow)
+	srv.hours["2024-03-06 12"] = DBDataPoint{Counter: 3, Meters: 300, MetersPerSecond: 2, KilometersPerHour: 7.2}
+
+	hours := Last24Hours(testNow)
+	dataPoints := streamLines(t, srv, "/api/v1/stream/hours")
+	if len(dataPoints) != len(hours) {
+		t.Fatalf("Got %d lines, expected %d", len(dataPoints), len(hours))
+	}
+	for i, rdp := range dataPoints {
+		if rdp.Timestamp != hours[i] {
+			t.Errorf("Line %d is for %s, expected %s", i, rdp.Timestamp, hours[i])
+		}
+	}
+	if last := dataPoints[len(dataPoints)-1]; last.Meters != 300 {
+		t.Errorf("Latest hour has %v meters, expected 300", last.Meters)
+	}
+
+	desc := streamLines(t, srv, "/api/v1/stream/hours?order=desc")
+	if len(desc) != len(hours) || desc[0].Timestamp != "2024-03-06 12" {
+		t.Errorf("Descending stream doesn't start from the latest hour: %v", eventTimestamps(desc))
+	}
+}
+
+func TestBucketActivity(t *testing.T) {
+	srv, _, _ := newTestServer(t, testNow)
+	for _, hour := range []string{"2024-03-06 12", "2024-03-06 10", "2024-03-05 20"} {
+		srv.hours[hour] = DBDataPoint{Counter: 4, Meters: 400, MetersPerSecond: 2}
+	}
+	// Written to, but nothing that counted as a sample
+	srv.hours["2024-03-06 11"] = DBDataPoint{Meters: 1}
+
+	response := StatsResponse{}
+	rec := getJSON(t, srv, "/api/v1/stats/hours", &response)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Request failed with %d", rec.Code)
+	}
+
+	expected := BucketActivity{Active: 3, Total: 24}
+	if response.Activity != expected {
+		t.Errorf("Activity is %+v, expected %+v", response.Activity, expected)
+	}
+}
+
+func jsonKeys(t *testing.T, value interface{}) map[string]interface{} {
+	t.Helper()
+	encoded, err := json.Marshal(value)
+	if err != nil {
+		t.Fatalf("Failed to encode: %s", err)
+	}
+
+	keys := map[string]interface{}{}
+	if err := json.Unmarshal(encoded, &keys); err != nil {
+		t.Fatalf("Failed to decode %s: %s", encoded, err)
+	}
+	return keys
+}
+
+func TestWireSchema(t *testing.T) {
+	udp := godometer.UpdateDataPoint{Timestamp: "2024-03-06 12:30", Meters: 150, MetersPerSecond: 2.5, KilometersPerHour: 9}
+	expected := map[string]interface{}{"ts": "2024-03-06 12:30", "m": 150.0, "mps": 2.5, "kph": 9.0}
+	if keys := jsonKeys(t, udp); !reflect.DeepEqual(keys, expected) {
+		t.Errorf("UpdateDataPoint encodes as %v, expected %v", keys, expected)
+	}
+
+	decoded := godometer.UpdateDataPoint{}
+	if err := json.Unmarshal([]byte(`{"ts":"2024-03-06 12:30","m":150,"mps":2.5,"kph":9}`), &decoded); err != nil || decoded != udp {
+		t.Errorf("UpdateDataPoint decodes as %+v (%v), expected %+v", decoded, err, udp)
+	}
+
+	ddp := DBDataPoint{Counter: 3, Meters: 150, MetersPerSecond: 2.5, KilometersPerHour: 9}
+	rdp := ddp.toResponseDataPoint("2024-03-06 12:30")
+	keys := jsonKeys(t, rdp)
+	for _, key := range []string{"c", "ts", "m", "mps", "kph", "pace"} {
+		if _, ok := keys[key]; !ok {
+			t.Errorf("ResponseDataPoint is missing %q: %v", key, keys)
+		}
+	}
+	if len(keys) != 6 {
+		t.Errorf("ResponseDataPoint has unexpected keys: %v", keys)
+	}
+
+	roundTrip := ResponseDataPoint{}
+	encoded, _ := json.Marshal(rdp)
+	if err := json.Unmarshal(encoded, &roundTrip); err != nil {
+		t.Fatalf("Failed to decode %s: %s", encoded, err)
+	}
+	if roundTrip.Counter != 3 || roundTrip.Timestamp != rdp.Timestamp || roundTrip.Meters != 150 || roundTrip.KilometersPerHour != 9 || *roundTrip.PaceMinPerKm != *rdp.PaceMinPerKm {
+		t.Errorf("ResponseDataPoint round trips as %+v", roundTrip)
+	}
+}
+
+func TestGzipNegotiation(t *testing.T) {
+	srv, _, _ := newTestServer(t, testNow)
+	srv.hours["2024-03-06 12"] = DBDataPoint{Counter: 3, Meters: 300, MetersPerSecond: 2, KilometersPerHour: 7.2}
+
+	for _, path := range []string{"/api/v1/stats/hours", "/api/v1/events", "/api/v1/total?from=2024-03-01&to=2024-03-06"} {
+		plain := request(srv, http.MethodGet, path, nil, nil)
+		if plain.Code != http.StatusOK || plain.Header().Get("Content-Encoding") != "" {
+			t.Fatalf("%s without gzip returned %d with Content-Encoding %q", path, plain.Code, plain.Header().Get("Content-Encoding"))
+		}
+
+		gzipped := request(srv, http.MethodGet, path, nil, map[string]string{"Accept-Encoding": "gzip"})
+		if gzipped.Code != http.StatusOK || gzipped.Header().Get("Content-Encoding") != "gzip" {
+			t.Fatalf("%s with gzip returned %d with Content-Encoding %q", path, gzipped.Code, gzipped.Header().Get("Content-Encoding"))
+		}
+
+		reader, err := gzip.NewReader(gzipped.Body)
+		if err != nil {
+			t.Fatalf("%s isn't gzip: %s", path, err)
+		}
+		body, err := ioutil.ReadAll(reader)
+		if err != nil {
+			t.Fatalf("Failed to decompress %s: %s", path, err)
+		}
+		if !bytes.Equal(body, plain.Body.Bytes()) {
+			t.Errorf("%s decompresses to\n%s\nexpected\n%s", path, body, plain.Body.Bytes())
+		}
+	}
+}
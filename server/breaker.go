@@ -0,0 +1,92 @@
+package server
+
+import (
+	"strconv"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// How often to let an update through to check if committing works again
+const breakerProbeInterval = 30 * time.Second
+
+// Stops taking updates after too many commits in a row have failed, so clients know to hold on to their data
+// instead of it being lost. Reads keep working from memory.
+type commitBreaker struct {
+	mutex     sync.Mutex
+	threshold int
+	failures  int
+	open      bool
+	lastProbe time.Time
+}
+
+// Reject updates with a 503 after this many commits in a row fail, until one succeeds again
+func (s *Server) EnableCircuitBreaker(threshold int) {
+	if threshold <= 0 {
+		logger.Warn("Ignoring invalid circuit breaker threshold", zap.Int("threshold", threshold))
+		return
+	}
+	s.breaker = &commitBreaker{threshold: threshold}
+}
+
+// While open, every breakerProbeInterval one update is let through to probe whether commits work again
+func (b *commitBreaker) allow(now time.Time) bool {
+	if b == nil {
+		return true
+	}
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if !b.open {
+		return true
+	}
+
+	if now.Sub(b.lastProbe) >= breakerProbeInterval {
+		b.lastProbe = now
+		return true
+	}
+
+	return false
+}
+
+func (b *commitBreaker) record(now time.Time, ok bool) {
+	if b == nil {
+		return
+	}
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if ok {
+		if b.open {
+			logger.Info("Commits work again, accepting updates")
+		}
+		b.failures = 0
+		b.open = false
+		return
+	}
+
+	b.failures += 1
+	if !b.open && b.failures >= b.threshold {
+		logger.Warn("Too many failed commits, rejecting updates", zap.Int("failures", b.failures))
+		b.open = true
+		b.lastProbe = now
+	}
+}
+
+func (b *commitBreaker) isOpen() bool {
+	if b == nil {
+		return false
+	}
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	return b.open
+}
+
+func retryAfter() string {
+	return strconv.Itoa(int(breakerProbeInterval.Seconds()))
+}
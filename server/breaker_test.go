@@ -0,0 +1,59 @@
+package server
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/lietu/godometer"
+)
+
+func TestBreakerTripsAndRecovers(t *testing.T) {
+	srv, fake, clock := newTestServer(t, testNow)
+	srv.EnableCircuitBreaker(2)
+
+	minute := testMinute(testNow)
+	dp := godometer.UpdateDataPoint{Timestamp: minute, Meters: 100, MetersPerSecond: 2, KilometersPerHour: 7.2}
+	readOnly := func() bool {
+		response := StatsResponse{}
+		if rec := getJSON(t, srv, "/api/v1/stats/hours", &response); rec.Code != http.StatusOK {
+			t.Fatalf("Reading failed with %d", rec.Code)
+		}
+		return response.ReadOnly
+	}
+
+	fake.fail(minute)
+	for i := 0; i < 2; i++ {
+		if rec := postStats(t, srv, dp); rec.Code != http.StatusServiceUnavailable {
+			t.Fatalf("Failing update %d returned %d, expected 503", i, rec.Code)
+		}
+	}
+	commits := fake.commitCount()
+
+	// Open, so rejected without trying to commit
+	rec := postStats(t, srv, dp)
+	if rec.Code != http.StatusServiceUnavailable || rec.Header().Get("Retry-After") == "" {
+		t.Errorf("Update with the breaker open returned %d with Retry-After %q", rec.Code, rec.Header().Get("Retry-After"))
+	}
+	if fake.commitCount() != commits {
+		t.Errorf("Update was committed with the breaker open")
+	}
+	if !readOnly() {
+		t.Errorf("Stats don't show the breaker as open")
+	}
+
+	// The probe after the interval succeeds and closes it
+	fake.recover()
+	clock.Add(breakerProbeInterval)
+	if rec := postStats(t, srv, dp); rec.Code != http.StatusOK {
+		t.Fatalf("Probe update returned %d, expected 200", rec.Code)
+	}
+	if readOnly() {
+		t.Errorf("Stats still show the breaker as open")
+	}
+	if rec := postStats(t, srv, godometer.UpdateDataPoint{Timestamp: minute, Meters: 20, MetersPerSecond: 1, KilometersPerHour: 3.6}); rec.Code != http.StatusOK {
+		t.Errorf("Update after recovering returned %d", rec.Code)
+	}
+	if row := srv.hours["2024-03-06 12"]; row.Meters != 120 {
+		t.Errorf("Hour is %s, expected the 120m from after recovering", recordStr(row))
+	}
+}
@@ -0,0 +1,154 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/lietu/godometer"
+)
+
+type eventBuffer struct {
+	interval   time.Duration
+	maxSize    int
+	dataPoints []godometer.UpdateDataPoint
+	mutex      sync.Mutex
+	flushNow   chan bool
+	stop       chan bool
+	// Made when the flusher is started, closed once it's stopped
+	done chan bool
+}
+
+// Instead of committing on every request, queue up the data points and save them all in one go every interval, or
+// once there's maxSize of them queued.
+func (s *Server) EnableBuffering(interval time.Duration, maxSize int) {
+	s.buffer = &eventBuffer{
+		interval: interval,
+		maxSize:  maxSize,
+		flushNow: make(chan bool, 1),
+		stop:     make(chan bool),
+	}
+}
+
+// Adds up the new events of each minute in a batch before adding them to the records, so each record is updated once
+// per minute of the batch rather than once per event. With buffering the batch is everything queued over the flush
+// interval. Only minutes with several events differ: they're counted the way the hours and longer periods count
+// them, so e.g. an update without speed doesn't add to the minute's counter.
+func (s *Server) EnableCoalescing() {
+	s.coalesce = true
+}
+
+func (s *Server) bufferDataPoints(udps []godometer.UpdateDataPoint) {
+	b := s.buffer
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	for _, udp := range udps {
+		// Clients resend recent data points, only the first one counts same as without buffering
+		queued := false
+		for _, dp := range b.dataPoints {
+			if eventId(dp) == eventId(udp) {
+				queued = true
+				break
+			}
+		}
+
+		if !queued {
+			b.dataPoints = append(b.dataPoints, udp)
+		}
+	}
+
+	if b.maxSize > 0 && len(b.dataPoints) >= b.maxSize {
+		select {
+		case b.flushNow <- true:
+		default:
+			// Flush already pending
+		}
+	}
+}
+
+// Saves the queued data points. If that fails they're put back in the queue for the next flush, ahead of anything
+// queued since.
+func (s *Server) flushBuffer(ctx context.Context) error {
+	b := s.buffer
+	b.mutex.Lock()
+	dataPoints := b.dataPoints
+	b.dataPoints = nil
+	b.mutex.Unlock()
+
+	if len(dataPoints) == 0 {
+		return nil
+	}
+
+	logger.Info("Flushing buffered data points", zap.Int("count", len(dataPoints)))
+	err := s.writeStats(ctx, dataPoints)
+	// Only a mirror failed, the data points are saved
+	var mirrorErr *MirrorError
+	if err != nil && !errors.As(err, &mirrorErr) {
+		logger.Warn("Failed to flush buffered data points, keeping them for the next flush", zap.Int("count", len(dataPoints)), zap.Error(err))
+		s.requeue(dataPoints)
+	}
+
+	return err
+}
+
+func (s *Server) requeue(dataPoints []godometer.UpdateDataPoint) {
+	b := s.buffer
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	queued := map[string]bool{}
+	for _, dp := range dataPoints {
+		queued[eventId(dp)] = true
+	}
+
+	for _, dp := range b.dataPoints {
+		if !queued[eventId(dp)] {
+			dataPoints = append(dataPoints, dp)
+		}
+	}
+	b.dataPoints = dataPoints
+}
+
+func (s *Server) runBuffer() {
+	b := s.buffer
+	defer close(b.done)
+
+	tick := time.NewTicker(b.interval)
+	defer tick.Stop()
+
+	// Failed flushes are logged and retried on the next one
+	ctx := context.Background()
+	for {
+		select {
+		case <-tick.C:
+			_ = s.flushBuffer(ctx)
+		case <-b.flushNow:
+			_ = s.flushBuffer(ctx)
+		case <-b.stop:
+			err := s.flushBuffer(ctx)
+			if err != nil {
+				b.mutex.Lock()
+				logger.Error("Shutting down with unsaved buffered data points", zap.Int("count", len(b.dataPoints)), zap.Error(err))
+				b.mutex.Unlock()
+			}
+			return
+		}
+	}
+}
+
+// Stops the background flushing and saves anything still in the buffer
+func (s *Server) stopBuffer() {
+	b := s.buffer
+	if b.done == nil {
+		// Never started, there's no flusher to stop but anything queued is still saved
+		_ = s.flushBuffer(context.Background())
+		return
+	}
+
+	close(b.stop)
+	<-b.done
+}
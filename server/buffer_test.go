@@ -0,0 +1,105 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/lietu/godometer"
+)
+
+func TestBufferedPointsCommitOnce(t *testing.T) {
+	srv, fake, _ := newTestServer(t, testNow)
+	srv.EnableBuffering(time.Hour, 0)
+
+	hour := "2024-03-06 12"
+	for _, minute := range []string{"2024-03-06 12:28", "2024-03-06 12:29", "2024-03-06 12:30"} {
+		rec := postStats(t, srv, godometer.UpdateDataPoint{Timestamp: minute, Meters: 60, MetersPerSecond: 1, KilometersPerHour: 3.6})
+		if rec.Code != http.StatusOK {
+			t.Fatalf("Update failed with %d", rec.Code)
+		}
+	}
+
+	if fake.commitCount() != 0 {
+		t.Fatalf("Buffered points were committed right away")
+	}
+
+	if err := srv.flushBuffer(context.Background()); err != nil {
+		t.Fatalf("Flushing failed: %s", err)
+	}
+
+	if fake.commitCount() != 1 {
+		t.Errorf("Expected one commit, got %d", fake.commitCount())
+	}
+
+	row := srv.hours[hour]
+	if row.Meters != 180 || row.Counter != 3 || row.MetersPerSecond != 1 {
+		t.Errorf("Hour is %s, expected 180m @ 1m/s from 3 records", recordStr(row))
+	}
+}
+
+func TestBufferKeepsPointsWhenFlushFails(t *testing.T) {
+	srv, fake, _ := newTestServer(t, testNow)
+	srv.EnableBuffering(time.Hour, 0)
+
+	minute := testMinute(testNow)
+	srv.bufferDataPoints([]godometer.UpdateDataPoint{{Timestamp: minute, Meters: 60, MetersPerSecond: 1, KilometersPerHour: 3.6}})
+
+	fake.setUnavailable(true)
+	if err := srv.flushBuffer(context.Background()); err == nil {
+		t.Fatalf("Flushing to an unavailable DB succeeded")
+	}
+	if len(srv.buffer.dataPoints) != 1 {
+		t.Fatalf("Expected the data point back in the buffer, have %d", len(srv.buffer.dataPoints))
+	}
+}
+
+func TestBufferRetriesFailedFlush(t *testing.T) {
+	srv, fake, _ := newTestServer(t, testNow)
+	srv.EnableBuffering(time.Hour, 0)
+
+	minute := testMinute(testNow)
+	srv.bufferDataPoints([]godometer.UpdateDataPoint{{Timestamp: minute, Meters: 60, MetersPerSecond: 1, KilometersPerHour: 3.6}})
+
+	fake.fail(minute)
+	if err := srv.flushBuffer(context.Background()); err == nil {
+		t.Fatalf("Flushing succeeded despite the failure")
+	}
+
+	fake.recover()
+	if err := srv.flushBuffer(context.Background()); err != nil {
+		t.Fatalf("Flushing failed: %s", err)
+	}
+	if !fake.has("minutes", minute) {
+		t.Errorf("Minute %s wasn't saved on the second flush", minute)
+	}
+	if got := srv.minutes[minute].Meters; got != 60 {
+		t.Errorf("Minute has %v meters, expected 60", got)
+	}
+}
+
+func TestShutdownWithoutStarting(t *testing.T) {
+	srv, fake, _ := newTestServer(t, testNow)
+	srv.EnableBuffering(time.Hour, 0)
+	if rec := postStats(t, srv, godometer.UpdateDataPoint{Timestamp: testMinute(testNow), Meters: 60, MetersPerSecond: 1, KilometersPerHour: 3.6}); rec.Code != http.StatusOK {
+		t.Fatalf("Update failed with %d", rec.Code)
+	}
+
+	done := make(chan error)
+	go func() {
+		done <- srv.Shutdown(context.Background())
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("Shutdown failed: %s", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Shutdown of a server that wasn't started hangs")
+	}
+
+	if !fake.has("minutes", testMinute(testNow)) {
+		t.Errorf("The queued point wasn't saved on shutdown")
+	}
+}
@@ -0,0 +1,89 @@
+package server
+
+import (
+	"sync"
+	"time"
+)
+
+// Anything that needs to know the current time, or wait for some of it to pass, should ask the Server's clock
+type Clock interface {
+	Now() time.Time
+	// Like time.After, with the time passing on this clock
+	After(d time.Duration) <-chan time.Time
+}
+
+type RealClock struct{}
+
+func (RealClock) Now() time.Time {
+	return time.Now()
+}
+
+func (RealClock) After(d time.Duration) <-chan time.Time {
+	return time.After(d)
+}
+
+type fakeTimer struct {
+	at time.Time
+	c  chan time.Time
+}
+
+// Clock that only moves when told to
+type FakeClock struct {
+	now    time.Time
+	timers []fakeTimer
+	mutex  sync.Mutex
+}
+
+func NewFakeClock(now time.Time) *FakeClock {
+	return &FakeClock{now: now}
+}
+
+func (fc *FakeClock) Now() time.Time {
+	fc.mutex.Lock()
+	defer fc.mutex.Unlock()
+	return fc.now
+}
+
+// Fires once the clock has been moved d forward
+func (fc *FakeClock) After(d time.Duration) <-chan time.Time {
+	fc.mutex.Lock()
+	defer fc.mutex.Unlock()
+
+	c := make(chan time.Time, 1)
+	fc.timers = append(fc.timers, fakeTimer{at: fc.now.Add(d), c: c})
+	fc.fire()
+	return c
+}
+
+func (fc *FakeClock) Set(now time.Time) {
+	fc.mutex.Lock()
+	defer fc.mutex.Unlock()
+	fc.now = now
+	fc.fire()
+}
+
+func (fc *FakeClock) Add(d time.Duration) {
+	fc.mutex.Lock()
+	defer fc.mutex.Unlock()
+	fc.now = fc.now.Add(d)
+	fc.fire()
+}
+
+// Caller must hold the mutex
+func (fc *FakeClock) fire() {
+	var pending []fakeTimer
+	for _, timer := range fc.timers {
+		if timer.at.After(fc.now) {
+			pending = append(pending, timer)
+		} else {
+			timer.c <- fc.now
+		}
+	}
+	fc.timers = pending
+}
+
+func (s *Server) SetClock(clock Clock) {
+	s.clock = clock
+	// Uptime is measured with the same clock
+	s.startedAt = clock.Now()
+}
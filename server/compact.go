@@ -0,0 +1,119 @@
+package server
+
+import (
+	"context"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"go.uber.org/zap"
+)
+
+// Per-hour breakdown of the minutes in it, written as the minutes age out of memory
+type MinuteDetail struct {
+	Minutes map[string]DBDataPoint `firestore:"minutes"`
+}
+
+// Keeps a per-hour document with the minutes of that hour for drill-down after they're no longer in memory.
+//
+// Minute documents are never deleted, but reading back an hour of them takes 60 reads while the detail document is
+// one. The tradeoff is storage and writes: each minute is stored twice, and every time minutes age out their hour's
+// detail document is written again, so in practice one extra write per minute of activity. A detail document holds at
+// most 60 minutes, well below Firestore's 1MiB document limit.
+func (s *Server) EnableMinuteDetail() {
+	s.minuteDetail = true
+}
+
+// Minutes are added to their hour in the same batch as they're written, so aging out of memory shouldn't lose any
+// distance. This makes sure of it, and writes the minute detail when enabled.
+func (s *Server) compactMinutes(ctx context.Context, aged map[string]DBDataPoint) error {
+	if len(aged) == 0 {
+		return nil
+	}
+
+	byHour := map[string]map[string]DBDataPoint{}
+	for minute, row := range aged {
+		ts, err := time.Parse(minuteLayout, minute)
+		if err != nil {
+			logger.Warn("Failed to parse minute", zap.String("minute", minute), zap.Error(err))
+			continue
+		}
+
+		hour := ts.Format(hourLayout)
+		if _, ok := byHour[hour]; !ok {
+			byHour[hour] = map[string]DBDataPoint{}
+		}
+		byHour[hour][minute] = row
+	}
+
+	db := s.db
+	batch := s.batch()
+	hoursColl := db.Collection(collectionName("hours"))
+	detailColl := db.Collection(collectionName("minutedetail"))
+
+	writes := 0
+	for hour, minutes := range byHour {
+		if s.guardHour(hour, minutes) {
+			batch.Set(hoursColl.Doc(hour), s.storable(s.hours[hour]))
+			writes += 1
+		}
+
+		if s.minuteDetail {
+			detail := map[string]interface{}{}
+			for minute, row := range minutes {
+				detail[minute] = s.storable(row)
+			}
+			batch.Set(detailColl.Doc(hour), map[string]interface{}{"minutes": detail}, firestore.MergeAll)
+			writes += 1
+		}
+	}
+
+	if writes == 0 {
+		return nil
+	}
+
+	_, err := batch.Commit(ctx)
+	if err != nil {
+		return storageError(err)
+	}
+
+	return nil
+}
+
+// Tops up the hour when it has less distance than the given minutes of it, returns whether it was changed. With
+// signed meters a part of the minutes can add up to more than the whole hour, so there's nothing to check.
+func (s *Server) guardHour(hour string, minutes map[string]DBDataPoint) bool {
+	row, ok := s.hours[hour]
+	if !ok || s.signedMeters {
+		return false
+	}
+
+	total := int64(0)
+	counter := int64(0)
+	for _, m := range minutes {
+		if m.Millimeters > 0 {
+			total += m.Millimeters
+			counter += 1
+		}
+	}
+
+	// Hours added up as floats before schema version 3 can be slightly off
+	if total-row.Millimeters <= total/1000 {
+		return false
+	}
+
+	logger.Warn("Hour is missing distance from its minutes, adding it back",
+		zap.String("hour", hour),
+		zap.Int64("hourMillimeters", row.Millimeters),
+		zap.Int64("minuteMillimeters", total),
+	)
+
+	row.Millimeters = total
+	row.Meters = float32(total) / 1000
+	if row.Counter < counter {
+		row.Counter = counter
+	}
+	s.hours[hour] = row
+	s.markModified("hours")
+
+	return true
+}
@@ -0,0 +1,67 @@
+package server
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/lietu/godometer"
+)
+
+func TestAgedMinutesKeepHourTotal(t *testing.T) {
+	now := time.Date(2024, 3, 6, 12, 5, 0, 0, time.UTC)
+	srv, _, clock := newTestServer(t, now)
+	srv.EnableMinuteDetail()
+
+	err := srv.writeStats(context.Background(), []godometer.UpdateDataPoint{
+		{Timestamp: "2024-03-06 12:01", Meters: 100, MetersPerSecond: 2, KilometersPerHour: 7.2},
+		{Timestamp: "2024-03-06 12:02", Meters: 60.5, MetersPerSecond: 1, KilometersPerHour: 3.6},
+		{Timestamp: "2024-03-06 12:03", Meters: 20.25, MetersPerSecond: 1, KilometersPerHour: 3.6},
+	})
+	if err != nil {
+		t.Fatalf("writeStats failed: %s", err)
+	}
+
+	clock.Add(90 * time.Minute)
+	srv.rollWindows(context.Background())
+
+	if _, ok := srv.minutes["2024-03-06 12:01"]; ok {
+		t.Fatalf("Minute didn't age out of memory")
+	}
+	if row := srv.hours["2024-03-06 12"]; row.Millimeters != 180750 {
+		t.Errorf("Hour is %s after the minutes aged out, expected 180.75m", recordStr(row))
+	}
+
+	doc, err := srv.db.Collection(collectionName("minutedetail")).Doc("2024-03-06 12").Get(context.Background())
+	if err != nil {
+		t.Fatalf("Failed to read the minute detail: %s", err)
+	}
+	detail := MinuteDetail{}
+	if err := doc.DataTo(&detail); err != nil {
+		t.Fatalf("Failed to parse the minute detail: %s", err)
+	}
+	if len(detail.Minutes) != 3 || detail.Minutes["2024-03-06 12:02"].Meters != 60.5 {
+		t.Errorf("Minute detail is %+v", detail.Minutes)
+	}
+}
+
+func TestGuardHourTopsUpMissingDistance(t *testing.T) {
+	srv, fake, _ := newTestServer(t, testNow)
+	srv.hours["2024-03-06 12"] = DBDataPoint{Counter: 1, Meters: 10, Millimeters: 10000}
+
+	aged := map[string]DBDataPoint{
+		"2024-03-06 12:01": sample(100, 2),
+		"2024-03-06 12:02": sample(50, 1),
+	}
+	if err := srv.compactMinutes(context.Background(), aged); err != nil {
+		t.Fatalf("Compacting failed: %s", err)
+	}
+
+	row := srv.hours["2024-03-06 12"]
+	if row.Meters != 150 || row.Counter != 2 {
+		t.Errorf("Hour is %s, expected it topped up to 150m from 2 records", recordStr(row))
+	}
+	if !fake.has("hours", "2024-03-06 12") {
+		t.Errorf("Topped up hour wasn't saved")
+	}
+}
@@ -0,0 +1,80 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+type ComparisonResponse struct {
+	Period   string            `json:"period"`
+	Current  ResponseDataPoint `json:"current"`
+	Previous ResponseDataPoint `json:"previous"`
+	// Change from the previous bucket in percent, null when the previous bucket is empty
+	MetersChange *float32 `json:"metersChange"`
+	SpeedChange  *float32 `json:"speedChange"`
+}
+
+// Percentage change from previous to current, there's no change from nothing
+func percentChange(previous float32, current float32) *float32 {
+	if previous == 0 {
+		return nil
+	}
+
+	change := (current - previous) / previous * 100
+	return &change
+}
+
+func (s *Server) lookupRecord(ctx context.Context, period string, id string) (ResponseDataPoint, error) {
+	rows, err := s.lookupRecords(ctx, period, []string{id})
+	if err != nil {
+		return ResponseDataPoint{}, err
+	}
+
+	row := DBDataPoint{}
+	if len(rows) > 0 {
+		row = rows[0]
+	}
+	row.Meters = zeroNaN(row.Meters)
+	row.MetersPerSecond = zeroNaN(row.MetersPerSecond)
+	row.KilometersPerHour = zeroNaN(row.KilometersPerHour)
+
+	return row.toResponseDataPoint(id), nil
+}
+
+// Compares the current bucket of the period to the one before it, e.g. today to yesterday
+func (s *Server) returnComparison(c *gin.Context) {
+	period := c.Param("period")
+	if !stringInList(periods, period) {
+		err := fmt.Errorf("invalid period %q", period)
+		logger.Warn("Invalid period", zap.String("period", period))
+		_ = c.AbortWithError(http.StatusNotFound, err)
+		return
+	}
+
+	ids := s.getPeriodIds(period)
+	ctx := c.Request.Context()
+
+	current, err := s.lookupRecord(ctx, period, ids[len(ids)-1])
+	if err != nil {
+		_ = c.AbortWithError(errorStatus(err), err)
+		return
+	}
+
+	previous, err := s.lookupRecord(ctx, period, ids[len(ids)-2])
+	if err != nil {
+		_ = c.AbortWithError(errorStatus(err), err)
+		return
+	}
+
+	c.JSON(200, ComparisonResponse{
+		Period:       period,
+		Current:      s.formatResponse(current),
+		Previous:     s.formatResponse(previous),
+		MetersChange: percentChange(previous.Meters, current.Meters),
+		SpeedChange:  percentChange(previous.KilometersPerHour, current.KilometersPerHour),
+	})
+}
@@ -0,0 +1,63 @@
+package server
+
+import (
+	"net/http"
+	"testing"
+)
+
+func compare(t *testing.T, srv *Server, period string) ComparisonResponse {
+	t.Helper()
+	response := ComparisonResponse{}
+	rec := getJSON(t, srv, "/api/v1/compare/"+period, &response)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Comparing %s failed with %d: %s", period, rec.Code, rec.Body.String())
+	}
+	return response
+}
+
+func TestCompareDelta(t *testing.T) {
+	srv, _, _ := newTestServer(t, testNow)
+	srv.days["2024-03-05"] = sample(100, 2)
+	srv.days["2024-03-06"] = sample(120, 2.5)
+
+	response := compare(t, srv, "days")
+	if response.Current.Timestamp != "2024-03-06" || response.Previous.Timestamp != "2024-03-05" {
+		t.Errorf("Compared %s to %s, expected today to yesterday", response.Current.Timestamp, response.Previous.Timestamp)
+	}
+	if response.MetersChange == nil || !closeTo(*response.MetersChange, 20) {
+		t.Errorf("Meters changed by %v, expected 20%%", response.MetersChange)
+	}
+	if response.SpeedChange == nil || !closeTo(*response.SpeedChange, 25) {
+		t.Errorf("Speed changed by %v, expected 25%%", response.SpeedChange)
+	}
+}
+
+func TestCompareZeroPrevious(t *testing.T) {
+	srv, _, _ := newTestServer(t, testNow)
+	srv.days["2024-03-06"] = sample(120, 2.5)
+
+	response := compare(t, srv, "days")
+	if response.MetersChange != nil || response.SpeedChange != nil {
+		t.Errorf("Expected no change from an empty day, got %v and %v", response.MetersChange, response.SpeedChange)
+	}
+	if response.Current.Meters != 120 || response.Previous.Meters != 0 {
+		t.Errorf("Compared %vm to %vm", response.Current.Meters, response.Previous.Meters)
+	}
+}
+
+func TestCompareZeroCurrent(t *testing.T) {
+	srv, _, _ := newTestServer(t, testNow)
+	srv.months["2024-02"] = sample(1000, 2)
+
+	response := compare(t, srv, "months")
+	if response.MetersChange == nil || *response.MetersChange != -100 {
+		t.Errorf("Meters changed by %v, expected -100%%", response.MetersChange)
+	}
+	if response.SpeedChange == nil || *response.SpeedChange != -100 {
+		t.Errorf("Speed changed by %v, expected -100%%", response.SpeedChange)
+	}
+
+	if rec := getJSON(t, srv, "/api/v1/compare/decades", nil); rec.Code != http.StatusNotFound {
+		t.Errorf("Invalid period returned %d, expected 404", rec.Code)
+	}
+}
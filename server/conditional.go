@@ -0,0 +1,111 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+// Weak, as the same content may be sent gzipped or not
+func weakETag(body []byte) string {
+	h := fnv.New64a()
+	_, _ = h.Write(body)
+	return fmt.Sprintf(`W/"%x"`, h.Sum64())
+}
+
+// When the newest bucket of the period started
+func bucketStart(period string, now time.Time) time.Time {
+	now = now.In(utc)
+	switch period {
+	case "minutes":
+		return now.Truncate(time.Minute)
+	case "hours":
+		return now.Truncate(time.Hour)
+	case "days":
+		return time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, utc)
+	case "weeks":
+		// ISO weeks start on Monday
+		daysSinceMonday := (int(now.Weekday()) + 6) % 7
+		return time.Date(now.Year(), now.Month(), now.Day()-daysSinceMonday, 0, 0, 0, 0, utc)
+	case "months":
+		return time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, utc)
+	case "years":
+		return time.Date(now.Year(), 1, 1, 0, 0, 0, 0, utc)
+	}
+	return now
+}
+
+// Caller must hold writeMutex
+func (s *Server) markModified(periods ...string) {
+	if s.modified == nil {
+		s.modified = map[string]time.Time{}
+	}
+
+	now := s.clock.Now()
+	for _, period := range periods {
+		s.modified[period] = now
+	}
+}
+
+// The period changes when it's written to, and when a new bucket starts and the oldest one drops out. Caller must
+// hold writeMutex.
+func (s *Server) periodLastModified(period string) time.Time {
+	modified := s.modified[period]
+
+	start := bucketStart(period, s.clock.Now())
+	if start.After(modified) {
+		return start
+	}
+	return modified
+}
+
+func notModified(r *http.Request, etag string, lastModified time.Time) bool {
+	// If-None-Match wins when both are given
+	if inm := r.Header.Get("If-None-Match"); inm != "" {
+		for _, tag := range strings.Split(inm, ",") {
+			tag = strings.TrimSpace(tag)
+			if tag == "*" || strings.TrimPrefix(tag, "W/") == strings.TrimPrefix(etag, "W/") {
+				return true
+			}
+		}
+		return false
+	}
+
+	if ims := r.Header.Get("If-Modified-Since"); ims != "" {
+		t, err := http.ParseTime(ims)
+		if err == nil && !lastModified.Truncate(time.Second).After(t) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Like c.JSON, but with an ETag and Last-Modified, and a 304 when the client already has the response
+func conditionalJSON(c *gin.Context, lastModified time.Time, response interface{}) {
+	body, err := json.Marshal(response)
+	if err != nil {
+		logger.Warn("Failed to marshal response", zap.Error(err))
+		_ = c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+
+	etag := weakETag(body)
+	c.Header("ETag", etag)
+	c.Header("Last-Modified", lastModified.UTC().Format(http.TimeFormat))
+
+	if notModified(c.Request, etag, lastModified) {
+		// The gzip middleware has already claimed the body is gzipped, but a 304 has no body
+		c.Writer.Header().Del("Content-Encoding")
+		c.Status(http.StatusNotModified)
+		return
+	}
+
+	c.Data(200, "application/json; charset=utf-8", body)
+}
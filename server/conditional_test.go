@@ -0,0 +1,67 @@
+package server
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/lietu/godometer"
+)
+
+func TestConditionalStats(t *testing.T) {
+	srv, _, _ := newTestServer(t, testNow)
+	gzipped := map[string]string{"Accept-Encoding": "gzip"}
+
+	first := request(srv, http.MethodGet, "/api/v1/stats/days", nil, gzipped)
+	etag := first.Header().Get("ETag")
+	if first.Code != http.StatusOK || etag == "" || first.Header().Get("Last-Modified") == "" {
+		t.Fatalf("Expected a 200 with an ETag and Last-Modified, got %d with %v", first.Code, first.Header())
+	}
+	if first.Header().Get("Content-Encoding") != "gzip" {
+		t.Errorf("Response wasn't gzipped")
+	}
+
+	// Over a real connection, as the recorder would also keep the empty gzip stream net/http refuses to send
+	ts := httptest.NewServer(srv.engine)
+	defer ts.Close()
+	req, _ := http.NewRequest(http.MethodGet, ts.URL+"/api/v1/stats/days", nil)
+	req.Header.Set("X-Forwarded-Proto", "https")
+	req.Header.Set("Accept-Encoding", "gzip")
+	req.Header.Set("If-None-Match", etag)
+	unchanged, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("Re-request failed: %s", err)
+	}
+	body, _ := ioutil.ReadAll(unchanged.Body)
+	_ = unchanged.Body.Close()
+	if unchanged.StatusCode != http.StatusNotModified {
+		t.Fatalf("Re-request with the ETag got %d, expected 304", unchanged.StatusCode)
+	}
+	if len(body) != 0 || unchanged.Header.Get("Content-Encoding") != "" {
+		t.Errorf("304 has a %d byte body with Content-Encoding %q", len(body), unchanged.Header.Get("Content-Encoding"))
+	}
+
+	since := request(srv, http.MethodGet, "/api/v1/stats/days", nil, map[string]string{
+		"If-Modified-Since": first.Header().Get("Last-Modified"),
+	})
+	if since.Code != http.StatusNotModified {
+		t.Errorf("Re-request with If-Modified-Since got %d, expected 304", since.Code)
+	}
+
+	rec := postStats(t, srv, godometer.UpdateDataPoint{Timestamp: testMinute(testNow), Meters: 120, MetersPerSecond: 2, KilometersPerHour: 7.2})
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Update failed with %d", rec.Code)
+	}
+
+	changed := request(srv, http.MethodGet, "/api/v1/stats/days", nil, map[string]string{
+		"Accept-Encoding": "gzip",
+		"If-None-Match":   etag,
+	})
+	if changed.Code != http.StatusOK {
+		t.Fatalf("Re-request after a write got %d, expected 200", changed.Code)
+	}
+	if changed.Header().Get("ETag") == etag {
+		t.Errorf("ETag didn't change after a write")
+	}
+}
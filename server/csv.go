@@ -0,0 +1,211 @@
+package server
+
+import (
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+// Same columns for exporting and importing
+var csvHeader = []string{"timestamp", "counter", "meters", "mps", "kph"}
+
+var weekPattern = regexp.MustCompile(`^\d{4} week \d{1,2}$`)
+
+type ImportResponse struct {
+	Imported int `json:"imported"`
+}
+
+func formatFloat(value float32) string {
+	return strconv.FormatFloat(float64(value), 'f', -1, 32)
+}
+
+func writeCSVHeader(w *csv.Writer) error {
+	return w.Write(csvHeader)
+}
+
+func writeCSVRow(w *csv.Writer, e ResponseDataPoint) error {
+	return w.Write([]string{
+		e.Timestamp,
+		strconv.FormatInt(e.Counter, 10),
+		formatFloat(e.Meters),
+		formatFloat(e.MetersPerSecond),
+		formatFloat(e.KilometersPerHour),
+	})
+}
+
+// Which period a record key belongs to, based on its format
+func timestampPeriod(ts string) (string, bool) {
+	if weekPattern.MatchString(ts) {
+		return "weeks", true
+	}
+
+	layouts := map[string]string{
+		"minutes": minuteLayout,
+		"hours":   hourLayout,
+		"days":    dayLayout,
+		"months":  monthLayout,
+		"years":   yearLayout,
+	}
+	for period, layout := range layouts {
+		t, err := time.Parse(layout, ts)
+		if err == nil && t.Format(layout) == ts {
+			return period, true
+		}
+	}
+
+	return "", false
+}
+
+type importRecord struct {
+	period string
+	id     string
+	row    DBDataPoint
+}
+
+// Parses the export format, with the period of each row either given or inferred from the timestamp
+func parseCSV(r io.Reader, period string) ([]importRecord, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = len(csvHeader)
+
+	header, err := reader.Read()
+	if err == io.EOF {
+		return nil, errors.New("empty file")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("line 1: %w", err)
+	}
+	if strings.Join(header, ",") != strings.Join(csvHeader, ",") {
+		return nil, fmt.Errorf("line 1: expected header %q, got %q", strings.Join(csvHeader, ","), strings.Join(header, ","))
+	}
+
+	var records []importRecord
+	line := 1
+	for {
+		fields, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		line += 1
+
+		record, err := parseCSVRow(fields, period)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		records = append(records, record)
+	}
+
+	return records, nil
+}
+
+func parseCSVRow(fields []string, period string) (importRecord, error) {
+	ts := fields[0]
+	rowPeriod, ok := timestampPeriod(ts)
+	if !ok {
+		return importRecord{}, fmt.Errorf("invalid timestamp %q", ts)
+	}
+	if period != "" && rowPeriod != period {
+		return importRecord{}, fmt.Errorf("timestamp %q is not in %s", ts, period)
+	}
+
+	counter, err := strconv.ParseInt(fields[1], 10, 64)
+	if err != nil || counter < 0 {
+		return importRecord{}, fmt.Errorf("invalid counter %q", fields[1])
+	}
+
+	var values [3]float32
+	for i, field := range fields[2:] {
+		f, err := strconv.ParseFloat(field, 32)
+		if err != nil || math.IsNaN(f) {
+			return importRecord{}, fmt.Errorf("invalid %s %q", csvHeader[i+2], field)
+		}
+		values[i] = float32(f)
+	}
+
+	row := DBDataPoint{
+		Counter:           counter,
+		Meters:            values[0],
+		MetersPerSecond:   values[1],
+		KilometersPerHour: values[2],
+	}
+	row.MovingSeconds = movingSeconds(row)
+
+	return importRecord{period: rowPeriod, id: ts, row: row.withIntegers()}, nil
+}
+
+func (s *Server) importStats(c *gin.Context) {
+	period := c.Query("period")
+	if period != "" && !stringInList(periods, period) {
+		err := fmt.Errorf("invalid period %q", period)
+		_ = c.AbortWithError(http.StatusBadRequest, err)
+		return
+	}
+
+	records, err := parseCSV(c.Request.Body, period)
+	if err != nil {
+		logger.Warn("Invalid import", zap.Error(err))
+		_ = c.AbortWithError(http.StatusBadRequest, err)
+		return
+	}
+
+	imported, err := s.importRecords(c.Request.Context(), records)
+	if err != nil {
+		logger.Warn("Failed to import records", zap.Int("imported", imported), zap.Error(err))
+		_ = c.AbortWithError(errorStatus(err), err)
+		return
+	}
+
+	c.JSON(200, ImportResponse{Imported: imported})
+}
+
+// Overwrites the records in the DB, and the ones in memory so they don't get written back over the import
+func (s *Server) importRecords(ctx context.Context, records []importRecord) (int, error) {
+	s.writeMutex.Lock()
+	defer s.writeMutex.Unlock()
+
+	imported := 0
+	for start := 0; start < len(records); start += maxBatchWrites {
+		end := start + maxBatchWrites
+		if end > len(records) {
+			end = len(records)
+		}
+
+		batch := s.batch()
+		for _, record := range records[start:end] {
+			ref := s.db.Collection(collectionName(record.period)).Doc(record.id)
+			batch.Set(ref, s.storable(record.row))
+		}
+
+		_, err := batch.Commit(ctx)
+		if err != nil {
+			return imported, storageError(err)
+		}
+
+		for _, record := range records[start:end] {
+			available, _ := s.periodRecords(record.period)
+			if _, ok := available[record.id]; ok {
+				row := record.row
+				row.Exists = true
+				available[record.id] = row
+				s.markModified(record.period)
+			}
+		}
+		imported += end - start
+	}
+
+	logger.Info("Imported records", zap.Int("count", imported))
+	return imported, nil
+}
@@ -0,0 +1,93 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/lietu/godometer"
+)
+
+func importCSV(srv *Server, path string, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+	req.Header.Set("Content-Type", "text/csv")
+	req.Header.Set("X-Forwarded-Proto", "https")
+	req.Header.Set("Authorization", testAdminAuth)
+
+	rec := httptest.NewRecorder()
+	srv.engine.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestCSVRoundTrip(t *testing.T) {
+	source, _, _ := newTestServer(t, testNow)
+	err := source.writeStats(context.Background(), []godometer.UpdateDataPoint{
+		{Timestamp: "2024-03-06 10:15", Meters: 100, MetersPerSecond: 2, KilometersPerHour: 7.2},
+		{Timestamp: "2024-03-06 12:20", Meters: 100, MetersPerSecond: 2, KilometersPerHour: 7.2},
+		{Timestamp: "2024-03-06 12:21", Meters: 33.5, MetersPerSecond: 1, KilometersPerHour: 3.6},
+	})
+	if err != nil {
+		t.Fatalf("writeStats failed: %s", err)
+	}
+
+	rec := request(source, http.MethodGet, "/api/v1/stream/hours?format=csv", nil, nil)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Export failed with %d", rec.Code)
+	}
+	exported := rec.Body.String()
+	if !strings.Contains(exported, "2024-03-06 12,2,133.5,") {
+		t.Errorf("Export doesn't have the counter and distance of the hour:\n%s", exported)
+	}
+
+	target, fake, _ := newTestServer(t, testNow)
+	target.EnableAdmin(testAdminAuth)
+	if rec := importCSV(target, "/api/v1/admin/import?period=hours", exported); rec.Code != http.StatusOK {
+		t.Fatalf("Import failed with %d: %s", rec.Code, rec.Body.String())
+	}
+	if !fake.has("hours", "2024-03-06 12") {
+		t.Errorf("Imported hour wasn't saved")
+	}
+
+	expected := StatsResponse{}
+	getJSON(t, source, "/api/v1/stats/hours", &expected)
+	imported := StatsResponse{}
+	getJSON(t, target, "/api/v1/stats/hours", &imported)
+	if !reflect.DeepEqual(imported.DataPoints, expected.DataPoints) {
+		t.Errorf("Imported hours differ:\n%+v\nexpected\n%+v", imported.DataPoints, expected.DataPoints)
+	}
+
+	rec = request(target, http.MethodGet, "/api/v1/stream/hours?format=csv", nil, nil)
+	if !bytes.Equal(rec.Body.Bytes(), []byte(exported)) {
+		t.Errorf("Exporting the import gave\n%s\nexpected\n%s", rec.Body.String(), exported)
+	}
+}
+
+func TestImportRejectsMalformedRows(t *testing.T) {
+	srv, fake, _ := newTestServer(t, testNow)
+	srv.EnableAdmin(testAdminAuth)
+
+	tests := map[string]string{
+		"wrong header":      "ts,c,m,mps,kph\n2024-03-06 12,1,1,1,3.6\n",
+		"invalid timestamp": "timestamp,counter,meters,mps,kph\n2024-03-06 12,1,1,1,3.6\nyesterday,1,1,1,3.6\n",
+		"wrong period":      "timestamp,counter,meters,mps,kph\n2024-03-06,1,1,1,3.6\n",
+		"negative counter":  "timestamp,counter,meters,mps,kph\n2024-03-06 12,-1,1,1,3.6\n",
+	}
+	for name, body := range tests {
+		if rec := importCSV(srv, "/api/v1/admin/import?period=hours", body); rec.Code != http.StatusBadRequest {
+			t.Errorf("Import with %s returned %d, expected 400", name, rec.Code)
+		}
+	}
+
+	_, err := parseCSV(strings.NewReader(tests["invalid timestamp"]), "")
+	if err == nil || !strings.HasPrefix(err.Error(), "line 3:") {
+		t.Errorf("Expected an error on line 3, got %v", err)
+	}
+
+	if ids := fake.ids("hours"); len(ids) != 0 {
+		t.Errorf("Malformed imports saved %v", ids)
+	}
+}
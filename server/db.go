@@ -2,21 +2,81 @@ package server
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"hash/fnv"
 	"log"
+	"math"
 	"math/rand"
 	"sort"
+	"strings"
+	"sync"
 	"time"
 
 	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
 
 	"cloud.google.com/go/firestore"
 	"github.com/lietu/godometer"
+	"go.opentelemetry.io/otel/api/trace"
+	"go.opentelemetry.io/otel/label"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
-const debugDb = false
+var (
+	ErrStorageUnavailable = errors.New("storage unavailable")
+	ErrNotFound           = errors.New("not found")
+	ErrMalformedRecord    = errors.New("malformed record")
+)
+
+// Firestore GetAll calls are split into chunks of this many documents, read this many chunks at a time
+const (
+	defaultReadChunkSize = 300
+	readWorkers          = 4
+)
+
+// Dump the records when debug logging
+func debugDb() bool {
+	return logLevel.Enabled(zapcore.DebugLevel)
+}
 
-var utc, _ = time.LoadLocation("UTC")
+// The built-in UTC, unlike time.LoadLocation it doesn't need tzdata so it works in stripped down images too
+var utc = time.UTC
+
+// Wraps DB errors so callers can match them with errors.Is
+func storageError(err error) error {
+	if status.Code(err) == codes.NotFound {
+		return fmt.Errorf("%w: %s", ErrNotFound, err)
+	}
+
+	return fmt.Errorf("%w: %s", ErrStorageUnavailable, err)
+}
+
+// Documents that exist but don't decode into a DBDataPoint, by id
+type DecodeError struct {
+	Collection string
+	Failures   map[string]error
+}
+
+func (e *DecodeError) Error() string {
+	var ids []string
+	for id := range e.Failures {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return fmt.Sprintf("%s: %d in %s: %s", ErrMalformedRecord, len(ids), e.Collection, strings.Join(ids, ", "))
+}
+
+func (e *DecodeError) Unwrap() error {
+	return ErrMalformedRecord
+}
+
+// By default records that fail to decode are logged and read as zeroes, in strict mode reading them fails with a
+// DecodeError instead. The rest of the records are returned either way.
+func (s *Server) SetStrictDecoding(enabled bool) {
+	s.strictDecoding = enabled
+}
 
 type LastEventContainer struct {
 	Events []ResponseDataPoint `firestore:"events"`
@@ -85,14 +145,16 @@ func (s *Server) printLatestRecords() {
 	log.Printf("Latest year:   %s", recordStr(s.years[latestKey(s.years)]))
 }
 
-func (s *Server) loadData() {
-	// Initialize all data structures
-	minutes := Last60Minutes()
-	hours := Last24Hours()
-	days := Last7Days()
-	weeks := Last5Weeks()
-	months := Last12Months()
-	years := Last4Years()
+// Zeroed out records for everything we keep in memory
+func (s *Server) initRecords(now time.Time) {
+	s.markModified(periods...)
+
+	minutes := Last60Minutes(now)
+	hours := Last24Hours(now)
+	days := Last7Days(now)
+	weeks := Last5Weeks(now)
+	months := Last12Months(now)
+	years := Last4Years(now)
 
 	s.minutes = map[string]DBDataPoint{}
 	for _, key := range minutes {
@@ -147,48 +209,234 @@ func (s *Server) loadData() {
 			KilometersPerHour: 0.0,
 		}
 	}
+}
+
+func (s *Server) loadData(ctx context.Context) error {
+	ctx, span := s.tracer.Start(ctx, "loadData")
+	defer span.End()
+
+	// Initialize all data structures
+	now := s.clock.Now()
+	s.initRecords(now)
+
+	minutes := Last60Minutes(now)
+	hours := Last24Hours(now)
+	days := Last7Days(now)
+	weeks := Last5Weeks(now)
+	months := Last12Months(now)
+	years := Last4Years(now)
+
+	// No past events is fine, that just means nothing has been recorded yet
+	eventsErr := s.readEvents(ctx)
+	if errors.Is(eventsErr, ErrNotFound) {
+		eventsErr = nil
+	}
+
+	// Read everything we can, periods that fail keep their zeroed out records
+	errs := []error{
+		eventsErr,
+		s.readYears(ctx, years[:]),
+		s.readMonths(ctx, months[:]),
+		s.readWeeks(ctx, weeks[:]),
+		s.readDays(ctx, days[:]),
+		s.readHours(ctx, hours[:]),
+		s.readMinutes(ctx, minutes[:]),
+	}
+
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
 
-	ctx := context.Background()
-	s.readEvents(ctx)
-	s.readYears(ctx, years[:])
-	s.readMonths(ctx, months[:])
-	s.readWeeks(ctx, weeks[:])
-	s.readDays(ctx, days[:])
-	s.readHours(ctx, hours[:])
-	s.readMinutes(ctx, minutes[:])
+	return nil
 }
 
-func (s *Server) readEvents(ctx context.Context) {
+func (s *Server) readEvents(ctx context.Context) error {
 	s.lastEvents = []ResponseDataPoint{}
 
-	db := GetClient(ctx, s.projectId)
+	db := s.db
 	eventsColl := db.Collection(collectionName("events"))
 	ref := eventsColl.Doc("lastEvents")
 	doc, err := ref.Get(ctx)
 	if err != nil {
 		logger.Warn("Got error trying to load past events", zap.Error(err))
-		return
+		return storageError(err)
 	}
 
 	eventContainer := LastEventContainer{}
 	err = doc.DataTo(&eventContainer)
 	if err != nil {
 		logger.Warn("Got error trying to parse past events", zap.Error(err))
-		return
+		return err
 	}
 
 	s.lastEvents = eventContainer.Events
 
-	if debugDb {
+	if debugDb() {
 		log.Printf("Recent events")
 		for _, e := range s.lastEvents {
 			log.Printf("%s: %.1fm @ %.1fm/s or %.1fkm/h", e.Timestamp, e.Meters, e.MetersPerSecond, e.KilometersPerHour)
 		}
 	}
+
+	return nil
 }
 
-func (s *Server) readRecords(ctx context.Context, collection string, ids []string) map[string]DBDataPoint {
-	db := GetClient(ctx, s.projectId)
+// Rebuilds the event history from minute records older than "before", newest
+// first. Minutes still in memory are used as is, older ones come from the DB.
+func (s *Server) readEventHistory(ctx context.Context, before string, limit int) ([]ResponseDataPoint, error) {
+	// A copy, so the lock isn't held while reading from the DB
+	minutes := map[string]DBDataPoint{}
+	s.writeMutex.RLock()
+	for key, row := range s.minutes {
+		minutes[key] = row
+	}
+	s.writeMutex.RUnlock()
+
+	var keys []string
+	for key := range minutes {
+		keys = append(keys, key)
+	}
+	sort.Sort(sort.Reverse(sort.StringSlice(keys)))
+
+	events := []ResponseDataPoint{}
+	cursor := before
+	for _, key := range keys {
+		if before != "" && key >= before {
+			continue
+		}
+
+		cursor = key
+		row := minutes[key]
+		if row.Counter > 0 {
+			events = append(events, row.toResponseDataPoint(key))
+			if len(events) == limit {
+				return events, nil
+			}
+		}
+	}
+
+	// Only as many as are still needed at a time, so the cost follows the page size and not the whole history.
+	// Minutes without events are skipped, so it may take a few pages.
+	collection := s.db.Collection(collectionName("minutes"))
+	for len(events) < limit {
+		pageSize := limit - len(events)
+		query := collection.OrderBy(firestore.DocumentID, firestore.Desc).Limit(pageSize)
+		if cursor != "" {
+			query = query.StartAfter(cursor)
+		}
+
+		docs, err := query.Documents(ctx).GetAll()
+		if err != nil {
+			logger.Warn("Error fetching event history from DB", zap.Error(err))
+			return events, storageError(err)
+		}
+
+		for _, doc := range docs {
+			cursor = doc.Ref.ID
+			row, err := decodeDataPoint(doc)
+			if err != nil {
+				logger.Warn("Failed to read data from DB to record. This is probably not great.", zap.Error(err))
+				continue
+			}
+			row = migrateDataPoint(row)
+			if row.Counter > 0 {
+				events = append(events, row.toResponseDataPoint(doc.Ref.ID))
+			}
+		}
+
+		if len(docs) < pageSize {
+			break
+		}
+	}
+
+	return events, nil
+}
+
+// Reads the records in chunks of readChunkSize, a few at a time. If some chunks fail the records from the others are
+// still returned along with the error.
+func (s *Server) readRecords(ctx context.Context, collection string, ids []string) (map[string]DBDataPoint, error) {
+	ctx, span := s.tracer.Start(ctx, "readRecords", trace.WithAttributes(
+		label.String("godometer.collection", collection),
+		label.Int("godometer.records", len(ids)),
+	))
+	defer span.End()
+
+	chunkSize := s.readChunkSize
+	if chunkSize <= 0 {
+		chunkSize = defaultReadChunkSize
+	}
+
+	var chunks [][]string
+	for start := 0; start < len(ids); start += chunkSize {
+		end := start + chunkSize
+		if end > len(ids) {
+			end = len(ids)
+		}
+		chunks = append(chunks, ids[start:end])
+	}
+
+	type chunkResult struct {
+		records  map[string]DBDataPoint
+		failures map[string]error
+		err      error
+	}
+
+	results := make([]chunkResult, len(chunks))
+	workers := make(chan bool, readWorkers)
+	wg := sync.WaitGroup{}
+	for i, chunk := range chunks {
+		wg.Add(1)
+		workers <- true
+		go func(i int, chunk []string) {
+			defer wg.Done()
+			records, failures, err := s.readChunk(ctx, collection, chunk)
+			results[i] = chunkResult{records: records, failures: failures, err: err}
+			<-workers
+		}(i, chunk)
+	}
+	wg.Wait()
+
+	records := map[string]DBDataPoint{}
+	failures := map[string]error{}
+	var err error
+	for _, r := range results {
+		if r.err != nil {
+			if err == nil {
+				err = r.err
+			}
+			continue
+		}
+
+		for id, row := range r.records {
+			records[id] = row
+		}
+		for id, failure := range r.failures {
+			failures[id] = failure
+		}
+	}
+
+	if err == nil && len(failures) > 0 {
+		decodeErr := &DecodeError{Collection: collection, Failures: failures}
+		if s.strictDecoding {
+			err = decodeErr
+		} else {
+			logger.Warn("Read malformed records as zeroes", zap.Error(decodeErr))
+		}
+	}
+
+	if err != nil {
+		span.RecordError(ctx, err)
+		return records, err
+	}
+
+	return records, nil
+}
+
+// Returns the records along with the ids of any that failed to decode
+func (s *Server) readChunk(ctx context.Context, collection string, ids []string) (map[string]DBDataPoint, map[string]error, error) {
+	db := s.db
 	collRef := db.Collection(collection)
 	var refs []*firestore.DocumentRef
 	for _, id := range ids {
@@ -198,9 +446,12 @@ func (s *Server) readRecords(ctx context.Context, collection string, ids []strin
 	results, err := db.GetAll(ctx, refs)
 	if err != nil {
 		logger.Warn("Error fetching records from DB", zap.Error(err))
+		return nil, nil, storageError(err)
 	}
 
 	records := map[string]DBDataPoint{}
+	failures := map[string]error{}
+	var outdated []*firestore.DocumentRef
 	for _, r := range results {
 		row := DBDataPoint{
 			Meters:            0.0,
@@ -208,41 +459,76 @@ func (s *Server) readRecords(ctx context.Context, collection string, ids []strin
 			KilometersPerHour: 0.0,
 		}
 
-		// Non-existing rows will be zeroed out, this is ok
+		// Non-existing rows will be zeroed out, this is ok. Exists tells them apart from stored zeroes.
 		if r.Exists() {
-			err := r.DataTo(&row)
+			decoded, err := decodeDataPoint(r)
 			if err != nil {
-				logger.Warn("Failed to read data from DB to record. This is probably not great.", zap.Error(err))
+				// Left zeroed and not migrated, so the document stays as it is for repairing
+				logger.Warn("Failed to decode record", zap.String("collection", collection), zap.String("id", r.Ref.ID), zap.Error(err))
+				failures[r.Ref.ID] = err
+				records[r.Ref.ID] = DBDataPoint{Exists: true}
+				continue
+			}
+			row = decoded
+			row.Exists = true
+
+			if row.SchemaVersion < currentSchemaVersion {
+				row = migrateDataPoint(row)
+				outdated = append(outdated, r.Ref)
 			}
 		}
 		records[r.Ref.ID] = row
 	}
 
-	return records
+	if len(outdated) > 0 {
+		s.saveMigrated(ctx, outdated, records)
+	}
+
+	return records, failures, nil
+}
+
+// Keeps whatever was read even when reading failed, e.g. the good records when some failed to decode. The records
+// missing from what was read stay as they were.
+func mergeRecords(into map[string]DBDataPoint, records map[string]DBDataPoint) {
+	for id, row := range records {
+		into[id] = row
+	}
 }
 
-func (s *Server) readYears(ctx context.Context, years []string) {
-	s.years = s.readRecords(ctx, collectionName("years"), years)
+func (s *Server) readYears(ctx context.Context, years []string) error {
+	records, err := s.readRecords(ctx, collectionName("years"), years)
+	mergeRecords(s.years, records)
+	return err
 }
 
-func (s *Server) readMonths(ctx context.Context, months []string) {
-	s.months = s.readRecords(ctx, collectionName("months"), months)
+func (s *Server) readMonths(ctx context.Context, months []string) error {
+	records, err := s.readRecords(ctx, collectionName("months"), months)
+	mergeRecords(s.months, records)
+	return err
 }
 
-func (s *Server) readWeeks(ctx context.Context, weeks []string) {
-	s.weeks = s.readRecords(ctx, collectionName("weeks"), weeks)
+func (s *Server) readWeeks(ctx context.Context, weeks []string) error {
+	records, err := s.readRecords(ctx, collectionName("weeks"), weeks)
+	mergeRecords(s.weeks, records)
+	return err
 }
 
-func (s *Server) readDays(ctx context.Context, days []string) {
-	s.days = s.readRecords(ctx, collectionName("days"), days)
+func (s *Server) readDays(ctx context.Context, days []string) error {
+	records, err := s.readRecords(ctx, collectionName("days"), days)
+	mergeRecords(s.days, records)
+	return err
 }
 
-func (s *Server) readHours(ctx context.Context, hours []string) {
-	s.hours = s.readRecords(ctx, collectionName("hours"), hours)
+func (s *Server) readHours(ctx context.Context, hours []string) error {
+	records, err := s.readRecords(ctx, collectionName("hours"), hours)
+	mergeRecords(s.hours, records)
+	return err
 }
 
-func (s *Server) readMinutes(ctx context.Context, minutes []string) {
-	s.minutes = s.readRecords(ctx, collectionName("minutes"), minutes)
+func (s *Server) readMinutes(ctx context.Context, minutes []string) error {
+	records, err := s.readRecords(ctx, collectionName("minutes"), minutes)
+	mergeRecords(s.minutes, records)
+	return err
 }
 
 func stringInList(items []string, item string) bool {
@@ -255,14 +541,16 @@ func stringInList(items []string, item string) bool {
 	return false
 }
 
-func (s *Server) clearOldStats() {
+// Returns the minutes with data that were dropped from memory, so they can be compacted
+func (s *Server) clearOldStats() map[string]DBDataPoint {
 	// List of data we want to store
-	minutes := Last60Minutes()
-	hours := Last24Hours()
-	days := Last7Days()
-	weeks := Last5Weeks()
-	months := Last12Months()
-	years := Last4Years()
+	now := s.clock.Now()
+	minutes := Last60Minutes(now)
+	hours := Last24Hours(now)
+	days := Last7Days(now)
+	weeks := Last5Weeks(now)
+	months := Last12Months(now)
+	years := Last4Years(now)
 
 	// Create any missing keys
 	for _, key := range minutes {
@@ -332,8 +620,12 @@ func (s *Server) clearOldStats() {
 	}
 
 	// Strip out any extra ones
-	for key := range s.minutes {
+	aged := map[string]DBDataPoint{}
+	for key, row := range s.minutes {
 		if !stringInList(minutes[:], key) {
+			if row.Counter > 0 {
+				aged[key] = row
+			}
 			delete(s.minutes, key)
 		}
 	}
@@ -367,32 +659,77 @@ func (s *Server) clearOldStats() {
 			delete(s.years, key)
 		}
 	}
+
+	return aged
+}
+
+func abs32(value float32) float32 {
+	return float32(math.Abs(float64(value)))
+}
+
+// With signed meters, moving backwards is just as much movement as going forwards
+func hasMeters(row DBDataPoint, signed bool) bool {
+	if signed {
+		return row.Meters != 0
+	}
+	return row.Meters > 0
+}
+
+// How long it took to move the distance at the given speed
+func movingSeconds(row DBDataPoint) float32 {
+	if row.MetersPerSecond <= 0 {
+		return 0
+	}
+	return abs32(row.Meters) / row.MetersPerSecond
+}
+
+// An update counts as a sample, adding to the counter and speed averages, when its distance and speed are above
+// these. The zero value only counts updates with some distance and speed. Negative values let e.g. updates with
+// distance but an exact zero speed count too.
+type sampleThreshold struct {
+	minMeters float32
+	// In m/s, km/h is compared against the same speed
+	minSpeed float32
 }
 
-func calculateUpdate(old DBDataPoint, ok bool, newRow DBDataPoint) (DBDataPoint, bool) {
+func (t sampleThreshold) isSample(row DBDataPoint, signed bool) bool {
+	meters := row.Meters
+	if signed {
+		meters = abs32(meters)
+	}
+	return meters > t.minMeters && row.MetersPerSecond > t.minSpeed && row.KilometersPerHour > t.minSpeed*3.6
+}
+
+func (s *Server) SetSampleThreshold(minMeters float32, minSpeed float32) {
+	s.sampleThreshold = sampleThreshold{minMeters: minMeters, minSpeed: minSpeed}
+}
+
+// Speeds are averaged over the time spent moving, i.e. distance over moving time. Each update is weighted by how
+// long it took to travel its distance, so the average doesn't depend on how many updates happened to be sent.
+// With signed meters negative distance is subtracted from the total, speeds are expected to be magnitudes.
+func calculateUpdate(old DBDataPoint, ok bool, newRow DBDataPoint, signed bool, threshold sampleThreshold) (DBDataPoint, bool) {
 	result := newRow
 	save := false
 
 	if ok {
-		totalMPS := (old.MetersPerSecond * float32(old.Counter)) + newRow.MetersPerSecond
-		totalKPH := (old.KilometersPerHour * float32(old.Counter)) + newRow.KilometersPerHour
+		result = old
+		result.Millimeters = old.Millimeters + newRow.Millimeters
+		result.Meters = float32(result.Millimeters) / 1000
+		save = newRow.Meters != 0
 
-		result = DBDataPoint{}
 		// Only count updates with actual data in them
-		if newRow.Meters > 0 && newRow.MetersPerSecond > 0 && newRow.KilometersPerHour > 0 {
+		if threshold.isSample(newRow, signed) {
+			seconds := movingSeconds(newRow)
 			result.Counter = old.Counter + 1
+			result.MovingSeconds = old.MovingSeconds + seconds
+			// Samples without speed don't add any moving time, so there's nothing to average yet
+			if result.MovingSeconds > 0 {
+				result.MetersPerSecond = (old.MetersPerSecond*old.MovingSeconds + newRow.MetersPerSecond*seconds) / result.MovingSeconds
+				result.KilometersPerHour = (old.KilometersPerHour*old.MovingSeconds + newRow.KilometersPerHour*seconds) / result.MovingSeconds
+				result.MillimetersPerSecond = toMillis(result.MetersPerSecond)
+			}
 			save = true
 		}
-
-		result.Meters = old.Meters + newRow.Meters
-
-		if result.Counter > 0 {
-			result.MetersPerSecond = totalMPS / float32(result.Counter)
-			result.KilometersPerHour = totalKPH / float32(result.Counter)
-		} else {
-			result.MetersPerSecond = 0
-			result.KilometersPerHour = 0
-		}
 	} else {
 		save = true
 	}
@@ -400,17 +737,93 @@ func calculateUpdate(old DBDataPoint, ok bool, newRow DBDataPoint) (DBDataPoint,
 	return result, save
 }
 
-func (s *Server) isKnownEvent(dataPoint godometer.UpdateDataPoint) bool {
-	for _, dp := range s.lastEvents {
-		if dp.Timestamp == dataPoint.Timestamp {
-			return true
-		}
+// Adds up two records made of any number of updates, weighing the speeds by moving time like calculateUpdate
+func addRecords(old DBDataPoint, add DBDataPoint) DBDataPoint {
+	result := old
+	result.Millimeters = old.Millimeters + add.Millimeters
+	result.Meters = float32(result.Millimeters) / 1000
+	result.Counter = old.Counter + add.Counter
+	result.MovingSeconds = old.MovingSeconds + add.MovingSeconds
+	if add.MovingSeconds > 0 {
+		result.MetersPerSecond = (old.MetersPerSecond*old.MovingSeconds + add.MetersPerSecond*add.MovingSeconds) / result.MovingSeconds
+		result.KilometersPerHour = (old.KilometersPerHour*old.MovingSeconds + add.KilometersPerHour*add.MovingSeconds) / result.MovingSeconds
+		result.MillimetersPerSecond = toMillis(result.MetersPerSecond)
 	}
+	return result
+}
 
-	return false
+// One or more new events of a minute, to add to the records in one go
+type recordUpdate struct {
+	ts         time.Time
+	row        DBDataPoint
+	eventIds   []string
+	dataPoints []godometer.UpdateDataPoint
+}
+
+// Coalesces another event of the minute into the update, adding them up like the aggregates would
+func (ru recordUpdate) add(udp godometer.UpdateDataPoint, id string, row DBDataPoint, signed bool, threshold sampleThreshold) recordUpdate {
+	if len(ru.eventIds) == 1 {
+		ru.row, _ = calculateUpdate(DBDataPoint{}, true, ru.row, signed, threshold)
+	}
+	ru.row, _ = calculateUpdate(ru.row, true, row, signed, threshold)
+	ru.eventIds = append(ru.eventIds, id)
+	ru.dataPoints = append(ru.dataPoints, udp)
+	return ru
+}
+
+func (ru recordUpdate) apply(old DBDataPoint, ok bool, signed bool, threshold sampleThreshold) (DBDataPoint, bool) {
+	if len(ru.eventIds) == 1 {
+		return calculateUpdate(old, ok, ru.row, signed, threshold)
+	}
+	if !ok {
+		return ru.row, true
+	}
+	return addRecords(old, ru.row), ru.row.Meters != 0 || ru.row.Counter > 0
+}
+
+// Identifies an event by everything the client sent, so a resend is the same event but another reading for the same
+// minute isn't. Monitors from before this merged a new reading into the data point they had already sent for the
+// same minute and sent that again, which looks like a new event and counts the first reading twice.
+func eventId(udp godometer.UpdateDataPoint) string {
+	h := fnv.New64a()
+	_, _ = fmt.Fprintf(h, "%s|%x|%x|%x", udp.Timestamp, math.Float32bits(udp.Meters), math.Float32bits(udp.MetersPerSecond), math.Float32bits(udp.KilometersPerHour))
+	return fmt.Sprintf("%x", h.Sum64())
+}
+
+// Minutes without event ids, from before events had them, fake data or a CSV import, can't tell which events they
+// have, so every event is new to them
+func (ddp DBDataPoint) hasEvent(id string) bool {
+	if ddp.Events == "" {
+		return false
+	}
+	return stringInList(strings.Split(ddp.Events, ","), id)
+}
+
+func (ddp DBDataPoint) withEvents(ids []string) DBDataPoint {
+	if ddp.Events != "" {
+		ids = append(strings.Split(ddp.Events, ","), ids...)
+	}
+	ddp.Events = strings.Join(ids, ",")
+	return ddp
 }
 
+// Keeps the latest events by time, batches aren't necessarily in order. Timestamps in minuteLayout sort
+// chronologically as strings. For duplicate timestamps the last one added wins.
 func (s *Server) cleanLastEvents() {
+	byTimestamp := map[string]ResponseDataPoint{}
+	for _, e := range s.lastEvents {
+		byTimestamp[e.Timestamp] = e
+	}
+
+	events := make([]ResponseDataPoint, 0, len(byTimestamp))
+	for _, e := range byTimestamp {
+		events = append(events, e)
+	}
+	sort.Slice(events, func(i, j int) bool {
+		return events[i].Timestamp < events[j].Timestamp
+	})
+	s.lastEvents = events
+
 	max := 5
 	current := len(s.lastEvents)
 	keep := 0
@@ -422,7 +835,138 @@ func (s *Server) cleanLastEvents() {
 	s.lastEvents = s.lastEvents[keep:]
 }
 
-func (s *Server) writeStats(ctx context.Context, updateDataPoints []godometer.UpdateDataPoint) {
+// Minute records are committed in the same batch as the aggregates they were added to, and list the ids of the events
+// added to them, so an event in its minute record has already been counted in every period. Minutes older than the
+// ones in memory are read from the DB, which catches replays of older events e.g. after a restart. Returns the ids
+// of the committed events.
+func (s *Server) committedEvents(ctx context.Context, updateDataPoints []godometer.UpdateDataPoint) (map[string]bool, error) {
+	minutes := map[string]DBDataPoint{}
+	var missing []string
+	for _, udp := range updateDataPoints {
+		ts, err := time.Parse(minuteLayout, udp.Timestamp)
+		if err != nil {
+			continue
+		}
+		minute := ts.Format(minuteLayout)
+
+		row, ok := s.minutes[minute]
+		if ok {
+			minutes[minute] = row
+		} else if !stringInList(missing, minute) {
+			missing = append(missing, minute)
+		}
+	}
+
+	if len(missing) > 0 {
+		records, err := s.readRecords(ctx, collectionName("minutes"), missing)
+		if err != nil {
+			return nil, err
+		}
+
+		for id, row := range records {
+			minutes[id] = row
+		}
+	}
+
+	committed := map[string]bool{}
+	for _, udp := range updateDataPoints {
+		ts, err := time.Parse(minuteLayout, udp.Timestamp)
+		if err != nil {
+			continue
+		}
+
+		id := eventId(udp)
+		if minutes[ts.Format(minuteLayout)].hasEvent(id) {
+			committed[id] = true
+		}
+	}
+
+	return committed, nil
+}
+
+func markExists(records map[string]DBDataPoint, ids []string) {
+	for _, id := range ids {
+		row := records[id]
+		row.Exists = true
+		records[id] = row
+	}
+}
+
+// A record as it was before writeStats changed it
+type recordSnapshot struct {
+	records map[string]DBDataPoint
+	id      string
+	row     DBDataPoint
+	ok      bool
+}
+
+func (rs recordSnapshot) restore() {
+	if rs.ok {
+		rs.records[rs.id] = rs.row
+	} else {
+		delete(rs.records, rs.id)
+	}
+}
+
+// Saving the records to the DB failed. Firestore batches are atomic, so none of the documents were written. A mirror
+// failing after the DB has them is a MirrorError instead.
+type CommitError struct {
+	DocIds []string
+	Err    error
+}
+
+func (e *CommitError) Error() string {
+	return fmt.Sprintf("failed to save %d records (%s): %s", len(e.DocIds), strings.Join(e.DocIds, ", "), e.Err)
+}
+
+func (e *CommitError) Unwrap() error {
+	return e.Err
+}
+
+func docId(ref *firestore.DocumentRef) string {
+	return fmt.Sprintf("%s/%s", ref.Parent.ID, ref.ID)
+}
+
+// Orders the batch by time so the aggregates and the events feed don't depend on the order the client sent them
+// in. Entries with timestamps that don't parse are dropped here. The sort is stable, so points with the same
+// timestamp keep the order they were sent in; resends of the same point are skipped, and different points for the
+// same minute are merged into the minute record via calculateUpdate.
+func sortedByTimestamp(updateDataPoints []godometer.UpdateDataPoint) []godometer.UpdateDataPoint {
+	type timedDataPoint struct {
+		udp godometer.UpdateDataPoint
+		ts  time.Time
+	}
+
+	timed := make([]timedDataPoint, 0, len(updateDataPoints))
+	for _, udp := range updateDataPoints {
+		ts, err := time.Parse(minuteLayout, udp.Timestamp)
+		if err != nil {
+			logger.Warn("Failed to parse time", zap.String("timestamp", udp.Timestamp), zap.Error(err))
+			continue
+		}
+		timed = append(timed, timedDataPoint{udp: udp, ts: ts})
+	}
+
+	sort.SliceStable(timed, func(i, j int) bool {
+		return timed[i].ts.Before(timed[j].ts)
+	})
+
+	sorted := make([]godometer.UpdateDataPoint, 0, len(timed))
+	for _, t := range timed {
+		sorted = append(sorted, t.udp)
+	}
+	return sorted
+}
+
+func (s *Server) writeStats(ctx context.Context, updateDataPoints []godometer.UpdateDataPoint) error {
+	s.writeMutex.Lock()
+	defer s.writeMutex.Unlock()
+
+	ctx, span := s.tracer.Start(ctx, "writeStats", trace.WithAttributes(
+		label.Int("godometer.events", len(updateDataPoints)),
+	))
+	defer span.End()
+
 	var years []string
 	var months []string
 	var weeks []string
@@ -431,10 +975,45 @@ func (s *Server) writeStats(ctx context.Context, updateDataPoints []godometer.Up
 	var minutes []string
 	var newEvents []string
 
-	newDataPoints := 0
+	if err := ctx.Err(); err != nil {
+		span.RecordError(ctx, err)
+		return err
+	}
+
+	updateDataPoints = sortedByTimestamp(updateDataPoints)
+	committed, err := s.committedEvents(ctx, updateDataPoints)
+	if err != nil {
+		span.RecordError(ctx, err)
+		return err
+	}
+
+	// Bailing out before committing puts the in-memory records back as they were
+	var snapshots []recordSnapshot
+	remember := func(records map[string]DBDataPoint, id string) {
+		row, ok := records[id]
+		snapshots = append(snapshots, recordSnapshot{records: records, id: id, row: row, ok: ok})
+	}
+	lastEvents := s.lastEvents
+	rollback := func() {
+		for i := len(snapshots) - 1; i >= 0; i-- {
+			snapshots[i].restore()
+		}
+		s.lastEvents = lastEvents
+	}
+
+	// Each new event is added to the records on its own, or with coalescing the new events of a minute together
+	var updates []recordUpdate
 	for _, udp := range updateDataPoints {
-		// Ignore already processed events
-		if s.isKnownEvent(udp) {
+		// Ignore already processed events, resends within the batch too
+		id := eventId(udp)
+		if committed[id] {
+			continue
+		}
+		committed[id] = true
+
+		ts, err := time.Parse(minuteLayout, udp.Timestamp)
+		if err != nil {
+			logger.Warn("Failed to parse time", zap.String("timestamp", udp.Timestamp), zap.Error(err))
 			continue
 		}
 
@@ -445,12 +1024,37 @@ func (s *Server) writeStats(ctx context.Context, updateDataPoints []godometer.Up
 			KilometersPerHour: udp.KilometersPerHour,
 		}
 
-		ts, err := time.Parse(minuteLayout, udp.Timestamp)
-		if err != nil {
-			logger.Warn("Failed to parse time", zap.String("timestamp", udp.Timestamp), zap.Error(err))
+		if s.signedMeters {
+			currentDataPoint.MetersPerSecond = abs32(currentDataPoint.MetersPerSecond)
+			currentDataPoint.KilometersPerHour = abs32(currentDataPoint.KilometersPerHour)
+		}
+		currentDataPoint.MovingSeconds = movingSeconds(currentDataPoint)
+		currentDataPoint = currentDataPoint.withIntegers()
+
+		// The batch is sorted, so the events of a minute are next to each other
+		if last := len(updates) - 1; s.coalesce && last >= 0 && updates[last].ts.Equal(ts) {
+			updates[last] = updates[last].add(udp, id, currentDataPoint, s.signedMeters, s.sampleThreshold)
 			continue
 		}
 
+		updates = append(updates, recordUpdate{
+			ts:         ts,
+			row:        currentDataPoint,
+			eventIds:   []string{id},
+			dataPoints: []godometer.UpdateDataPoint{udp},
+		})
+	}
+
+	newDataPoints := 0
+	var processed []godometer.UpdateDataPoint
+	for _, update := range updates {
+		if err := ctx.Err(); err != nil {
+			rollback()
+			span.RecordError(ctx, err)
+			return err
+		}
+
+		ts := update.ts
 		year := ts.Format(yearLayout)
 		month := ts.Format(monthLayout)
 		week := weekFormat(ts)
@@ -463,18 +1067,26 @@ func (s *Server) writeStats(ctx context.Context, updateDataPoints []godometer.Up
 		weekRow, weeksOk := s.weeks[week]
 		dayRow, daysOk := s.days[day]
 		hourRow, hoursOk := s.hours[hour]
-		_, minutesOk := s.minutes[minute]
+		minuteRow, minutesOk := s.minutes[minute]
 
-		yearRow, saveYear := calculateUpdate(yearRow, yearsOk, currentDataPoint)
-		monthRow, saveMonth := calculateUpdate(monthRow, monthsOk, currentDataPoint)
-		weekRow, saveWeek := calculateUpdate(weekRow, weeksOk, currentDataPoint)
-		dayRow, saveDay := calculateUpdate(dayRow, daysOk, currentDataPoint)
-		hourRow, saveHour := calculateUpdate(hourRow, hoursOk, currentDataPoint)
+		yearRow, saveYear := update.apply(yearRow, yearsOk, s.signedMeters, s.sampleThreshold)
+		monthRow, saveMonth := update.apply(monthRow, monthsOk, s.signedMeters, s.sampleThreshold)
+		weekRow, saveWeek := update.apply(weekRow, weeksOk, s.signedMeters, s.sampleThreshold)
+		dayRow, saveDay := update.apply(dayRow, daysOk, s.signedMeters, s.sampleThreshold)
+		hourRow, saveHour := update.apply(hourRow, hoursOk, s.signedMeters, s.sampleThreshold)
 		saveMinute := false
-		if currentDataPoint.Meters > 0 || currentDataPoint.MetersPerSecond > 0 || currentDataPoint.KilometersPerHour > 0 || minutesOk {
+		if hasMeters(update.row, s.signedMeters) || update.row.MetersPerSecond > 0 || update.row.KilometersPerHour > 0 || minutesOk {
 			saveMinute = true
 		}
 
+		// Further events for a minute with data add up like in the other periods
+		if minutesOk && (minuteRow.Counter > 0 || minuteRow.Events != "") {
+			minuteRow, _ = update.apply(minuteRow, true, s.signedMeters, s.sampleThreshold)
+		} else {
+			minuteRow = update.row
+		}
+		minuteRow = minuteRow.withEvents(update.eventIds)
+
 		if saveYear && !stringInList(years, year) {
 			years = append(years, year)
 		}
@@ -499,22 +1111,33 @@ func (s *Server) writeStats(ctx context.Context, updateDataPoints []godometer.Up
 			minutes = append(minutes, minute)
 		}
 
+		remember(s.years, year)
+		remember(s.months, month)
+		remember(s.weeks, week)
+		remember(s.days, day)
+		remember(s.hours, hour)
+		remember(s.minutes, minute)
+
 		s.years[year] = yearRow
 		s.months[month] = monthRow
 		s.weeks[week] = weekRow
 		s.days[day] = dayRow
 		s.hours[hour] = hourRow
-		s.minutes[minute] = currentDataPoint
-
-		s.lastEvents = append(s.lastEvents, currentDataPoint.toResponseDataPoint(udp.Timestamp))
-		newDataPoints += 1
-		newEvents = append(newEvents, udp.Timestamp)
+		s.minutes[minute] = minuteRow
+
+		// The feed has one entry per minute, with everything in it so far
+		s.lastEvents = append(s.lastEvents, minuteRow.toResponseDataPoint(minute))
+		for _, udp := range update.dataPoints {
+			processed = append(processed, udp)
+			newDataPoints += 1
+			newEvents = append(newEvents, udp.Timestamp)
+		}
 	}
 
 	s.cleanLastEvents()
 
-	db := GetClient(ctx, s.projectId)
-	batch := db.Batch()
+	db := s.db
+	batch := s.batch()
 
 	eventsColl := db.Collection(collectionName("events"))
 	yearsColl := db.Collection(collectionName("years"))
@@ -525,51 +1148,75 @@ func (s *Server) writeStats(ctx context.Context, updateDataPoints []godometer.Up
 	minutesColl := db.Collection(collectionName("minutes"))
 
 	batchRecords := 0
+	var docIds []string
 
 	if newDataPoints > 0 {
 		batchRecords += 1
 		eventContainer := LastEventContainer{
 			Events: s.lastEvents,
 		}
-		batch.Set(eventsColl.Doc("lastEvents"), eventContainer)
+		ref := eventsColl.Doc("lastEvents")
+		docIds = append(docIds, docId(ref))
+		batch.Set(ref, eventContainer)
 	}
 
 	for _, id := range years {
 		batchRecords += 1
 		ref := yearsColl.Doc(id)
-		batch.Set(ref, s.years[id])
+		docIds = append(docIds, docId(ref))
+		batch.Set(ref, s.storable(s.years[id]))
 	}
 
 	for _, id := range months {
 		batchRecords += 1
 		ref := monthsColl.Doc(id)
-		batch.Set(ref, s.months[id])
+		docIds = append(docIds, docId(ref))
+		batch.Set(ref, s.storable(s.months[id]))
 	}
 
 	for _, id := range weeks {
 		batchRecords += 1
 		ref := weeksColl.Doc(id)
-		batch.Set(ref, s.weeks[id])
+		docIds = append(docIds, docId(ref))
+		batch.Set(ref, s.storable(s.weeks[id]))
 	}
 
 	for _, id := range days {
 		batchRecords += 1
 		ref := daysColl.Doc(id)
-		batch.Set(ref, s.days[id])
+		docIds = append(docIds, docId(ref))
+		batch.Set(ref, s.storable(s.days[id]))
 	}
 
 	for _, id := range hours {
 		batchRecords += 1
 		ref := hoursColl.Doc(id)
-		batch.Set(ref, s.hours[id])
+		docIds = append(docIds, docId(ref))
+		batch.Set(ref, s.storable(s.hours[id]))
 	}
 
 	for _, id := range minutes {
 		batchRecords += 1
 		ref := minutesColl.Doc(id)
-		batch.Set(ref, s.minutes[id])
+		docIds = append(docIds, docId(ref))
+		batch.Set(ref, s.storable(s.minutes[id]))
+	}
+
+	// Last chance to give up without the DB and memory disagreeing
+	if err := ctx.Err(); err != nil {
+		rollback()
+		span.RecordError(ctx, err)
+		return err
 	}
 
+	for period, ids := range map[string][]string{"years": years, "months": months, "weeks": weeks, "days": days, "hours": hours, "minutes": minutes} {
+		if len(ids) > 0 {
+			s.markModified(period)
+		}
+	}
+
+	var commitErr *CommitError
+	var mirrorErr *MirrorError
 	if batchRecords > 0 {
 		var keys []string
 		keys = append(keys, years...)
@@ -578,42 +1225,95 @@ func (s *Server) writeStats(ctx context.Context, updateDataPoints []godometer.Up
 		keys = append(keys, days...)
 		keys = append(keys, hours...)
 		keys = append(keys, minutes...)
-		logger.Info("Processed events", zap.Strings("events", newEvents))
-		logger.Info("Saving records to DB", zap.Int("count", batchRecords), zap.Strings("keys", keys))
+		commitLogger.Info("Processed events", zap.Strings("events", newEvents))
+		commitStart := s.clock.Now()
 		_, err := batch.Commit(ctx)
+		// The DB has the records, so they're kept and the error is returned once done with them
+		if errors.As(err, &mirrorErr) {
+			span.RecordError(ctx, err)
+			err = nil
+		}
+		commitDuration := s.clock.Now().Sub(commitStart)
+		span.SetAttributes(
+			label.Int("godometer.records", batchRecords),
+			label.Int64("godometer.commit_duration_ms", commitDuration.Milliseconds()),
+		)
+		s.metrics.commitDuration.Observe(commitDuration.Seconds())
+		s.metrics.commitWrites.Observe(float64(batchRecords))
+		s.breaker.record(s.clock.Now(), err == nil)
 		if err != nil {
-			logger.Warn("Error trying to save records to DB", zap.Error(err))
+			// Nothing was saved, so a retry of the same events must not be skipped as a replay
+			rollback()
+			span.RecordError(ctx, err)
+			logger.Warn("Error trying to save records to DB", zap.Strings("failed", docIds), zap.Duration("duration", commitDuration), zap.Error(err))
+			commitErr = &CommitError{
+				DocIds: docIds,
+				Err:    storageError(err),
+			}
+		} else {
+			commitLogger.Info("Saved records to DB", zap.Int("count", batchRecords), zap.Strings("keys", keys), zap.Duration("duration", commitDuration))
+			markExists(s.years, years)
+			markExists(s.months, months)
+			markExists(s.weeks, weeks)
+			markExists(s.days, days)
+			markExists(s.hours, hours)
+			markExists(s.minutes, minutes)
 		}
 	} else {
-		logger.Info("How strange, no records updated")
+		commitLogger.Info("How strange, no records updated")
 	}
 
-	s.clearOldStats()
+	// Only once saved, so failed commits that get retried don't alert again
+	if commitErr == nil {
+		for _, udp := range processed {
+			s.checkSpeedAlert(udp)
+		}
+	}
 
-	if debugDb {
+	aged := s.clearOldStats()
+	if commitErr == nil {
+		err := s.compactMinutes(ctx, aged)
+		if err != nil {
+			logger.Warn("Failed to compact minutes", zap.Error(err))
+		}
+	}
+
+	if debugDb() {
 		s.printLatestRecords()
 	}
-}
 
-var firestoreClient *firestore.Client
+	if commitErr != nil {
+		return commitErr
+	}
 
-func GetClient(ctx context.Context, projectId string) *firestore.Client {
-	if firestoreClient == nil {
-		c, err := firestore.NewClient(ctx, projectId)
-		if err != nil {
-			logger.Panic("Failed to connect to DB", zap.Error(err))
-		}
+	if mirrorErr != nil {
+		return mirrorErr
+	}
 
-		firestoreClient = c
+	return nil
+}
+
+func NewClient(ctx context.Context, projectId string) *firestore.Client {
+	c, err := firestore.NewClient(ctx, projectId)
+	if err != nil {
+		logger.Panic("Failed to connect to DB", zap.Error(err))
 	}
 
-	return firestoreClient
+	return c
+}
+
+// The windows should end right before the next key, if they don't the clock or formatting is off somehow. The loops
+// are bounded by the window size so this can't go out of range or spin, but the window won't be right either.
+func checkWindow(period string, filled int, size int, reachedNext bool) {
+	if filled != size || !reachedNext {
+		logger.Warn("Window did not line up", zap.String("period", period), zap.Int("filled", filled), zap.Int("size", size), zap.Bool("reachedNext", reachedNext))
+	}
 }
 
-func Last60Minutes() [60]string {
+func Last60Minutes(now time.Time) [60]string {
 	var minutes [60]string
 	step := time.Minute
-	now := time.Now().In(utc)
+	now = now.In(utc)
 	nextStr := now.Add(step).Format(minuteLayout)
 	start := now.Add(-59 * step)
 
@@ -621,20 +1321,22 @@ func Last60Minutes() [60]string {
 	currentStr := current.Format(minuteLayout)
 
 	index := 0
-	for currentStr != nextStr {
+	for index < len(minutes) && currentStr != nextStr {
 		minutes[index] = currentStr
 		current = current.Add(step)
 		currentStr = current.Format(minuteLayout)
 		index += 1
 	}
 
+	checkWindow("minutes", index, len(minutes), currentStr == nextStr)
+
 	return minutes
 }
 
-func Last24Hours() [24]string {
+func Last24Hours(now time.Time) [24]string {
 	var hours [24]string
 	step := time.Hour
-	now := time.Now().In(utc)
+	now = now.In(utc)
 	nextStr := now.Add(step).Format(hourLayout)
 	start := now.Add(-23 * step)
 
@@ -642,20 +1344,22 @@ func Last24Hours() [24]string {
 	currentStr := current.Format(hourLayout)
 
 	index := 0
-	for currentStr != nextStr {
+	for index < len(hours) && currentStr != nextStr {
 		hours[index] = currentStr
 		current = current.Add(step)
 		currentStr = current.Format(hourLayout)
 		index += 1
 	}
 
+	checkWindow("hours", index, len(hours), currentStr == nextStr)
+
 	return hours
 }
 
-func Last7Days() [7]string {
+func Last7Days(now time.Time) [7]string {
 	var days [7]string
 	step := time.Hour * 24
-	now := time.Now().In(utc)
+	now = now.In(utc)
 	nextStr := now.Add(step).Format(dayLayout)
 	start := now.Add(-6 * step)
 
@@ -663,20 +1367,22 @@ func Last7Days() [7]string {
 	currentStr := current.Format(dayLayout)
 
 	index := 0
-	for currentStr != nextStr {
+	for index < len(days) && currentStr != nextStr {
 		days[index] = currentStr
 		current = current.Add(step)
 		currentStr = current.Format(dayLayout)
 		index += 1
 	}
 
+	checkWindow("days", index, len(days), currentStr == nextStr)
+
 	return days
 }
 
-func Last5Weeks() [5]string {
+func Last5Weeks(now time.Time) [5]string {
 	var weeks [5]string
 	step := time.Hour * 24 * 7
-	now := time.Now().In(utc)
+	now = now.In(utc)
 	nextStr := weekFormat(now.Add(step))
 	start := now.Add(-4 * step)
 
@@ -684,19 +1390,23 @@ func Last5Weeks() [5]string {
 	currentStr := weekFormat(current)
 
 	index := 0
-	for currentStr != nextStr {
+	for index < len(weeks) && currentStr != nextStr {
 		weeks[index] = currentStr
 		current = current.Add(step)
 		currentStr = weekFormat(current)
 		index += 1
 	}
 
+	checkWindow("weeks", index, len(weeks), currentStr == nextStr)
+
 	return weeks
 }
 
-func Last12Months() [12]string {
+func Last12Months(now time.Time) [12]string {
 	var months [12]string
-	now := time.Now().In(utc)
+	now = now.In(utc)
+	// Step from the first of the month, AddDate overflows e.g. Jan 31st + 1 month into March
+	now = time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, utc)
 	nextStr := now.AddDate(0, 1, 0).Format(monthLayout)
 	start := now.AddDate(0, -11, 0)
 
@@ -704,19 +1414,21 @@ func Last12Months() [12]string {
 	currentStr := current.Format(monthLayout)
 
 	index := 0
-	for currentStr != nextStr {
+	for index < len(months) && currentStr != nextStr {
 		months[index] = currentStr
 		current = current.AddDate(0, 1, 0)
 		currentStr = current.Format(monthLayout)
 		index += 1
 	}
 
+	checkWindow("months", index, len(months), currentStr == nextStr)
+
 	return months
 }
 
-func Last4Years() [4]string {
+func Last4Years(now time.Time) [4]string {
 	var years [4]string
-	now := time.Now().In(utc)
+	now = now.In(utc)
 	nextStr := now.AddDate(1, 0, 0).Format(yearLayout)
 	start := now.AddDate(-3, 0, 0)
 
@@ -724,68 +1436,120 @@ func Last4Years() [4]string {
 	currentStr := current.Format(yearLayout)
 
 	index := 0
-	for currentStr != nextStr {
+	for index < len(years) && currentStr != nextStr {
 		years[index] = currentStr
 		current = current.AddDate(1, 0, 0)
 		currentStr = current.Format(yearLayout)
 		index += 1
 	}
 
+	checkWindow("years", index, len(years), currentStr == nextStr)
+
 	return years
 }
 
-func fakeDataPoint() DBDataPoint {
+// The fake data is a random walk, its position is kept in the DB so it carries on from the same spot after a restart
+type fakeWalkState struct {
+	Meters float64 `firestore:"meters"`
+}
+
+func (s *Server) fakeWalkRef() *firestore.DocumentRef {
+	return s.db.Collection(collectionName("events")).Doc("fakeWalk")
+}
+
+func (s *Server) loadFakeWalk(ctx context.Context) {
+	doc, err := s.fakeWalkRef().Get(ctx)
+	if err != nil {
+		if !errors.Is(storageError(err), ErrNotFound) {
+			logger.Warn("Failed to load fake data walk, starting from zero", zap.Error(err))
+		}
+		return
+	}
+
+	state := fakeWalkState{}
+	err = doc.DataTo(&state)
+	if err != nil {
+		logger.Warn("Failed to read fake data walk, starting from zero", zap.Error(err))
+		return
+	}
+	s.fakeMeters = state.Meters
+}
+
+func (s *Server) saveFakeWalk(ctx context.Context) {
+	_, err := s.fakeWalkRef().Set(ctx, fakeWalkState{Meters: s.fakeMeters})
+	if err != nil {
+		logger.Warn("Failed to save fake data walk", zap.Error(err))
+	}
+}
+
+func (s *Server) fakeDataPoint() DBDataPoint {
 	metersChange := rand.Float64() * 50.0
-	if prevFakeMeters-metersChange > 0 && prevFakeMeters+metersChange < maxFakeMeters {
+	if s.fakeMeters-metersChange > 0 && s.fakeMeters+metersChange < maxFakeMeters {
 		dir := rand.Int31n(1) == 1
 		if !dir {
 			metersChange = -metersChange
 		}
-	} else if prevFakeMeters+metersChange > maxFakeMeters {
+	} else if s.fakeMeters+metersChange > maxFakeMeters {
 		metersChange = -metersChange
 	}
 
-	meters := prevFakeMeters + metersChange
+	meters := s.fakeMeters + metersChange
 
 	mps := float32(meters / 60.0)
 	kph := mps * 3600.0 / 1000.0
 
-	prevFakeMeters = meters
+	s.fakeMeters = meters
 
-	return DBDataPoint{
+	dp := DBDataPoint{
 		Counter:           1,
 		Meters:            float32(meters),
 		MetersPerSecond:   mps,
 		KilometersPerHour: kph,
 	}
+	dp.MovingSeconds = movingSeconds(dp)
+
+	return dp.withIntegers()
 }
 
+// Caller must hold writeMutex
 func (s *Server) fillFakeDataRecords(records map[string]DBDataPoint) {
 	for key := range records {
-		records[key] = fakeDataPoint()
+		records[key] = s.fakeDataPoint()
 	}
 }
 
-func (s *Server) generateFakeData() {
+// Generates a fake event every interval until the context is cancelled
+func (s *Server) generateFakeData(ctx context.Context, interval time.Duration) {
+	s.loadFakeWalk(ctx)
+
 	// Initialize all data structures
+	s.writeMutex.Lock()
 	s.fillFakeDataRecords(s.years)
 	s.fillFakeDataRecords(s.months)
 	s.fillFakeDataRecords(s.weeks)
 	s.fillFakeDataRecords(s.days)
 	s.fillFakeDataRecords(s.hours)
 	s.fillFakeDataRecords(s.minutes)
+	s.markModified(periods...)
+	s.writeMutex.Unlock()
 
+	s.saveFakeWalk(ctx)
 	logger.Info("Filled records with fake data")
 
-	tick := time.Tick(time.Minute)
-	ctx := context.Background()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
 	for {
 		select {
-		case <-tick:
-			dp := fakeDataPoint()
+		case <-ctx.Done():
+			// The last step may not have been saved before the context was cancelled
+			s.saveFakeWalk(context.Background())
+			logger.Info("Stopped generating fake data")
+			return
+		case <-ticker.C:
+			dp := s.fakeDataPoint()
 			udp := []godometer.UpdateDataPoint{
 				{
-					Timestamp:         time.Now().In(utc).Format(minuteLayout),
+					Timestamp:         s.clock.Now().In(utc).Format(minuteLayout),
 					Meters:            dp.Meters,
 					MetersPerSecond:   dp.MetersPerSecond,
 					KilometersPerHour: dp.KilometersPerHour,
@@ -793,7 +1557,8 @@ func (s *Server) generateFakeData() {
 			}
 
 			logger.Info("FAKED EVENT", zap.Float32("meters", udp[0].Meters), zap.Float32("MPS", udp[0].MetersPerSecond), zap.Float32("KPH", udp[0].KilometersPerHour))
-			s.writeStats(ctx, udp)
+			_ = s.writeStats(ctx, udp)
+			s.saveFakeWalk(ctx)
 		}
 	}
 }
@@ -0,0 +1,650 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"math"
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/lietu/godometer"
+)
+
+func TestWriteStatsReportsFailedDocs(t *testing.T) {
+	srv, fake, _ := newTestServer(t, testNow)
+	minute := testMinute(testNow)
+	fake.fail(minute)
+
+	err := srv.writeStats(context.Background(), []godometer.UpdateDataPoint{
+		{Timestamp: minute, Meters: 60, MetersPerSecond: 1, KilometersPerHour: 3.6},
+	})
+
+	var commitErr *CommitError
+	if !errors.As(err, &commitErr) {
+		t.Fatalf("Expected a CommitError, got %v", err)
+	}
+
+	failed := map[string]bool{}
+	for _, id := range commitErr.DocIds {
+		failed[id] = true
+	}
+	for _, id := range []string{
+		collectionName("minutes") + "/" + minute,
+		collectionName("hours") + "/2024-03-06 12",
+		collectionName("weeks") + "/2024 week 10",
+	} {
+		if !failed[id] {
+			t.Errorf("%s missing from the failed ids %v", id, commitErr.DocIds)
+		}
+	}
+
+	if fake.has("minutes", minute) {
+		t.Errorf("Minute %s was written despite the failure", minute)
+	}
+}
+
+func TestStorageErrors(t *testing.T) {
+	srv, fake, _ := newTestServer(t, testNow)
+
+	// Nothing stored yet isn't an error
+	if err := srv.loadData(context.Background()); err != nil {
+		t.Fatalf("Loading an empty DB failed: %s", err)
+	}
+
+	fake.setUnavailable(true)
+
+	if err := srv.loadData(context.Background()); !errors.Is(err, ErrStorageUnavailable) {
+		t.Errorf("loadData returned %v, expected ErrStorageUnavailable", err)
+	}
+
+	err := srv.writeStats(context.Background(), []godometer.UpdateDataPoint{
+		{Timestamp: testMinute(testNow), Meters: 60, MetersPerSecond: 1, KilometersPerHour: 3.6},
+	})
+	if !errors.Is(err, ErrStorageUnavailable) {
+		t.Errorf("writeStats returned %v, expected ErrStorageUnavailable", err)
+	}
+
+	rec := getJSON(t, srv, "/api/v1/record/minutes/2020-01-01%2000:00", nil)
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("Reading a record with the DB down returned %d, expected 503", rec.Code)
+	}
+
+	fake.recover()
+
+	rec = getJSON(t, srv, "/api/v1/record/minutes/2020-01-01%2000:00", nil)
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("Reading a missing record returned %d, expected 404", rec.Code)
+	}
+}
+
+func closeTo(a float32, b float32) bool {
+	return math.Abs(float64(a-b)) < 0.001
+}
+
+func TestSignedMeters(t *testing.T) {
+	srv, _, _ := newTestServer(t, testNow)
+	srv.SetSignedMeters(true)
+
+	err := srv.writeStats(context.Background(), []godometer.UpdateDataPoint{
+		{Timestamp: "2024-03-06 12:10", Meters: 100, MetersPerSecond: 2, KilometersPerHour: 7.2},
+		{Timestamp: "2024-03-06 12:11", Meters: -40, MetersPerSecond: -1, KilometersPerHour: -3.6},
+		{Timestamp: "2024-03-06 12:12", Meters: 60, MetersPerSecond: 2, KilometersPerHour: 7.2},
+	})
+	if err != nil {
+		t.Fatalf("writeStats failed: %s", err)
+	}
+
+	reverse := srv.minutes["2024-03-06 12:11"]
+	if reverse.Meters != -40 || reverse.MetersPerSecond != 1 || reverse.Counter != 1 {
+		t.Errorf("Reversing minute is %s, expected -40m @ 1m/s counted as a sample", recordStr(reverse))
+	}
+
+	// 200m of movement in 120s, of which 40m backwards
+	hour := srv.hours["2024-03-06 12"]
+	if hour.Meters != 120 || hour.Counter != 3 {
+		t.Errorf("Hour is %s, expected 120m from 3 records", recordStr(hour))
+	}
+	if !closeTo(hour.MetersPerSecond, 200.0/120) || !closeTo(hour.KilometersPerHour, 6) {
+		t.Errorf("Hour averages %v m/s and %v km/h, expected 1.667 and 6", hour.MetersPerSecond, hour.KilometersPerHour)
+	}
+}
+
+func TestUnsignedMetersIgnoreNegative(t *testing.T) {
+	srv, _, _ := newTestServer(t, testNow)
+
+	err := srv.writeStats(context.Background(), []godometer.UpdateDataPoint{
+		{Timestamp: "2024-03-06 12:10", Meters: 100, MetersPerSecond: 2, KilometersPerHour: 7.2},
+		{Timestamp: "2024-03-06 12:11", Meters: -40, MetersPerSecond: -1, KilometersPerHour: -3.6},
+	})
+	if err != nil {
+		t.Fatalf("writeStats failed: %s", err)
+	}
+
+	hour := srv.hours["2024-03-06 12"]
+	if hour.Counter != 1 || hour.MetersPerSecond != 2 {
+		t.Errorf("Hour is %s, the negative update shouldn't count as a sample", recordStr(hour))
+	}
+}
+
+func sample(meters float32, mps float32) DBDataPoint {
+	row := DBDataPoint{Counter: 1, Meters: meters, MetersPerSecond: mps, KilometersPerHour: mps * 3.6}
+	row.MovingSeconds = movingSeconds(row)
+	return row.withIntegers()
+}
+
+func TestCalculateUpdateWeighsByMovingTime(t *testing.T) {
+	// A minute of steady walking, and a one second burst sent as its own update
+	updates := []DBDataPoint{sample(120, 2), sample(10, 10)}
+
+	var row DBDataPoint
+	ok := false
+	var unweighted float32
+	for _, update := range updates {
+		row, _ = calculateUpdate(row, ok, update, false, sampleThreshold{})
+		ok = true
+		unweighted += update.MetersPerSecond / float32(len(updates))
+	}
+
+	// Averaging per update would claim 6 m/s, but 130m took 61s
+	if unweighted != 6 {
+		t.Fatalf("Unweighted average is %v, expected 6", unweighted)
+	}
+	if !closeTo(row.MetersPerSecond, 130.0/61) || !closeTo(row.KilometersPerHour, 130.0/61*3.6) {
+		t.Errorf("Weighted average is %v m/s and %v km/h, expected %v m/s", row.MetersPerSecond, row.KilometersPerHour, 130.0/61)
+	}
+	if row.Meters != 130 || row.Counter != 2 || row.MovingSeconds != 61 {
+		t.Errorf("Row is %s over %vs, expected 130m from 2 records over 61s", recordStr(row), row.MovingSeconds)
+	}
+}
+
+func TestCalculateUpdateSkipsEmptyUpdates(t *testing.T) {
+	old := sample(120, 2)
+	row, save := calculateUpdate(old, true, DBDataPoint{Counter: 1}, false, sampleThreshold{})
+	if save || row != old {
+		t.Errorf("An empty update changed the row to %s, save %v", recordStr(row), save)
+	}
+}
+
+func TestFakeDataStopsOnCancel(t *testing.T) {
+	srv, fake, _ := newTestServer(t, testNow)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan bool)
+	go func() {
+		srv.generateFakeData(ctx, 5*time.Millisecond)
+		close(done)
+	}()
+
+	// Let it generate something first
+	deadline := time.Now().Add(5 * time.Second)
+	for fake.commitCount() < 2 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatalf("Fake data generation didn't stop after cancelling")
+	}
+
+	if fake.commitCount() < 2 {
+		t.Errorf("No fake data was generated")
+	}
+}
+
+func TestFakeWalkResumesAfterRestart(t *testing.T) {
+	srv, fake, _ := newTestServer(t, testNow)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan bool)
+	go func() {
+		srv.generateFakeData(ctx, 5*time.Millisecond)
+		close(done)
+	}()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for fake.commitCount() < 4 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	cancel()
+	<-done
+
+	walked := srv.fakeMeters
+	if walked == 0 {
+		t.Fatalf("The walk didn't go anywhere")
+	}
+	if stored := fake.field("events", "fakeWalk", "meters"); stored == nil || stored.GetDoubleValue() != walked {
+		t.Fatalf("Stored walk is %v, expected %v", stored, walked)
+	}
+
+	restarted := NewServerWithClient(false, fake.newClient(t), "")
+	restarted.loadFakeWalk(context.Background())
+	if restarted.fakeMeters != walked {
+		t.Errorf("Restarted walk is at %v, expected to resume from %v", restarted.fakeMeters, walked)
+	}
+
+	// One step on from where it was, not from the start
+	dp := restarted.fakeDataPoint()
+	if math.Abs(float64(dp.Meters)-walked) > 50 {
+		t.Errorf("First step after restarting is %v, more than a step from %v", dp.Meters, walked)
+	}
+
+	// The walk is the server's own, another one starts from zero
+	other, _, _ := newTestServer(t, testNow)
+	if other.fakeMeters != 0 {
+		t.Errorf("Another server's walk is at %v", other.fakeMeters)
+	}
+}
+
+func TestReplayAfterRestart(t *testing.T) {
+	srv, _, clock := newTestServer(t, testNow)
+
+	dataPoints := []godometer.UpdateDataPoint{
+		{Timestamp: "2024-03-06 12:20", Meters: 100, MetersPerSecond: 2, KilometersPerHour: 7.2},
+		{Timestamp: "2024-03-06 12:21", Meters: 50, MetersPerSecond: 1, KilometersPerHour: 3.6},
+	}
+	if err := srv.writeStats(context.Background(), dataPoints); err != nil {
+		t.Fatalf("writeStats failed: %s", err)
+	}
+
+	// A new process on the same DB, which the sensor sends the same events to again
+	restarted := NewServerWithClient(false, srv.db, "")
+	restarted.SetClock(clock)
+	if err := restarted.loadData(context.Background()); err != nil {
+		t.Fatalf("loadData failed: %s", err)
+	}
+	// Older than the few recent events kept
+	restarted.lastEvents = nil
+	if err := restarted.writeStats(context.Background(), dataPoints); err != nil {
+		t.Fatalf("writeStats failed: %s", err)
+	}
+
+	if err := restarted.loadData(context.Background()); err != nil {
+		t.Fatalf("loadData failed: %s", err)
+	}
+	for period, id := range map[string]string{"hours": "2024-03-06 12", "days": "2024-03-06", "years": "2024"} {
+		records, _ := restarted.periodRecords(period)
+		if row := records[id]; row.Meters != 150 || row.Counter != 2 {
+			t.Errorf("%s %s is %s after the replay, expected 150m from 2 records", period, id, recordStr(row))
+		}
+	}
+}
+
+func TestRetryAfterFailedCommit(t *testing.T) {
+	srv, fake, _ := newTestServer(t, testNow)
+	minute := "2024-03-06 12:20"
+	dataPoints := []godometer.UpdateDataPoint{{Timestamp: minute, Meters: 100, MetersPerSecond: 2, KilometersPerHour: 7.2}}
+
+	fake.fail(minute)
+	if err := srv.writeStats(context.Background(), dataPoints); err == nil {
+		t.Fatalf("writeStats succeeded despite the failure")
+	}
+	if row := srv.hours["2024-03-06 12"]; row.Meters != 0 {
+		t.Errorf("Hour is %s after a failed commit, expected it untouched", recordStr(row))
+	}
+
+	fake.recover()
+	if err := srv.writeStats(context.Background(), dataPoints); err != nil {
+		t.Fatalf("Retry failed: %s", err)
+	}
+	if !fake.has("minutes", minute) {
+		t.Errorf("Retried minute wasn't saved")
+	}
+	if row := srv.hours["2024-03-06 12"]; row.Meters != 100 || row.Counter != 1 {
+		t.Errorf("Hour is %s after the retry, expected 100m from 1 record", recordStr(row))
+	}
+}
+
+func TestWriteStatsCancelled(t *testing.T) {
+	srv, fake, _ := newTestServer(t, testNow)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	err := srv.writeStats(ctx, []godometer.UpdateDataPoint{
+		{Timestamp: testMinute(testNow), Meters: 100, MetersPerSecond: 2, KilometersPerHour: 7.2},
+	})
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected context.Canceled, got %v", err)
+	}
+	if fake.commitCount() != 0 {
+		t.Errorf("Cancelled writeStats committed")
+	}
+	if row := srv.hours["2024-03-06 12"]; row.Meters != 0 || len(srv.lastEvents) != 0 {
+		t.Errorf("Cancelled writeStats changed the hour to %s", recordStr(row))
+	}
+}
+
+func TestReadRecordsInChunks(t *testing.T) {
+	srv, fake, _ := newTestServer(t, testNow)
+	srv.SetReadChunkSize(10)
+
+	var ids []string
+	for day := 1; day <= 25; day++ {
+		id := time.Date(2023, 1, day, 0, 0, 0, 0, time.UTC).Format(dayLayout)
+		ids = append(ids, id)
+		seedRecord(t, srv, "days", id, DBDataPoint{Counter: 1, Meters: float32(day)})
+	}
+	// Not stored at all
+	ids = append(ids, "2023-01-26")
+
+	records, err := srv.readRecords(context.Background(), collectionName("days"), ids)
+	if err != nil {
+		t.Fatalf("readRecords failed: %s", err)
+	}
+	if len(records) != len(ids) {
+		t.Fatalf("Got %d records, expected %d", len(records), len(ids))
+	}
+	if row := records["2023-01-25"]; row.Meters != 25 || !row.Exists {
+		t.Errorf("Last stored day is %s", recordStr(row))
+	}
+	if row := records["2023-01-26"]; row.Meters != 0 || row.Exists {
+		t.Errorf("Missing day is %s, expected it zeroed", recordStr(row))
+	}
+
+	// The second chunk failing leaves the others
+	fake.failRead("2023-01-15")
+	records, err = srv.readRecords(context.Background(), collectionName("days"), ids)
+	if !errors.Is(err, ErrStorageUnavailable) {
+		t.Errorf("Expected ErrStorageUnavailable, got %v", err)
+	}
+	if len(records) != 16 {
+		t.Errorf("Got %d records, expected the 16 from the chunks that didn't fail", len(records))
+	}
+	if _, ok := records["2023-01-15"]; ok {
+		t.Errorf("Got a record from the failed chunk")
+	}
+}
+
+func TestReadRecordsPresence(t *testing.T) {
+	srv, _, _ := newTestServer(t, testNow)
+	seedRecord(t, srv, "hours", "2024-03-06 10", DBDataPoint{})
+	seedRecord(t, srv, "hours", "2024-03-06 11", DBDataPoint{Counter: 2, Meters: 120, MetersPerSecond: 1})
+
+	ids := []string{"2024-03-06 10", "2024-03-06 11", "2024-03-06 12"}
+	records, err := srv.readRecords(context.Background(), collectionName("hours"), ids)
+	if err != nil {
+		t.Fatalf("readRecords failed: %s", err)
+	}
+
+	expected := map[string]DBDataPoint{
+		"2024-03-06 10": {Exists: true},
+		"2024-03-06 11": {Counter: 2, Meters: 120, MetersPerSecond: 1, Millimeters: 120000, MillimetersPerSecond: 1000, Exists: true},
+		"2024-03-06 12": {},
+	}
+	for id, want := range expected {
+		got := records[id]
+		got.SchemaVersion = 0
+		if got != want {
+			t.Errorf("Hour %s is %+v, expected %+v", id, got, want)
+		}
+	}
+
+	// Written ones exist from then on, without reading them back
+	rec := postStats(t, srv, godometer.UpdateDataPoint{Timestamp: testMinute(testNow), Meters: 60, MetersPerSecond: 1, KilometersPerHour: 3.6})
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Update failed with %d", rec.Code)
+	}
+	if !srv.hours["2024-03-06 12"].Exists || srv.hours["2024-03-06 09"].Exists {
+		t.Errorf("Only the written hour should exist")
+	}
+}
+
+func TestMalformedRecords(t *testing.T) {
+	for _, strict := range []bool{false, true} {
+		srv, _, _ := newTestServer(t, testNow)
+		seedRecord(t, srv, "hours", "2024-03-06 10", DBDataPoint{Counter: 2, Meters: 120, MetersPerSecond: 1}.withIntegers())
+		seedRecord(t, srv, "hours", "2024-03-06 12", DBDataPoint{Counter: 1, Meters: 60, MetersPerSecond: 1}.withIntegers())
+		_, err := srv.db.Collection(collectionName("hours")).Doc("2024-03-06 11").Set(context.Background(), map[string]interface{}{"schemaVersion": currentSchemaVersion, "Meters": "a lot"})
+		if err != nil {
+			t.Fatalf("Failed to seed the malformed hour: %s", err)
+		}
+
+		// Configured after creating the server, the way the command does it
+		srv.SetStrictDecoding(strict)
+		srv.start(false)
+		_ = srv.Shutdown(context.Background())
+
+		if got := srv.hours["2024-03-06 10"].Meters; got != 120 {
+			t.Errorf("Strict %v: hour 2024-03-06 10 has %v meters, expected 120", strict, got)
+		}
+		if got := srv.hours["2024-03-06 12"].Meters; got != 60 {
+			t.Errorf("Strict %v: hour 2024-03-06 12 has %v meters, expected 60", strict, got)
+		}
+		if got := srv.hours["2024-03-06 11"]; got != (DBDataPoint{Exists: true}) {
+			t.Errorf("Strict %v: malformed hour is %+v, expected zeroes", strict, got)
+		}
+		if len(srv.hours) != 24 {
+			t.Errorf("Strict %v: %d hours in memory, expected 24", strict, len(srv.hours))
+		}
+
+		err = srv.loadData(context.Background())
+		var decodeErr *DecodeError
+		if !strict {
+			if err != nil {
+				t.Errorf("Loading malformed records failed when not strict: %s", err)
+			}
+			continue
+		}
+		if !errors.As(err, &decodeErr) || !errors.Is(err, ErrMalformedRecord) {
+			t.Fatalf("Expected a DecodeError, got %v", err)
+		}
+		if _, ok := decodeErr.Failures["2024-03-06 11"]; !ok || len(decodeErr.Failures) != 1 || decodeErr.Collection != collectionName("hours") {
+			t.Errorf("DecodeError reports %s, expected only hour 2024-03-06 11", decodeErr)
+		}
+		if got := srv.hours["2024-03-06 10"].Meters; got != 120 {
+			t.Errorf("Good hour has %v meters after a failed strict load, expected 120", got)
+		}
+	}
+}
+
+var sameMinuteEvents = []godometer.UpdateDataPoint{
+	{Timestamp: "2024-03-06 12:20", Meters: 60, MetersPerSecond: 2, KilometersPerHour: 7.2},
+	{Timestamp: "2024-03-06 12:20", Meters: 30, MetersPerSecond: 1, KilometersPerHour: 3.6},
+	{Timestamp: "2024-03-06 12:20", Meters: 90, MetersPerSecond: 3, KilometersPerHour: 10.8},
+}
+
+func TestSameMinuteEventsAddUp(t *testing.T) {
+	srv, _, clock := newTestServer(t, testNow)
+
+	if err := srv.writeStats(context.Background(), sameMinuteEvents); err != nil {
+		t.Fatalf("writeStats failed: %s", err)
+	}
+
+	// 180m in 90s
+	minute := srv.minutes["2024-03-06 12:20"]
+	if minute.Meters != 180 || minute.Counter != 3 || minute.MovingSeconds != 90 || !closeTo(minute.MetersPerSecond, 2) {
+		t.Errorf("Minute is %s, expected 180m @ 2m/s from all 3 events", recordStr(minute))
+	}
+	if hour := srv.hours["2024-03-06 12"]; hour.Meters != 180 || hour.Counter != 3 {
+		t.Errorf("Hour is %s, expected 180m from 3 records", recordStr(hour))
+	}
+
+	// Resent, also to a new process on the same DB
+	if err := srv.writeStats(context.Background(), sameMinuteEvents); err != nil {
+		t.Fatalf("writeStats failed: %s", err)
+	}
+	restarted := NewServerWithClient(false, srv.db, "")
+	restarted.SetClock(clock)
+	if err := restarted.loadData(context.Background()); err != nil {
+		t.Fatalf("loadData failed: %s", err)
+	}
+	restarted.lastEvents = nil
+	if err := restarted.writeStats(context.Background(), sameMinuteEvents[1:]); err != nil {
+		t.Fatalf("writeStats failed: %s", err)
+	}
+
+	for _, s := range []*Server{srv, restarted} {
+		if minute := s.minutes["2024-03-06 12:20"]; minute.Meters != 180 || minute.Counter != 3 {
+			t.Errorf("Minute is %s after resending, expected it unchanged", recordStr(minute))
+		}
+	}
+}
+
+func TestEventsAddToMinutesWithoutIds(t *testing.T) {
+	srv, _, _ := newTestServer(t, testNow)
+	// E.g. imported from CSV, in memory and in the DB
+	srv.minutes["2024-03-06 12:20"] = DBDataPoint{Counter: 1, Meters: 60, MetersPerSecond: 2, MovingSeconds: 30}.withIntegers()
+	seedRecord(t, srv, "minutes", "2024-01-15 08:00", DBDataPoint{Counter: 1, Meters: 60, MetersPerSecond: 2, MovingSeconds: 30}.withIntegers())
+
+	events := []godometer.UpdateDataPoint{sameMinuteEvents[1], {Timestamp: "2024-01-15 08:00", Meters: 30, MetersPerSecond: 1, KilometersPerHour: 3.6}}
+	committed, err := srv.committedEvents(context.Background(), events)
+	if err != nil {
+		t.Fatalf("committedEvents failed: %s", err)
+	}
+	if len(committed) != 0 {
+		t.Errorf("Events %v were taken as already counted in minutes without event ids", committed)
+	}
+
+	if err := srv.writeStats(context.Background(), events[:1]); err != nil {
+		t.Fatalf("writeStats failed: %s", err)
+	}
+	if minute := srv.minutes["2024-03-06 12:20"]; minute.Meters != 90 || minute.Counter != 2 {
+		t.Errorf("Minute is %s, expected the new event added to it", recordStr(minute))
+	}
+}
+
+func TestCoalescedEventsAddUpTheSame(t *testing.T) {
+	dataPoints := append([]godometer.UpdateDataPoint{
+		{Timestamp: "2024-03-06 12:19", Meters: 100, MetersPerSecond: 2.5, KilometersPerHour: 9},
+	}, sameMinuteEvents...)
+
+	separate, _, _ := newTestServer(t, testNow)
+	coalesced, _, _ := newTestServer(t, testNow)
+	coalesced.EnableCoalescing()
+
+	for _, srv := range []*Server{separate, coalesced} {
+		if err := srv.writeStats(context.Background(), dataPoints); err != nil {
+			t.Fatalf("writeStats failed: %s", err)
+		}
+	}
+
+	for period, id := range map[string]string{"minutes": "2024-03-06 12:20", "hours": "2024-03-06 12", "years": "2024"} {
+		want, _ := separate.periodRecords(period)
+		got, _ := coalesced.periodRecords(period)
+		if got[id].Meters != want[id].Meters || got[id].Counter != want[id].Counter || !closeTo(got[id].MetersPerSecond, want[id].MetersPerSecond) {
+			t.Errorf("Coalesced %s %s is %s, expected %s", period, id, recordStr(got[id]), recordStr(want[id]))
+		}
+	}
+
+	// Still recognized as resends one by one
+	if err := coalesced.writeStats(context.Background(), sameMinuteEvents[:1]); err != nil {
+		t.Fatalf("writeStats failed: %s", err)
+	}
+	if hour := coalesced.hours["2024-03-06 12"]; hour.Meters != 280 || hour.Counter != 4 {
+		t.Errorf("Hour is %s after resending, expected 280m from 4 records", recordStr(hour))
+	}
+}
+
+func TestShuffledBatchAddsUpLikeSorted(t *testing.T) {
+	sorted := []godometer.UpdateDataPoint{
+		{Timestamp: "2024-03-05 23:58", Meters: 45, MetersPerSecond: 1.5, KilometersPerHour: 5.4},
+		{Timestamp: "2024-03-06 11:59", Meters: 30, MetersPerSecond: 1, KilometersPerHour: 3.6},
+		{Timestamp: "2024-03-06 12:20", Meters: 60, MetersPerSecond: 2, KilometersPerHour: 7.2},
+		{Timestamp: "2024-03-06 12:20", Meters: 90, MetersPerSecond: 3, KilometersPerHour: 10.8},
+		{Timestamp: "2024-03-06 12:21", Meters: 75, MetersPerSecond: 2.5, KilometersPerHour: 9},
+		{Timestamp: "2024-03-06 12:30", Meters: 12.5, MetersPerSecond: 0.5, KilometersPerHour: 1.8},
+	}
+	shuffled := []godometer.UpdateDataPoint{sorted[4], sorted[3], sorted[0], {Timestamp: "noon"}, sorted[5], sorted[2], sorted[1]}
+
+	var servers []*Server
+	var fakes []*fakeFirestore
+	for _, batch := range [][]godometer.UpdateDataPoint{sorted, shuffled} {
+		srv, fake, _ := newTestServer(t, testNow)
+		if err := srv.writeStats(context.Background(), batch); err != nil {
+			t.Fatalf("writeStats failed: %s", err)
+		}
+		servers = append(servers, srv)
+		fakes = append(fakes, fake)
+	}
+
+	same := func(a DBDataPoint, b DBDataPoint) bool {
+		return a.Counter == b.Counter && a.Millimeters == b.Millimeters && closeTo(a.MetersPerSecond, b.MetersPerSecond) && closeTo(a.MovingSeconds, b.MovingSeconds)
+	}
+	records := []struct {
+		period string
+		id     string
+	}{
+		{"minutes", "2024-03-06 12:20"},
+		{"minutes", "2024-03-06 12:21"},
+		{"hours", "2024-03-05 23"},
+		{"hours", "2024-03-06 11"},
+		{"hours", "2024-03-06 12"},
+		{"days", "2024-03-05"},
+		{"days", "2024-03-06"},
+	}
+	for _, r := range records {
+		got, err := servers[1].readRecords(context.Background(), collectionName(r.period), []string{r.id})
+		if err != nil {
+			t.Fatalf("readRecords failed: %s", err)
+		}
+		want, err := servers[0].readRecords(context.Background(), collectionName(r.period), []string{r.id})
+		if err != nil {
+			t.Fatalf("readRecords failed: %s", err)
+		}
+		if !want[r.id].Exists || !same(got[r.id], want[r.id]) {
+			t.Errorf("Shuffled %s %s is %s, expected %s like sorted", r.period, r.id, recordStr(got[r.id]), recordStr(want[r.id]))
+		}
+	}
+
+	var totals []TotalResponse
+	for _, srv := range servers {
+		total := TotalResponse{}
+		if rec := getJSON(t, srv, "/api/v1/total?from=2024-03-05&to=2024-03-06", &total); rec.Code != http.StatusOK {
+			t.Fatalf("Total failed with %d: %s", rec.Code, rec.Body.String())
+		}
+		totals = append(totals, total)
+	}
+	if totals[0].Meters != 312.5 || totals[1].Meters != totals[0].Meters || !closeTo(totals[1].MetersPerSecond, totals[0].MetersPerSecond) {
+		t.Errorf("Shuffled total is %+v, expected %+v like sorted", totals[1], totals[0])
+	}
+
+	if got, want := eventTimestamps(servers[1].lastEvents), eventTimestamps(servers[0].lastEvents); !reflect.DeepEqual(got, want) {
+		t.Errorf("Shuffled events are %v, expected %v like sorted", got, want)
+	}
+	if fakes[1].commitCount() != fakes[0].commitCount() {
+		t.Errorf("Shuffled batch took %d commits, sorted %d", fakes[1].commitCount(), fakes[0].commitCount())
+	}
+}
+
+func TestSampleThreshold(t *testing.T) {
+	threshold := sampleThreshold{minMeters: 5, minSpeed: 0.5}
+	old := sample(100, 2)
+
+	cases := []struct {
+		name   string
+		row    DBDataPoint
+		sample bool
+	}{
+		{"above", DBDataPoint{Meters: 5.1, MetersPerSecond: 0.6, KilometersPerHour: 2.16}, true},
+		{"meters below", DBDataPoint{Meters: 4.9, MetersPerSecond: 0.6, KilometersPerHour: 2.16}, false},
+		{"speed below", DBDataPoint{Meters: 5.1, MetersPerSecond: 0.4, KilometersPerHour: 1.44}, false},
+		{"all zero", DBDataPoint{}, false},
+	}
+	for _, c := range cases {
+		row, save := calculateUpdate(old, true, c.row.withIntegers(), false, threshold)
+		if counted := row.Counter == 2; counted != c.sample {
+			t.Errorf("%s: counted as a sample %v, expected %v", c.name, counted, c.sample)
+		}
+		if c.row.Meters != 0 && (!save || !closeTo(row.Meters, 100+c.row.Meters)) {
+			t.Errorf("%s: distance wasn't added, got %s", c.name, recordStr(row))
+		}
+		if !c.sample && row.MetersPerSecond != 2 {
+			t.Errorf("%s: speed average changed to %v", c.name, row.MetersPerSecond)
+		}
+	}
+
+	// By default only strictly positive updates count, negative thresholds let standing still count too
+	if row, _ := calculateUpdate(old, true, DBDataPoint{Meters: 1}.withIntegers(), false, sampleThreshold{}); row.Counter != 1 {
+		t.Errorf("Update without speed counted by default")
+	}
+	if row, _ := calculateUpdate(old, true, DBDataPoint{Meters: 1}.withIntegers(), false, sampleThreshold{minMeters: 0, minSpeed: -1}); row.Counter != 2 {
+		t.Errorf("Update without speed didn't count with a negative speed threshold")
+	}
+	if row, save := calculateUpdate(old, true, DBDataPoint{}, false, sampleThreshold{minMeters: -1, minSpeed: -1}); row.Counter != 2 || !save {
+		t.Errorf("All zero update didn't count with negative thresholds")
+	}
+}
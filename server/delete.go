@@ -0,0 +1,218 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+// Checks the ids are keys of the period, so a typo can't wipe a different range than intended
+func validRecordIds(period string, ids ...string) error {
+	if !stringInList(periods, period) {
+		return fmt.Errorf("invalid period %q", period)
+	}
+
+	for _, id := range ids {
+		if p, ok := timestampPeriod(id); !ok || p != period {
+			return fmt.Errorf("invalid %s key %q", period, id)
+		}
+	}
+
+	return nil
+}
+
+func (s *Server) deleteRecord(c *gin.Context) {
+	period := c.Param("period")
+	id := c.Param("id")
+	if err := validRecordIds(period, id); err != nil {
+		badRequest(c, err)
+		return
+	}
+
+	s.respondDeleted(c, period, []string{id})
+}
+
+// Range deletes list every key in the range, so e.g. a decade of minutes can't be deleted by accident
+const maxDeleteRecords = 10000
+
+// Week keys aren't time layouts, see weekFormat
+var recordKeyLayouts = map[string]string{
+	"minutes": minuteLayout,
+	"hours":   hourLayout,
+	"days":    dayLayout,
+	"months":  monthLayout,
+	"years":   yearLayout,
+}
+
+// The start of the bucket the key of the period stands for. Week keys are ISO weeks, which start on Monday and the
+// first of which has January 4th in it.
+func parseRecordKey(period string, id string) (time.Time, error) {
+	if period == "weeks" {
+		var year, week int
+		_, err := fmt.Sscanf(id, "%d week %d", &year, &week)
+		if err != nil {
+			return time.Time{}, err
+		}
+
+		jan4 := time.Date(year, 1, 4, 0, 0, 0, 0, utc)
+		monday := jan4.AddDate(0, 0, -((int(jan4.Weekday()) + 6) % 7))
+		return monday.AddDate(0, 0, (week-1)*7), nil
+	}
+
+	return time.ParseInLocation(recordKeyLayouts[period], id, utc)
+}
+
+func nextRecordKey(period string, ts time.Time) time.Time {
+	switch period {
+	case "minutes":
+		return ts.Add(time.Minute)
+	case "hours":
+		return ts.Add(time.Hour)
+	case "days":
+		return ts.AddDate(0, 0, 1)
+	case "weeks":
+		return ts.AddDate(0, 0, 7)
+	case "months":
+		return ts.AddDate(0, 1, 0)
+	}
+	return ts.AddDate(1, 0, 0)
+}
+
+func formatRecordKey(period string, ts time.Time) string {
+	if period == "weeks" {
+		return weekFormat(ts)
+	}
+	return ts.Format(recordKeyLayouts[period])
+}
+
+// Every key of the period from "from" to "to", both inclusive. The keys have to be valid for the period.
+func recordIds(period string, from string, to string) ([]string, error) {
+	start, err := parseRecordKey(period, from)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s key %q: %w", period, from, err)
+	}
+	end, err := parseRecordKey(period, to)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s key %q: %w", period, to, err)
+	}
+
+	if start.After(end) {
+		return nil, fmt.Errorf("from %q must not be after to %q", from, to)
+	}
+
+	var ids []string
+	for current := start; !current.After(end); current = nextRecordKey(period, current) {
+		if len(ids) == maxDeleteRecords {
+			return nil, fmt.Errorf("range from %q to %q has more than %d records", from, to, maxDeleteRecords)
+		}
+		ids = append(ids, formatRecordKey(period, current))
+	}
+
+	return ids, nil
+}
+
+// Deletes all the records of the period from ?from= to ?to=, both inclusive
+func (s *Server) deleteRecordRange(c *gin.Context) {
+	period := c.Param("period")
+	from := c.Query("from")
+	to := c.Query("to")
+	if err := validRecordIds(period, from, to); err != nil {
+		badRequest(c, err)
+		return
+	}
+
+	ids, err := recordIds(period, from, to)
+	if err != nil {
+		badRequest(c, err)
+		return
+	}
+
+	s.respondDeleted(c, period, ids)
+}
+
+func (s *Server) respondDeleted(c *gin.Context, period string, ids []string) {
+	deleted, err := s.deleteRecords(c.Request.Context(), period, ids)
+	if err != nil {
+		logger.Warn("Failed to delete records", zap.String("period", period), zap.Int("deleted", deleted), zap.Error(err))
+		_ = c.AbortWithError(errorStatus(err), err)
+		return
+	}
+
+	c.JSON(200, ResetResponse{Deleted: deleted})
+}
+
+// Deletes the records of the period with the given keys from the DB, and zeroes out the ones in memory. Returns how
+// many records existed and were deleted. The ids of the events in a minute go with it, so if a client sends any of
+// them again they're counted again, in the minute and in every other period.
+func (s *Server) deleteRecords(ctx context.Context, period string, ids []string) (int, error) {
+	s.writeMutex.Lock()
+	defer s.writeMutex.Unlock()
+
+	collection := s.db.Collection(collectionName(period))
+	available, _ := s.periodRecords(period)
+	deletedIds := map[string]bool{}
+	defer func() {
+		// Whatever was deleted before failing is gone from memory too
+		if period == "minutes" && len(deletedIds) > 0 {
+			events := []ResponseDataPoint{}
+			for _, e := range s.lastEvents {
+				if !deletedIds[e.Timestamp] {
+					events = append(events, e)
+				}
+			}
+			s.lastEvents = events
+		}
+		s.markModified(period)
+	}()
+
+	for start := 0; start < len(ids); start += maxBatchWrites {
+		end := start + maxBatchWrites
+		if end > len(ids) {
+			end = len(ids)
+		}
+
+		var refs []*firestore.DocumentRef
+		for _, id := range ids[start:end] {
+			refs = append(refs, collection.Doc(id))
+		}
+
+		// Only the ones that exist are counted
+		docs, err := s.db.GetAll(ctx, refs)
+		if err != nil {
+			return len(deletedIds), storageError(err)
+		}
+
+		batch := s.batch()
+		var existing []string
+		for _, doc := range docs {
+			if doc.Exists() {
+				batch.Delete(doc.Ref)
+				existing = append(existing, doc.Ref.ID)
+			}
+		}
+		if len(existing) == 0 {
+			continue
+		}
+
+		_, err = batch.Commit(ctx)
+		if err != nil {
+			return len(deletedIds), storageError(err)
+		}
+
+		for _, id := range existing {
+			deletedIds[id] = true
+			if _, ok := available[id]; ok {
+				available[id] = DBDataPoint{}
+			} else {
+				logger.Warn("Deleted record outside the window kept in memory", zap.String("period", period), zap.String("id", id))
+			}
+		}
+	}
+
+	logger.Info("Deleted records", zap.String("period", period), zap.String("from", ids[0]), zap.String("to", ids[len(ids)-1]), zap.Int("deleted", len(deletedIds)))
+	return len(deletedIds), nil
+}
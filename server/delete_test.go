@@ -0,0 +1,160 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/lietu/godometer"
+)
+
+func deleteRequest(t *testing.T, srv *Server, path string) (int, int) {
+	t.Helper()
+	rec := request(srv, http.MethodDelete, path, nil, map[string]string{"Authorization": testAdminAuth})
+	response := ResetResponse{}
+	if rec.Code == http.StatusOK {
+		if err := json.Unmarshal(rec.Body.Bytes(), &response); err != nil {
+			t.Fatalf("Failed to parse response of %s: %s", path, err)
+		}
+	}
+	return rec.Code, response.Deleted
+}
+
+func TestDeleteRecord(t *testing.T) {
+	srv, fake, _ := newTestServer(t, testNow)
+	srv.EnableAdmin(testAdminAuth)
+
+	minute := testMinute(testNow)
+	other := testMinute(testNow.Add(-time.Minute))
+	for _, ts := range []string{other, minute} {
+		if rec := postStats(t, srv, godometer.UpdateDataPoint{Timestamp: ts, Meters: 100, MetersPerSecond: 2, KilometersPerHour: 7.2}); rec.Code != http.StatusOK {
+			t.Fatalf("Update failed with %d: %s", rec.Code, rec.Body.String())
+		}
+	}
+
+	status, deleted := deleteRequest(t, srv, "/api/v1/admin/records/minutes/"+url.PathEscape(minute))
+	if status != http.StatusOK || deleted != 1 {
+		t.Fatalf("Delete returned %d, deleted %d, expected 1", status, deleted)
+	}
+
+	if fake.has("minutes", minute) {
+		t.Errorf("Minute %s is still in the DB", minute)
+	}
+	if !fake.has("minutes", other) {
+		t.Errorf("Minute %s was deleted from the DB too", other)
+	}
+	if got := srv.minutes[minute]; got.Counter != 0 || got.Meters != 0 {
+		t.Errorf("Minute %s is still in memory: %+v", minute, got)
+	}
+	if got := srv.minutes[other].Meters; got != 100 {
+		t.Errorf("Minute %s has %v meters in memory, expected 100", other, got)
+	}
+	if events := eventTimestamps(srv.lastEvents); len(events) != 1 || events[0] != other {
+		t.Errorf("Last events are %v, expected only %s", events, other)
+	}
+
+	// Nothing left to delete
+	status, deleted = deleteRequest(t, srv, "/api/v1/admin/records/minutes/"+url.PathEscape(minute))
+	if status != http.StatusOK || deleted != 0 {
+		t.Errorf("Deleting again returned %d, deleted %d, expected 0", status, deleted)
+	}
+
+	// The event ids went with the minute, so a resend counts again
+	if rec := postStats(t, srv, godometer.UpdateDataPoint{Timestamp: minute, Meters: 100, MetersPerSecond: 2, KilometersPerHour: 7.2}); rec.Code != http.StatusOK {
+		t.Fatalf("Resend failed with %d: %s", rec.Code, rec.Body.String())
+	}
+	if got := srv.minutes[minute]; got.Counter != 1 || got.Meters != 100 {
+		t.Errorf("Resent minute %s is %+v, expected it counted again", minute, got)
+	}
+}
+
+func TestDeleteWeekRange(t *testing.T) {
+	srv, fake, _ := newTestServer(t, testNow)
+	srv.EnableAdmin(testAdminAuth)
+
+	weeks := []string{"2024 week 8", "2024 week 9", "2024 week 10"}
+	for _, week := range weeks {
+		row := DBDataPoint{Counter: 10, Meters: 1000, MetersPerSecond: 2, KilometersPerHour: 7.2}.withIntegers()
+		seedRecord(t, srv, "weeks", week, row)
+		srv.weeks[week] = row
+	}
+
+	// As strings "2024 week 10" sorts before "2024 week 9", the range has to go by time
+	query := url.Values{"from": {"2024 week 9"}, "to": {"2024 week 10"}}
+	status, deleted := deleteRequest(t, srv, "/api/v1/admin/records/weeks?"+query.Encode())
+	if status != http.StatusOK || deleted != 2 {
+		t.Fatalf("Delete returned %d, deleted %d, expected 2", status, deleted)
+	}
+
+	for _, week := range weeks[1:] {
+		if fake.has("weeks", week) {
+			t.Errorf("%s is still in the DB", week)
+		}
+		if got := srv.weeks[week]; got.Counter != 0 || got.Meters != 0 {
+			t.Errorf("%s is still in memory: %+v", week, got)
+		}
+	}
+	if !fake.has("weeks", "2024 week 8") {
+		t.Errorf("2024 week 8 was deleted from the DB too")
+	}
+	if got := srv.weeks["2024 week 8"].Meters; got != 1000 {
+		t.Errorf("2024 week 8 has %v meters in memory, expected 1000", got)
+	}
+}
+
+func TestDeleteRangeValidation(t *testing.T) {
+	srv, _, _ := newTestServer(t, testNow)
+	srv.EnableAdmin(testAdminAuth)
+
+	tests := []struct {
+		period string
+		from   string
+		to     string
+	}{
+		{"weeks", "2024 week 10", "2024 week 9"},
+		{"weeks", "2024-03-06 12", "2024 week 9"},
+		// More than maxDeleteRecords minutes
+		{"minutes", "2024-01-01 00:00", "2024-12-31 00:00"},
+	}
+	for _, test := range tests {
+		query := url.Values{"from": {test.from}, "to": {test.to}}
+		if status, _ := deleteRequest(t, srv, "/api/v1/admin/records/"+test.period+"?"+query.Encode()); status != http.StatusBadRequest {
+			t.Errorf("Deleting %s from %s to %s returned %d, expected 400", test.period, test.from, test.to, status)
+		}
+	}
+}
+
+func TestRecordIds(t *testing.T) {
+	tests := []struct {
+		period   string
+		from     string
+		to       string
+		expected []string
+	}{
+		{"weeks", "2020 week 52", "2021 week 1", []string{"2020 week 52", "2020 week 53", "2021 week 1"}},
+		{"months", "2023-11", "2024-02", []string{"2023-11", "2023-12", "2024-01", "2024-02"}},
+		{"days", "2024-02-28", "2024-03-01", []string{"2024-02-28", "2024-02-29", "2024-03-01"}},
+		{"hours", "2024-03-06 23", "2024-03-07 00", []string{"2024-03-06 23", "2024-03-07 00"}},
+		{"minutes", "2024-03-06 12:30", "2024-03-06 12:30", []string{"2024-03-06 12:30"}},
+	}
+
+	for _, test := range tests {
+		ids, err := recordIds(test.period, test.from, test.to)
+		if err != nil {
+			t.Errorf("Listing %s from %s to %s failed: %s", test.period, test.from, test.to, err)
+			continue
+		}
+		if len(ids) != len(test.expected) {
+			t.Errorf("Listing %s from %s to %s gave %v, expected %v", test.period, test.from, test.to, ids, test.expected)
+			continue
+		}
+		for i := range ids {
+			if ids[i] != test.expected[i] {
+				t.Errorf("Listing %s from %s to %s gave %v, expected %v", test.period, test.from, test.to, ids, test.expected)
+				break
+			}
+		}
+	}
+}
@@ -0,0 +1,128 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"reflect"
+	"testing"
+
+	"github.com/lietu/godometer"
+)
+
+func eventTimestamps(events []ResponseDataPoint) []string {
+	timestamps := []string{}
+	for _, e := range events {
+		timestamps = append(timestamps, e.Timestamp)
+	}
+	return timestamps
+}
+
+func TestEventHistoryPagination(t *testing.T) {
+	srv, _, _ := newTestServer(t, testNow)
+
+	// Three in memory, and two older ones only in the DB
+	for _, key := range []string{"2024-03-06 12:30", "2024-03-06 12:10", "2024-03-06 11:45"} {
+		srv.minutes[key] = DBDataPoint{Counter: 1, Meters: 50, MetersPerSecond: 1}
+	}
+	for _, key := range []string{"2024-03-06 10:00", "2024-03-06 09:00"} {
+		seedRecord(t, srv, "minutes", key, DBDataPoint{Counter: 1, Meters: 50, MetersPerSecond: 1})
+	}
+
+	pages := []struct {
+		path   string
+		events []string
+		next   string
+	}{
+		{"/api/v1/events?limit=2", []string{"2024-03-06 12:30", "2024-03-06 12:10"}, "2024-03-06 12:10"},
+		{"/api/v1/events?limit=2&before=2024-03-06%2012:10", []string{"2024-03-06 11:45", "2024-03-06 10:00"}, "2024-03-06 10:00"},
+		{"/api/v1/events?limit=2&before=2024-03-06%2010:00", []string{"2024-03-06 09:00"}, ""},
+	}
+
+	for _, page := range pages {
+		response := EventHistoryResponse{}
+		rec := getJSON(t, srv, page.path, &response)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("%s failed with %d: %s", page.path, rec.Code, rec.Body.String())
+		}
+
+		if got := eventTimestamps(response.Events); !reflect.DeepEqual(got, page.events) {
+			t.Errorf("%s returned events %v, expected %v", page.path, got, page.events)
+		}
+		if response.Next != page.next {
+			t.Errorf("%s returned next %q, expected %q", page.path, response.Next, page.next)
+		}
+	}
+}
+
+func TestEventHistoryReadsOnlyThePage(t *testing.T) {
+	srv, fake, _ := newTestServer(t, testNow)
+
+	// A long history in the DB, with a gap of empty minutes to page over
+	for hour := 0; hour < 10; hour++ {
+		for minute := 0; minute < 5; minute++ {
+			seedRecord(t, srv, "minutes", fmt.Sprintf("2024-03-05 %02d:%02d", hour, minute), DBDataPoint{Counter: 1, Meters: 50, MetersPerSecond: 1}.withIntegers())
+		}
+	}
+	for minute := 0; minute < 3; minute++ {
+		seedRecord(t, srv, "minutes", fmt.Sprintf("2024-03-05 10:%02d", minute), DBDataPoint{}.withIntegers())
+	}
+
+	response := EventHistoryResponse{}
+	rec := getJSON(t, srv, "/api/v1/events?limit=2", &response)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Request failed with %d: %s", rec.Code, rec.Body.String())
+	}
+
+	expected := []string{"2024-03-05 09:04", "2024-03-05 09:03"}
+	if got := eventTimestamps(response.Events); !reflect.DeepEqual(got, expected) {
+		t.Errorf("Got events %v, expected %v", got, expected)
+	}
+	// The empty minutes and the page, not the other 48
+	if got := fake.queriedCount(); got > 5 {
+		t.Errorf("Read %d minutes from the DB for a page of 2", got)
+	}
+}
+
+func TestLastEventsAreLatestByTime(t *testing.T) {
+	srv, fake, _ := newTestServer(t, testNow)
+
+	// Out of order, across two batches, with a resend
+	batches := [][]godometer.UpdateDataPoint{
+		{
+			{Timestamp: "2024-03-06 12:25", Meters: 25, MetersPerSecond: 1, KilometersPerHour: 3.6},
+			{Timestamp: "2024-03-06 12:21", Meters: 21, MetersPerSecond: 1, KilometersPerHour: 3.6},
+			{Timestamp: "2024-03-06 12:28", Meters: 28, MetersPerSecond: 1, KilometersPerHour: 3.6},
+			{Timestamp: "2024-03-06 12:23", Meters: 23, MetersPerSecond: 1, KilometersPerHour: 3.6},
+		},
+		{
+			{Timestamp: "2024-03-06 12:22", Meters: 22, MetersPerSecond: 1, KilometersPerHour: 3.6},
+			{Timestamp: "2024-03-06 12:27", Meters: 27, MetersPerSecond: 1, KilometersPerHour: 3.6},
+			{Timestamp: "2024-03-06 12:28", Meters: 28, MetersPerSecond: 1, KilometersPerHour: 3.6},
+			{Timestamp: "2024-03-06 12:20", Meters: 20, MetersPerSecond: 1, KilometersPerHour: 3.6},
+		},
+	}
+	for _, batch := range batches {
+		if err := srv.writeStats(context.Background(), batch); err != nil {
+			t.Fatalf("writeStats failed: %s", err)
+		}
+	}
+
+	expected := []string{"2024-03-06 12:22", "2024-03-06 12:23", "2024-03-06 12:25", "2024-03-06 12:27", "2024-03-06 12:28"}
+	if got := eventTimestamps(srv.lastEvents); !reflect.DeepEqual(got, expected) {
+		t.Errorf("Last events are %v, expected %v", got, expected)
+	}
+
+	// Persisted in the same order
+	restarted := NewServerWithClient(false, srv.db, "")
+	restarted.SetClock(srv.clock)
+	if err := restarted.loadData(context.Background()); err != nil {
+		t.Fatalf("loadData failed: %s", err)
+	}
+	if got := eventTimestamps(restarted.lastEvents); !reflect.DeepEqual(got, expected) {
+		t.Errorf("Last events are %v after a restart, expected %v", got, expected)
+	}
+	if !fake.has("events", "lastEvents") {
+		t.Errorf("Last events weren't saved")
+	}
+}
@@ -0,0 +1,508 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"github.com/golang/protobuf/ptypes"
+	"github.com/golang/protobuf/ptypes/timestamp"
+	"google.golang.org/api/option"
+	pb "google.golang.org/genproto/googleapis/firestore/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const testProjectId = "godometer-test"
+
+// In-memory Firestore behind a real gRPC server, just enough of it for what the server does: getting and listing
+// documents, batched writes and queries ordered by document ID. Tests can make writes to given documents fail, or
+// the whole DB unreachable.
+type fakeFirestore struct {
+	pb.UnimplementedFirestoreServer
+	addr        string
+	mutex       sync.Mutex
+	docs        map[string]*pb.Document
+	failIds     map[string]bool
+	failReads   map[string]bool
+	unavailable bool
+	commitDelay time.Duration
+	getDelay    time.Duration
+	commits     int
+	// Documents returned by queries
+	queried int
+}
+
+// Starts a fake Firestore for the test, and returns it with a client connected to it
+func newFakeFirestore(t *testing.T) (*fakeFirestore, *firestore.Client) {
+	fake := &fakeFirestore{
+		docs:      map[string]*pb.Document{},
+		failIds:   map[string]bool{},
+		failReads: map[string]bool{},
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %s", err)
+	}
+	fake.addr = listener.Addr().String()
+
+	grpcServer := grpc.NewServer()
+	pb.RegisterFirestoreServer(grpcServer, fake)
+	go func() {
+		_ = grpcServer.Serve(listener)
+	}()
+	t.Cleanup(grpcServer.Stop)
+
+	return fake, fake.newClient(t)
+}
+
+// Another client of the same fake Firestore
+func (f *fakeFirestore) newClient(t *testing.T) *firestore.Client {
+	conn, err := grpc.Dial(f.addr, grpc.WithInsecure())
+	if err != nil {
+		t.Fatalf("Failed to dial fake Firestore: %s", err)
+	}
+
+	client, err := firestore.NewClient(context.Background(), testProjectId, option.WithGRPCConn(conn))
+	if err != nil {
+		t.Fatalf("Failed to create Firestore client: %s", err)
+	}
+
+	// Closing twice is fine, the server might have closed it already
+	t.Cleanup(func() {
+		_ = client.Close()
+	})
+
+	return client
+}
+
+// A server using a fresh fake Firestore, with a fake clock at the given time
+func newTestServer(t *testing.T, now time.Time) (*Server, *fakeFirestore, *FakeClock) {
+	fake, client := newFakeFirestore(t)
+	clock := NewFakeClock(now)
+
+	srv := NewServerWithClient(false, client, "")
+	srv.SetClock(clock)
+	srv.initRecords(now)
+
+	return srv, fake, clock
+}
+
+func testDocName(collection string, id string) string {
+	return fmt.Sprintf("projects/%s/databases/(default)/documents/%s/%s", testProjectId, collection, id)
+}
+
+func docIdOf(name string) string {
+	return name[strings.LastIndex(name, "/")+1:]
+}
+
+// Makes commits writing to any of the ids fail
+func (f *fakeFirestore) fail(ids ...string) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	for _, id := range ids {
+		f.failIds[id] = true
+	}
+}
+
+// Makes getting any of the ids fail
+func (f *fakeFirestore) failRead(ids ...string) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	for _, id := range ids {
+		f.failReads[id] = true
+	}
+}
+
+func (f *fakeFirestore) recover() {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.failIds = map[string]bool{}
+	f.failReads = map[string]bool{}
+	f.unavailable = false
+}
+
+func (f *fakeFirestore) setUnavailable(unavailable bool) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.unavailable = unavailable
+}
+
+// Makes commits slow
+func (f *fakeFirestore) setCommitDelay(delay time.Duration) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.commitDelay = delay
+}
+
+// Makes reading single documents take this long
+func (f *fakeFirestore) setGetDelay(delay time.Duration) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.getDelay = delay
+}
+
+func (f *fakeFirestore) commitCount() int {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return f.commits
+}
+
+func (f *fakeFirestore) queriedCount() int {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return f.queried
+}
+
+func (f *fakeFirestore) has(period string, id string) bool {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	_, ok := f.docs[testDocName(collectionName(period), id)]
+	return ok
+}
+
+// The stored value of a field, nil when there's no such document or field
+func (f *fakeFirestore) field(period string, id string, name string) *pb.Value {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	doc, ok := f.docs[testDocName(collectionName(period), id)]
+	if !ok {
+		return nil
+	}
+	return doc.Fields[name]
+}
+
+func (f *fakeFirestore) ids(period string) []string {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	var ids []string
+	prefix := testDocName(collectionName(period), "")
+	for name := range f.docs {
+		if strings.HasPrefix(name, prefix) && !strings.Contains(name[len(prefix):], "/") {
+			ids = append(ids, docIdOf(name))
+		}
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// Not retried by the client, so failing tests don't have to wait for the backoff
+func (f *fakeFirestore) checkAvailable() error {
+	if f.unavailable {
+		return status.Error(codes.ResourceExhausted, "fake Firestore is unavailable")
+	}
+	return nil
+}
+
+func (f *fakeFirestore) GetDocument(ctx context.Context, req *pb.GetDocumentRequest) (*pb.Document, error) {
+	f.mutex.Lock()
+	delay := f.getDelay
+	f.mutex.Unlock()
+	time.Sleep(delay)
+
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	if err := f.checkAvailable(); err != nil {
+		return nil, err
+	}
+
+	doc, ok := f.docs[req.Name]
+	if !ok {
+		return nil, status.Errorf(codes.NotFound, "%s not found", req.Name)
+	}
+	return doc, nil
+}
+
+func (f *fakeFirestore) BatchGetDocuments(req *pb.BatchGetDocumentsRequest, stream pb.Firestore_BatchGetDocumentsServer) error {
+	f.mutex.Lock()
+	if err := f.checkAvailable(); err != nil {
+		f.mutex.Unlock()
+		return err
+	}
+
+	var responses []*pb.BatchGetDocumentsResponse
+	for _, name := range req.Documents {
+		if f.failReads[docIdOf(name)] {
+			f.mutex.Unlock()
+			return status.Errorf(codes.ResourceExhausted, "reading %s failed", name)
+		}
+
+		response := &pb.BatchGetDocumentsResponse{ReadTime: ptypes.TimestampNow()}
+		if doc, ok := f.docs[name]; ok {
+			response.Result = &pb.BatchGetDocumentsResponse_Found{Found: doc}
+		} else {
+			response.Result = &pb.BatchGetDocumentsResponse_Missing{Missing: name}
+		}
+		responses = append(responses, response)
+	}
+	f.mutex.Unlock()
+
+	for _, response := range responses {
+		if err := stream.Send(response); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (f *fakeFirestore) Commit(ctx context.Context, req *pb.CommitRequest) (*pb.CommitResponse, error) {
+	f.mutex.Lock()
+	delay := f.commitDelay
+	f.mutex.Unlock()
+	time.Sleep(delay)
+
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	if err := f.checkAvailable(); err != nil {
+		return nil, err
+	}
+
+	for _, w := range req.Writes {
+		name := w.GetDelete()
+		if update := w.GetUpdate(); update != nil {
+			name = update.Name
+		}
+		if f.failIds[docIdOf(name)] {
+			return nil, status.Errorf(codes.ResourceExhausted, "writing %s failed", name)
+		}
+	}
+
+	now := ptypes.TimestampNow()
+	response := &pb.CommitResponse{CommitTime: now}
+	for _, w := range req.Writes {
+		if update := w.GetUpdate(); update != nil {
+			f.applyUpdate(update, w.UpdateMask, now)
+		} else {
+			delete(f.docs, w.GetDelete())
+		}
+		response.WriteResults = append(response.WriteResults, &pb.WriteResult{UpdateTime: now})
+	}
+	f.commits += 1
+
+	return response, nil
+}
+
+func (f *fakeFirestore) applyUpdate(update *pb.Document, mask *pb.DocumentMask, now *timestamp.Timestamp) {
+	existing, ok := f.docs[update.Name]
+	if !ok || mask == nil {
+		f.docs[update.Name] = &pb.Document{
+			Name:       update.Name,
+			Fields:     update.Fields,
+			CreateTime: now,
+			UpdateTime: now,
+		}
+		if ok {
+			f.docs[update.Name].CreateTime = existing.CreateTime
+		}
+		if mask == nil {
+			return
+		}
+		existing = &pb.Document{Name: update.Name, Fields: map[string]*pb.Value{}, CreateTime: now}
+	}
+
+	fields := copyFields(existing.Fields)
+	for _, path := range mask.FieldPaths {
+		setField(fields, splitFieldPath(path), lookupField(update.Fields, splitFieldPath(path)))
+	}
+	f.docs[update.Name] = &pb.Document{
+		Name:       update.Name,
+		Fields:     fields,
+		CreateTime: existing.CreateTime,
+		UpdateTime: now,
+	}
+}
+
+func copyFields(fields map[string]*pb.Value) map[string]*pb.Value {
+	copied := map[string]*pb.Value{}
+	for key, value := range fields {
+		if m := value.GetMapValue(); m != nil {
+			value = &pb.Value{ValueType: &pb.Value_MapValue{MapValue: &pb.MapValue{Fields: copyFields(m.Fields)}}}
+		}
+		copied[key] = value
+	}
+	return copied
+}
+
+// Splits a.`b c`.d into its parts
+func splitFieldPath(path string) []string {
+	var parts []string
+	var current strings.Builder
+	quoted := false
+	for i := 0; i < len(path); i++ {
+		c := path[i]
+		switch {
+		case c == '\\' && quoted && i+1 < len(path):
+			i++
+			current.WriteByte(path[i])
+		case c == '`':
+			quoted = !quoted
+		case c == '.' && !quoted:
+			parts = append(parts, current.String())
+			current.Reset()
+		default:
+			current.WriteByte(c)
+		}
+	}
+	return append(parts, current.String())
+}
+
+func lookupField(fields map[string]*pb.Value, path []string) *pb.Value {
+	value, ok := fields[path[0]]
+	if !ok || len(path) == 1 {
+		return value
+	}
+	m := value.GetMapValue()
+	if m == nil {
+		return nil
+	}
+	return lookupField(m.Fields, path[1:])
+}
+
+func setField(fields map[string]*pb.Value, path []string, value *pb.Value) {
+	if len(path) == 1 {
+		if value == nil {
+			delete(fields, path[0])
+		} else {
+			fields[path[0]] = value
+		}
+		return
+	}
+
+	m := fields[path[0]].GetMapValue()
+	if m == nil {
+		m = &pb.MapValue{Fields: map[string]*pb.Value{}}
+		fields[path[0]] = &pb.Value{ValueType: &pb.Value_MapValue{MapValue: m}}
+	}
+	setField(m.Fields, path[1:], value)
+}
+
+func (f *fakeFirestore) collectionDocs(parent string, collection string) []*pb.Document {
+	prefix := parent + "/" + collection + "/"
+	var docs []*pb.Document
+	for name, doc := range f.docs {
+		if strings.HasPrefix(name, prefix) && !strings.Contains(name[len(prefix):], "/") {
+			docs = append(docs, doc)
+		}
+	}
+	sort.Slice(docs, func(i, j int) bool {
+		return docs[i].Name < docs[j].Name
+	})
+	return docs
+}
+
+func (f *fakeFirestore) ListDocuments(ctx context.Context, req *pb.ListDocumentsRequest) (*pb.ListDocumentsResponse, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	if err := f.checkAvailable(); err != nil {
+		return nil, err
+	}
+
+	return &pb.ListDocumentsResponse{Documents: f.collectionDocs(req.Parent, req.CollectionId)}, nil
+}
+
+// Only queries on a single collection, ordered by document ID if at all
+func (f *fakeFirestore) RunQuery(req *pb.RunQueryRequest, stream pb.Firestore_RunQueryServer) error {
+	f.mutex.Lock()
+	if err := f.checkAvailable(); err != nil {
+		f.mutex.Unlock()
+		return err
+	}
+
+	query := req.GetStructuredQuery()
+	if query == nil || len(query.From) != 1 || query.Where != nil {
+		f.mutex.Unlock()
+		return status.Error(codes.Unimplemented, "unsupported query")
+	}
+
+	desc := false
+	for _, order := range query.OrderBy {
+		if order.Field.FieldPath != firestore.DocumentID {
+			f.mutex.Unlock()
+			return status.Errorf(codes.Unimplemented, "unsupported order by %s", order.Field.FieldPath)
+		}
+		desc = order.Direction == pb.StructuredQuery_DESCENDING
+	}
+
+	docs := f.collectionDocs(req.Parent, query.From[0].CollectionId)
+	f.mutex.Unlock()
+
+	if desc {
+		for i, j := 0, len(docs)-1; i < j; i, j = i+1, j-1 {
+			docs[i], docs[j] = docs[j], docs[i]
+		}
+	}
+
+	// Position of the document relative to the cursor in the query's order
+	compare := func(doc *pb.Document, cursor *pb.Cursor) int {
+		c := strings.Compare(doc.Name, cursor.Values[0].GetReferenceValue())
+		if desc {
+			c = -c
+		}
+		return c
+	}
+
+	var results []*pb.Document
+	for _, doc := range docs {
+		if start := query.StartAt; start != nil {
+			c := compare(doc, start)
+			if c < 0 || (c == 0 && !start.Before) {
+				continue
+			}
+		}
+		if end := query.EndAt; end != nil {
+			c := compare(doc, end)
+			if c > 0 || (c == 0 && end.Before) {
+				continue
+			}
+		}
+		results = append(results, doc)
+	}
+
+	if query.Offset > 0 {
+		if int(query.Offset) >= len(results) {
+			results = nil
+		} else {
+			results = results[query.Offset:]
+		}
+	}
+	if query.Limit != nil && int(query.Limit.Value) < len(results) {
+		results = results[:query.Limit.Value]
+	}
+
+	f.mutex.Lock()
+	f.queried += len(results)
+	f.mutex.Unlock()
+
+	if len(results) == 0 {
+		return stream.Send(&pb.RunQueryResponse{ReadTime: ptypes.TimestampNow()})
+	}
+
+	for _, doc := range results {
+		err := stream.Send(&pb.RunQueryResponse{Document: doc, ReadTime: ptypes.TimestampNow()})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Stores the record in the server's DB the same way writeStats would
+func seedRecord(t *testing.T, srv *Server, period string, id string, row DBDataPoint) {
+	t.Helper()
+	_, err := srv.db.Collection(collectionName(period)).Doc(id).Set(context.Background(), srv.storable(row))
+	if err != nil {
+		t.Fatalf("Failed to seed %s %s: %s", period, id, err)
+	}
+}
@@ -0,0 +1,41 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+// Don't let a slow DB hang the probe
+const readinessTimeout = 2 * time.Second
+
+type HealthResponse struct {
+	Status string `json:"status"`
+}
+
+// Liveness, the process is up and serving requests
+func (s *Server) returnHealth(c *gin.Context) {
+	c.JSON(200, HealthResponse{Status: "ok"})
+}
+
+// Readiness, the DB is reachable. The sentinel document doesn't need to exist.
+func (s *Server) returnReadiness(c *gin.Context) {
+	ctx, cancel := context.WithTimeout(c.Request.Context(), readinessTimeout)
+	defer cancel()
+
+	_, err := s.db.Collection(collectionName("health")).Doc("readiness").Get(ctx)
+	if err != nil {
+		err = storageError(err)
+		if !errors.Is(err, ErrNotFound) {
+			logger.Warn("Readiness check failed", zap.Error(err))
+			c.JSON(http.StatusServiceUnavailable, HealthResponse{Status: "unavailable"})
+			return
+		}
+	}
+
+	c.JSON(200, HealthResponse{Status: "ok"})
+}
@@ -0,0 +1,26 @@
+package server
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestReadiness(t *testing.T) {
+	srv, fake, _ := newTestServer(t, testNow)
+
+	response := HealthResponse{}
+	if rec := getJSON(t, srv, "/readyz", &response); rec.Code != http.StatusOK || response.Status != "ok" {
+		t.Errorf("Readiness with a healthy DB returned %d %q", rec.Code, response.Status)
+	}
+
+	fake.setUnavailable(true)
+
+	if rec := getJSON(t, srv, "/readyz", nil); rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("Readiness with an unreachable DB returned %d, expected 503", rec.Code)
+	}
+
+	// Liveness doesn't care about the DB
+	if rec := getJSON(t, srv, "/healthz", nil); rec.Code != http.StatusOK {
+		t.Errorf("Liveness returned %d", rec.Code)
+	}
+}
@@ -0,0 +1,107 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Bands of 0-5, 5-10, 10-15 and 15+ km/h
+var defaultHistogramEdges = []float32{5, 10, 15}
+
+type HistogramBand struct {
+	From  float32  `json:"from"`
+	To    *float32 `json:"to"`
+	Count int64    `json:"count"`
+}
+
+type HistogramResponse struct {
+	Period string          `json:"period"`
+	Bands  []HistogramBand `json:"bands"`
+}
+
+func parseHistogramEdges(value string) ([]float32, error) {
+	if value == "" {
+		return defaultHistogramEdges, nil
+	}
+
+	var edges []float32
+	for _, part := range strings.Split(value, ",") {
+		f, err := strconv.ParseFloat(strings.TrimSpace(part), 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid edge %q: %w", part, err)
+		}
+		if f <= 0 {
+			return nil, fmt.Errorf("edges must be positive, got %q", part)
+		}
+		edges = append(edges, float32(f))
+	}
+
+	sort.Slice(edges, func(i, j int) bool {
+		return edges[i] < edges[j]
+	})
+
+	return edges, nil
+}
+
+// Counts how many minutes were spent in each speed band. Minute records are a single sample each, the coarser
+// periods have the average of Counter samples.
+func histogram(period string, records map[string]DBDataPoint, edges []float32) []HistogramBand {
+	var bands []HistogramBand
+	from := float32(0)
+	for i := range edges {
+		to := edges[i]
+		bands = append(bands, HistogramBand{From: from, To: &to})
+		from = to
+	}
+	bands = append(bands, HistogramBand{From: from})
+
+	for _, row := range records {
+		kph := zeroNaN(row.KilometersPerHour)
+		if kph <= 0 {
+			continue
+		}
+
+		weight := row.Counter
+		if period == "minutes" {
+			weight = 1
+		}
+
+		band := sort.Search(len(edges), func(i int) bool {
+			return kph < edges[i]
+		})
+		bands[band].Count += weight
+	}
+
+	return bands
+}
+
+func (s *Server) returnHistogram(c *gin.Context) {
+	period := c.Param("period")
+	edges, err := parseHistogramEdges(c.Query("edges"))
+	if err != nil {
+		badRequest(c, err)
+		return
+	}
+
+	s.writeMutex.RLock()
+	records, ok := s.periodRecords(period)
+	var bands []HistogramBand
+	if ok {
+		bands = histogram(period, records, edges)
+	}
+	s.writeMutex.RUnlock()
+	if !ok {
+		c.AbortWithStatus(http.StatusNotFound)
+		return
+	}
+
+	c.JSON(200, HistogramResponse{
+		Period: period,
+		Bands:  bands,
+	})
+}
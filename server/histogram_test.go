@@ -0,0 +1,66 @@
+package server
+
+import (
+	"net/http"
+	"testing"
+)
+
+func bandCounts(bands []HistogramBand) []int64 {
+	var counts []int64
+	for _, band := range bands {
+		counts = append(counts, band.Count)
+	}
+	return counts
+}
+
+func TestHistogramOfMinutes(t *testing.T) {
+	records := map[string]DBDataPoint{
+		"2024-03-06 12:00": {Counter: 1, KilometersPerHour: 3},
+		"2024-03-06 12:01": {Counter: 1, KilometersPerHour: 4.9},
+		"2024-03-06 12:02": {Counter: 1, KilometersPerHour: 5},
+		"2024-03-06 12:03": {Counter: 1, KilometersPerHour: 12},
+		"2024-03-06 12:04": {Counter: 1, KilometersPerHour: 14.5},
+		"2024-03-06 12:05": {Counter: 1, KilometersPerHour: 22},
+		"2024-03-06 12:06": {},
+	}
+
+	counts := bandCounts(histogram("minutes", records, defaultHistogramEdges))
+	expected := []int64{2, 1, 2, 1}
+	for i := range expected {
+		if counts[i] != expected[i] {
+			t.Fatalf("Band counts are %v, expected %v", counts, expected)
+		}
+	}
+}
+
+func TestHistogramWeighsByCounter(t *testing.T) {
+	records := map[string]DBDataPoint{
+		"2024-03-06 11": {Counter: 40, KilometersPerHour: 8},
+		"2024-03-06 12": {Counter: 15, KilometersPerHour: 16},
+	}
+
+	counts := bandCounts(histogram("hours", records, []float32{10}))
+	if len(counts) != 2 || counts[0] != 40 || counts[1] != 15 {
+		t.Errorf("Band counts are %v, expected [40 15]", counts)
+	}
+}
+
+func TestHistogramEdgesParameter(t *testing.T) {
+	srv, _, _ := newTestServer(t, testNow)
+	srv.minutes["2024-03-06 12:30"] = DBDataPoint{Counter: 1, KilometersPerHour: 7}
+
+	response := HistogramResponse{}
+	rec := getJSON(t, srv, "/api/v1/histogram/minutes?edges=8,6", &response)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Histogram failed with %d: %s", rec.Code, rec.Body.String())
+	}
+
+	if len(response.Bands) != 3 || response.Bands[1].From != 6 || *response.Bands[1].To != 8 || response.Bands[1].Count != 1 {
+		t.Errorf("Unexpected bands %+v", response.Bands)
+	}
+
+	rec = getJSON(t, srv, "/api/v1/histogram/minutes?edges=fast", nil)
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("Invalid edges returned %d, expected 400", rec.Code)
+	}
+}
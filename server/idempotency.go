@@ -0,0 +1,130 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const idempotencyHeader = "Idempotency-Key"
+
+// Clients are expected to give up retrying well before this
+const keepIdempotencyKeys = 24 * time.Hour
+
+// A request still being processed after this is taken to have been abandoned, e.g. by a panic, so the key can be
+// retried instead of getting conflicts until the restart
+const keepPendingIdempotencyKeys = time.Minute
+
+// Status is 0 while the request is still being processed
+type IdempotencyRecord struct {
+	Status  int       `firestore:"status"`
+	Created time.Time `firestore:"created"`
+}
+
+// Keys are used as document IDs
+func validIdempotencyKey(key string) bool {
+	return len(key) <= 200 && !strings.Contains(key, "/") && key != "." && key != ".."
+}
+
+type idempotencyStore struct {
+	mutex sync.Mutex
+	keys  map[string]IdempotencyRecord
+}
+
+func newIdempotencyStore() *idempotencyStore {
+	return &idempotencyStore{
+		keys: map[string]IdempotencyRecord{},
+	}
+}
+
+// Caller must hold the store's mutex
+func (s *Server) pruneIdempotencyKeys() {
+	for key, record := range s.idempotency.keys {
+		if s.idempotencyExpired(record) {
+			delete(s.idempotency.keys, key)
+		}
+	}
+}
+
+func (s *Server) idempotencyExpired(record IdempotencyRecord) bool {
+	age := s.clock.Now().Sub(record.Created)
+	if record.Status == 0 {
+		return age > keepPendingIdempotencyKeys
+	}
+	return age > keepIdempotencyKeys
+}
+
+// Returns the earlier record if the key has been seen before, otherwise marks it as being processed. The DB is read
+// without holding the store's mutex, so other requests don't wait for it.
+func (s *Server) startIdempotent(ctx context.Context, key string) (*IdempotencyRecord, error) {
+	store := s.idempotency
+	store.mutex.Lock()
+	if record, ok := store.keys[key]; ok && !s.idempotencyExpired(record) {
+		store.mutex.Unlock()
+		return &record, nil
+	}
+	store.mutex.Unlock()
+
+	// Might have been processed before a restart
+	var stored *IdempotencyRecord
+	doc, err := s.db.Collection(collectionName("idempotency")).Doc(key).Get(ctx)
+	if err == nil {
+		record := IdempotencyRecord{}
+		err = doc.DataTo(&record)
+		if err != nil {
+			return nil, err
+		}
+
+		if !s.idempotencyExpired(record) {
+			stored = &record
+		}
+	} else if err = storageError(err); !errors.Is(err, ErrNotFound) {
+		return nil, err
+	}
+
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	// Another request with the key may have got here first while reading
+	if record, ok := store.keys[key]; ok && !s.idempotencyExpired(record) {
+		return &record, nil
+	}
+	if stored != nil {
+		store.keys[key] = *stored
+		return stored, nil
+	}
+
+	s.pruneIdempotencyKeys()
+	store.keys[key] = IdempotencyRecord{
+		Status:  0,
+		Created: s.clock.Now(),
+	}
+
+	return nil, nil
+}
+
+// Only successes are remembered, failed requests can be retried with the same key
+func (s *Server) finishIdempotent(ctx context.Context, key string, status int) {
+	store := s.idempotency
+	store.mutex.Lock()
+	if status < 200 || status > 299 {
+		delete(store.keys, key)
+		store.mutex.Unlock()
+		return
+	}
+
+	record := store.keys[key]
+	record.Status = status
+	store.keys[key] = record
+	store.mutex.Unlock()
+
+	_, err := s.db.Collection(collectionName("idempotency")).Doc(key).Set(ctx, record)
+	if err != nil {
+		// We still remember it until restart
+		logger.Warn("Failed to save idempotency key", zap.String("key", key), zap.Error(err))
+	}
+}
@@ -0,0 +1,121 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/lietu/godometer"
+)
+
+// Returns the status and whether it was replayed
+func postStatsWithKey(srv *Server, key string, dataPoints ...godometer.UpdateDataPoint) (int, bool) {
+	body := godometer.UpdateStatsRequest{DataPoints: dataPoints}
+	rec := request(srv, http.MethodPost, "/api/v1/updateStats", body, map[string]string{idempotencyHeader: key})
+	return rec.Code, rec.Header().Get("Idempotent-Replayed") == "true"
+}
+
+func TestIdempotencyKeyReplay(t *testing.T) {
+	srv, fake, _ := newTestServer(t, testNow)
+	hour := "2024-03-06 12"
+
+	first := godometer.UpdateDataPoint{Timestamp: "2024-03-06 12:20", Meters: 100, MetersPerSecond: 2, KilometersPerHour: 7.2}
+	if status, _ := postStatsWithKey(srv, "request-1", first); status != http.StatusOK {
+		t.Fatalf("First request returned %d", status)
+	}
+	commits := fake.commitCount()
+
+	if status, replayed := postStatsWithKey(srv, "request-1", first); status != http.StatusOK || !replayed {
+		t.Errorf("Repeated request returned %d replayed %v, expected a replayed 200", status, replayed)
+	}
+
+	// The key is what counts, even if the retry differs slightly
+	retried := godometer.UpdateDataPoint{Timestamp: "2024-03-06 12:21", Meters: 101, MetersPerSecond: 2, KilometersPerHour: 7.2}
+	if status, replayed := postStatsWithKey(srv, "request-1", retried); status != http.StatusOK || !replayed {
+		t.Errorf("Retry with the same key returned %d replayed %v, expected a replayed 200", status, replayed)
+	}
+
+	if fake.commitCount() != commits {
+		t.Errorf("Replays committed %d more times", fake.commitCount()-commits)
+	}
+	if row := srv.hours[hour]; row.Meters != 100 || row.Counter != 1 {
+		t.Errorf("Hour is %s, expected only the first request in it", recordStr(row))
+	}
+}
+
+func TestIdempotencyKeySurvivesRestart(t *testing.T) {
+	srv, _, _ := newTestServer(t, testNow)
+	dp := godometer.UpdateDataPoint{Timestamp: "2024-03-06 12:20", Meters: 100, MetersPerSecond: 2, KilometersPerHour: 7.2}
+	if status, _ := postStatsWithKey(srv, "request-1", dp); status != http.StatusOK {
+		t.Fatalf("First request returned %d", status)
+	}
+
+	// Same DB, fresh memory
+	restarted := NewServerWithClient(false, srv.db, "")
+	restarted.SetClock(srv.clock)
+	if status, replayed := postStatsWithKey(restarted, "request-1", dp); status != http.StatusOK || !replayed {
+		t.Errorf("Repeated request after a restart returned %d replayed %v, expected a replayed 200", status, replayed)
+	}
+}
+
+func TestIdempotencyKeyAfterFailure(t *testing.T) {
+	srv, fake, _ := newTestServer(t, testNow)
+	dp := godometer.UpdateDataPoint{Timestamp: "2024-03-06 12:20", Meters: 100, MetersPerSecond: 2, KilometersPerHour: 7.2}
+
+	fake.fail(dp.Timestamp)
+	if status, _ := postStatsWithKey(srv, "request-1", dp); status == http.StatusOK {
+		t.Fatalf("Expected the first request to fail")
+	}
+
+	fake.recover()
+	if status, replayed := postStatsWithKey(srv, "request-1", dp); status != http.StatusOK || replayed {
+		t.Errorf("Retry after a failure returned %d replayed %v, expected it to be processed", status, replayed)
+	}
+	if row := srv.minutes[dp.Timestamp]; row.Meters != 100 {
+		t.Errorf("Minute is %s, expected the retry in it", recordStr(row))
+	}
+}
+
+func TestAbandonedIdempotencyKeyExpires(t *testing.T) {
+	srv, _, clock := newTestServer(t, testNow)
+	dp := godometer.UpdateDataPoint{Timestamp: "2024-03-06 12:20", Meters: 100, MetersPerSecond: 2, KilometersPerHour: 7.2}
+
+	// As if the request processing it never finished
+	if record, err := srv.startIdempotent(context.Background(), "request-1"); err != nil || record != nil {
+		t.Fatalf("Starting returned %v, %v", record, err)
+	}
+	if status, _ := postStatsWithKey(srv, "request-1", dp); status != http.StatusConflict {
+		t.Errorf("Request while the key is being processed returned %d, expected 409", status)
+	}
+
+	clock.Add(keepPendingIdempotencyKeys + time.Second)
+	if status, replayed := postStatsWithKey(srv, "request-1", dp); status != http.StatusOK || replayed {
+		t.Errorf("Request with an abandoned key returned %d replayed %v, expected it to be processed", status, replayed)
+	}
+}
+
+func TestIdempotencyLookupsDontWaitForEachOther(t *testing.T) {
+	srv, fake, _ := newTestServer(t, testNow)
+	delay := 200 * time.Millisecond
+	fake.setGetDelay(delay)
+
+	start := time.Now()
+	wg := sync.WaitGroup{}
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if _, err := srv.startIdempotent(context.Background(), fmt.Sprintf("request-%d", i)); err != nil {
+				t.Errorf("Starting request-%d failed: %s", i, err)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	if elapsed := time.Since(start); elapsed >= 3*delay {
+		t.Errorf("Four lookups took %s, they're done one at a time", elapsed)
+	}
+}
@@ -0,0 +1,76 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Gaps of up to this many minutes are assumed to be the sensor dropping out, longer ones actual idle time. Nothing
+// longer than the minutes kept in memory can be filled anyway.
+const (
+	defaultInterpolateMaxGap = 5
+	maxInterpolateMaxGap     = 60
+)
+
+// Applies ?interpolate=true&maxGap=N to minute data, other periods don't support interpolation. Caller must hold
+// writeMutex.
+func (s *Server) maybeInterpolate(c *gin.Context, period string, events []ResponseDataPoint) ([]ResponseDataPoint, error) {
+	interpolate, err := strconv.ParseBool(c.DefaultQuery("interpolate", "false"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid interpolate value: %w", err)
+	}
+
+	if !interpolate {
+		return events, nil
+	}
+
+	if period != "minutes" {
+		return nil, errors.New("interpolation is only supported for minutes")
+	}
+
+	maxGap, err := queryPositiveInt(c, "maxGap", defaultInterpolateMaxGap, maxInterpolateMaxGap)
+	if err != nil {
+		return nil, err
+	}
+
+	return interpolateMinutes(events, s.minutes, maxGap), nil
+}
+
+// Linearly fills short runs of minutes with no data between two minutes with distance. Records with a zero Counter
+// never received an update, so they're "no data" instead of a real zero.
+func interpolateMinutes(events []ResponseDataPoint, records map[string]DBDataPoint, maxGap int) []ResponseDataPoint {
+	hasData := func(i int) bool {
+		return records[events[i].Timestamp].Counter > 0
+	}
+
+	// Index of the last minute with data and distance, -1 if none yet
+	prev := -1
+	for i := range events {
+		if !hasData(i) {
+			continue
+		}
+
+		gap := i - prev - 1
+		if prev >= 0 && gap > 0 && gap <= maxGap && events[prev].Meters > 0 && events[i].Meters > 0 {
+			from := events[prev]
+			to := events[i]
+			steps := float32(gap + 1)
+			for j := 1; j <= gap; j++ {
+				f := float32(j) / steps
+				e := events[prev+j]
+				e.Meters = from.Meters + (to.Meters-from.Meters)*f
+				e.MetersPerSecond = from.MetersPerSecond + (to.MetersPerSecond-from.MetersPerSecond)*f
+				e.KilometersPerHour = from.KilometersPerHour + (to.KilometersPerHour-from.KilometersPerHour)*f
+				e.Interpolated = true
+				events[prev+j] = e.withPace()
+			}
+		}
+
+		prev = i
+	}
+
+	return events
+}
@@ -0,0 +1,98 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+)
+
+// Minutes 12:00 to 12:19 with data in the given ones
+func testMinutes(meters map[int]float32) ([]ResponseDataPoint, map[string]DBDataPoint) {
+	var events []ResponseDataPoint
+	records := map[string]DBDataPoint{}
+	for i := 0; i < 20; i++ {
+		ts := fmt.Sprintf("2024-03-06 12:%02d", i)
+		row := DBDataPoint{}
+		if m, ok := meters[i]; ok {
+			row = DBDataPoint{Counter: 1, Meters: m, MetersPerSecond: m / 60, KilometersPerHour: m / 60 * 3.6}
+		}
+		records[ts] = row
+		events = append(events, row.toResponseDataPoint(ts))
+	}
+	return events, records
+}
+
+func TestInterpolateShortGap(t *testing.T) {
+	events, records := testMinutes(map[int]float32{2: 60, 5: 120})
+	events = interpolateMinutes(events, records, 5)
+
+	for i, expected := range map[int]float32{3: 80, 4: 100} {
+		e := events[i]
+		if !e.Interpolated || !closeTo(e.Meters, expected) {
+			t.Errorf("Minute %s is %vm interpolated %v, expected %vm interpolated", e.Timestamp, e.Meters, e.Interpolated, expected)
+		}
+		if e.PaceMinPerKm == nil {
+			t.Errorf("Interpolated minute %s has no pace", e.Timestamp)
+		}
+	}
+
+	for _, i := range []int{1, 2, 5, 6} {
+		if events[i].Interpolated {
+			t.Errorf("Minute %s shouldn't be interpolated", events[i].Timestamp)
+		}
+	}
+}
+
+func TestInterpolateLeavesLongGap(t *testing.T) {
+	events, records := testMinutes(map[int]float32{2: 60, 10: 120})
+	events = interpolateMinutes(events, records, 5)
+
+	for i := 3; i < 10; i++ {
+		if events[i].Interpolated || events[i].Meters != 0 {
+			t.Errorf("Minute %s in a long gap is %vm interpolated %v, expected it left at zero", events[i].Timestamp, events[i].Meters, events[i].Interpolated)
+		}
+	}
+}
+
+func TestInterpolateKeepsRealZero(t *testing.T) {
+	events, records := testMinutes(map[int]float32{2: 60, 3: 0, 4: 60})
+	events = interpolateMinutes(events, records, 5)
+
+	if events[3].Interpolated || events[3].Meters != 0 {
+		t.Errorf("Minute with an update of zero distance was interpolated to %vm", events[3].Meters)
+	}
+}
+
+func TestInterpolateParameters(t *testing.T) {
+	srv, _, _ := newTestServer(t, testNow)
+	srv.minutes["2024-03-06 12:20"] = DBDataPoint{Counter: 1, Meters: 60, MetersPerSecond: 1}
+	srv.minutes["2024-03-06 12:23"] = DBDataPoint{Counter: 1, Meters: 60, MetersPerSecond: 1}
+
+	response := StatsResponse{}
+	rec := getJSON(t, srv, "/api/v1/stats/minutes?interpolate=true&maxGap=1", &response)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Request failed with %d: %s", rec.Code, rec.Body.String())
+	}
+	for _, rdp := range response.DataPoints {
+		if rdp.Interpolated {
+			t.Errorf("Minute %s was interpolated over a gap longer than maxGap", rdp.Timestamp)
+		}
+	}
+
+	getJSON(t, srv, "/api/v1/stats/minutes?interpolate=true", &response)
+	interpolated := 0
+	for _, rdp := range response.DataPoints {
+		if rdp.Interpolated {
+			interpolated++
+		}
+	}
+	if interpolated != 2 {
+		t.Errorf("Expected 2 interpolated minutes, got %d", interpolated)
+	}
+
+	for _, path := range []string{"/api/v1/stats/hours?interpolate=true", "/api/v1/stats/minutes?interpolate=maybe", "/api/v1/stats/minutes?interpolate=true&maxGap=0"} {
+		if rec := getJSON(t, srv, path, nil); rec.Code != http.StatusBadRequest {
+			t.Errorf("%s returned %d, expected 400", path, rec.Code)
+		}
+	}
+}
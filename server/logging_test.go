@@ -0,0 +1,38 @@
+package server
+
+import (
+	"testing"
+
+	"go.uber.org/zap/zapcore"
+)
+
+func TestLogLevel(t *testing.T) {
+	previous := logLevel.Level()
+	t.Cleanup(func() {
+		logLevel.SetLevel(previous)
+	})
+
+	if err := SetLogLevel("warn"); err != nil {
+		t.Fatalf("Failed to set level: %s", err)
+	}
+	if ce := commitLogger.Check(zapcore.InfoLevel, "Saved records to DB"); ce != nil {
+		t.Errorf("Commit messages are logged at warn level")
+	}
+	if ce := logger.Check(zapcore.WarnLevel, "Error trying to save records to DB"); ce == nil {
+		t.Errorf("Warnings aren't logged at warn level")
+	}
+	if debugDb() {
+		t.Errorf("Records are dumped at warn level")
+	}
+
+	if err := SetLogLevel("debug"); err != nil {
+		t.Fatalf("Failed to set level: %s", err)
+	}
+	if !debugDb() {
+		t.Errorf("Records aren't dumped at debug level")
+	}
+
+	if err := SetLogLevel("loud"); err == nil {
+		t.Errorf("Invalid level was accepted")
+	}
+}
@@ -0,0 +1,83 @@
+package server
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strconv"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+)
+
+var (
+	commitDurationBuckets = []float64{0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+	commitWritesBuckets   = []float64{1, 2, 5, 10, 25, 50, 100, 250, 500}
+)
+
+// Cumulative histogram in the Prometheus text format, so a couple of metrics don't need the whole client library
+type histogramMetric struct {
+	name    string
+	help    string
+	buckets []float64
+	mutex   sync.Mutex
+	counts  []uint64
+	sum     float64
+	count   uint64
+}
+
+func newHistogramMetric(name string, help string, buckets []float64) *histogramMetric {
+	return &histogramMetric{
+		name:    name,
+		help:    help,
+		buckets: buckets,
+		counts:  make([]uint64, len(buckets)),
+	}
+}
+
+func (h *histogramMetric) Observe(value float64) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	for i, le := range h.buckets {
+		if value <= le {
+			h.counts[i] += 1
+		}
+	}
+	h.sum += value
+	h.count += 1
+}
+
+func (h *histogramMetric) write(w io.Writer) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	_, _ = fmt.Fprintf(w, "# HELP %s %s\n", h.name, h.help)
+	_, _ = fmt.Fprintf(w, "# TYPE %s histogram\n", h.name)
+	for i, le := range h.buckets {
+		_, _ = fmt.Fprintf(w, "%s_bucket{le=\"%s\"} %d\n", h.name, strconv.FormatFloat(le, 'g', -1, 64), h.counts[i])
+	}
+	_, _ = fmt.Fprintf(w, "%s_bucket{le=\"+Inf\"} %d\n", h.name, h.count)
+	_, _ = fmt.Fprintf(w, "%s_sum %s\n", h.name, strconv.FormatFloat(h.sum, 'g', -1, 64))
+	_, _ = fmt.Fprintf(w, "%s_count %d\n", h.name, h.count)
+}
+
+type serverMetrics struct {
+	commitDuration *histogramMetric
+	commitWrites   *histogramMetric
+}
+
+func newServerMetrics() *serverMetrics {
+	return &serverMetrics{
+		commitDuration: newHistogramMetric("godometer_commit_duration_seconds", "Time taken to commit a batch of records to Firestore.", commitDurationBuckets),
+		commitWrites:   newHistogramMetric("godometer_commit_writes", "Number of writes in a batch committed to Firestore.", commitWritesBuckets),
+	}
+}
+
+func (s *Server) returnMetrics(c *gin.Context) {
+	var buf bytes.Buffer
+	s.metrics.commitDuration.write(&buf)
+	s.metrics.commitWrites.write(&buf)
+
+	c.Data(200, "text/plain; version=0.0.4", buf.Bytes())
+}
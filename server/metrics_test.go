@@ -0,0 +1,46 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lietu/godometer"
+)
+
+func TestCommitMetrics(t *testing.T) {
+	srv, fake, _ := newTestServer(t, testNow)
+	// Commit time is measured with the server's clock, so it has to actually move
+	srv.SetClock(RealClock{})
+	srv.initRecords(srv.clock.Now())
+	fake.setCommitDelay(50 * time.Millisecond)
+
+	err := srv.writeStats(context.Background(), []godometer.UpdateDataPoint{
+		{Timestamp: testMinute(srv.clock.Now()), Meters: 100, MetersPerSecond: 2, KilometersPerHour: 7.2},
+	})
+	if err != nil {
+		t.Fatalf("writeStats failed: %s", err)
+	}
+
+	duration := srv.metrics.commitDuration
+	if duration.count != 1 || duration.sum < 0.05 {
+		t.Errorf("Commit duration has %d observations adding up to %vs, expected one of at least 0.05s", duration.count, duration.sum)
+	}
+	if writes := srv.metrics.commitWrites; writes.count != 1 || writes.sum != 7 {
+		t.Errorf("Commit writes has %d observations adding up to %v, expected one of 7", writes.count, writes.sum)
+	}
+
+	rec := request(srv, http.MethodGet, "/metrics", nil, nil)
+	body := rec.Body.String()
+	for _, line := range []string{
+		`godometer_commit_duration_seconds_bucket{le="0.025"} 0`,
+		`godometer_commit_duration_seconds_count 1`,
+		`godometer_commit_writes_bucket{le="10"} 1`,
+	} {
+		if !strings.Contains(body, line+"\n") {
+			t.Errorf("Metrics don't have %q:\n%s", line, body)
+		}
+	}
+}
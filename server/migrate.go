@@ -0,0 +1,175 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"math"
+
+	"cloud.google.com/go/firestore"
+	"go.uber.org/zap"
+	"google.golang.org/api/iterator"
+)
+
+// Bump this and add a step to migrateDataPoint when changing DBDataPoint.
+// Version 0 had broken struct tags, so fields were stored by their Go names, and NaNs could end up in the DB.
+// Version 1 averaged speeds per update and had no moving time.
+// Version 2 only had floats, not the integer millimeters.
+const currentSchemaVersion = 3
+
+// Firestore won't take more writes than this in a single batch
+const maxBatchWrites = 500
+
+var periods = []string{"years", "months", "weeks", "days", "hours", "minutes"}
+
+func zeroNaN(value float32) float32 {
+	if math.IsNaN(float64(value)) {
+		return 0
+	}
+	return value
+}
+
+// Version 0 documents have no schemaVersion, and may have integers or NaNs in their fields
+var legacyFields = []string{"Counter", "Meters", "MetersPerSecond", "KilometersPerHour"}
+
+func legacyNumber(data map[string]interface{}, field string) (float64, error) {
+	switch value := data[field].(type) {
+	case nil:
+		return 0, nil
+	case int64:
+		return float64(value), nil
+	case float64:
+		return value, nil
+	default:
+		return 0, fmt.Errorf("legacy field %s is a %T, not a number", field, value)
+	}
+}
+
+func legacyDataPoint(data map[string]interface{}) (DBDataPoint, error) {
+	values := map[string]float64{}
+	for _, field := range legacyFields {
+		value, err := legacyNumber(data, field)
+		if err != nil {
+			return DBDataPoint{}, err
+		}
+		values[field] = value
+	}
+
+	return DBDataPoint{
+		Counter:           int64(values["Counter"]),
+		Meters:            float32(values["Meters"]),
+		MetersPerSecond:   float32(values["MetersPerSecond"]),
+		KilometersPerHour: float32(values["KilometersPerHour"]),
+	}, nil
+}
+
+// Reads a record document whichever schema version it was stored in, ready for migrateDataPoint
+func decodeDataPoint(doc *firestore.DocumentSnapshot) (DBDataPoint, error) {
+	data := doc.Data()
+	if _, ok := data["schemaVersion"]; !ok {
+		return legacyDataPoint(data)
+	}
+
+	row := DBDataPoint{}
+	err := doc.DataTo(&row)
+	if err != nil {
+		return DBDataPoint{}, err
+	}
+	return row.decoded(), nil
+}
+
+func (ddp DBDataPoint) stamped() DBDataPoint {
+	ddp.SchemaVersion = currentSchemaVersion
+	return ddp
+}
+
+func migrateDataPoint(row DBDataPoint) DBDataPoint {
+	if row.SchemaVersion < 1 {
+		row.Meters = zeroNaN(row.Meters)
+		row.MetersPerSecond = zeroNaN(row.MetersPerSecond)
+		row.KilometersPerHour = zeroNaN(row.KilometersPerHour)
+	}
+
+	if row.SchemaVersion < 2 {
+		// Best guess, assume the average speed was kept the whole way
+		row.MovingSeconds = movingSeconds(row)
+	}
+
+	if row.SchemaVersion < 3 {
+		row = row.withIntegers()
+	}
+
+	return row.stamped()
+}
+
+// Best effort, if this fails we'll just try again the next time the records are read
+func (s *Server) saveMigrated(ctx context.Context, refs []*firestore.DocumentRef, records map[string]DBDataPoint) {
+	batch := s.batch()
+	for _, ref := range refs {
+		batch.Set(ref, s.storable(records[ref.ID]))
+	}
+
+	_, err := batch.Commit(ctx)
+	if err != nil {
+		logger.Warn("Failed to save migrated records", zap.Int("count", len(refs)), zap.Error(err))
+		return
+	}
+
+	logger.Info("Migrated records", zap.Int("count", len(refs)), zap.Int("schemaVersion", currentSchemaVersion))
+}
+
+// Upgrades every outdated record in the DB, not just the ones we happen to read
+func (s *Server) Migrate(ctx context.Context) error {
+	for _, period := range periods {
+		iter := s.db.Collection(collectionName(period)).Documents(ctx)
+
+		batch := s.batch()
+		batchRecords := 0
+		migrated := 0
+		for {
+			doc, err := iter.Next()
+			if err == iterator.Done {
+				break
+			}
+			if err != nil {
+				iter.Stop()
+				return storageError(err)
+			}
+
+			row, err := decodeDataPoint(doc)
+			if err != nil {
+				logger.Warn("Failed to read data from DB to record. This is probably not great.", zap.String("id", docId(doc.Ref)), zap.Error(err))
+				continue
+			}
+
+			if row.SchemaVersion >= currentSchemaVersion {
+				continue
+			}
+
+			batch.Set(doc.Ref, s.storable(migrateDataPoint(row)))
+			batchRecords += 1
+			if batchRecords == maxBatchWrites {
+				_, err := batch.Commit(ctx)
+				if err != nil {
+					iter.Stop()
+					return storageError(err)
+				}
+				migrated += batchRecords
+				batch = s.batch()
+				batchRecords = 0
+			}
+		}
+		iter.Stop()
+
+		if batchRecords > 0 {
+			_, err := batch.Commit(ctx)
+			if err != nil {
+				return storageError(err)
+			}
+			migrated += batchRecords
+		}
+
+		logger.Info("Migrated records", zap.String("period", period), zap.Int("count", migrated))
+	}
+
+	return nil
+}
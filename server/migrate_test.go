@@ -0,0 +1,106 @@
+package server
+
+import (
+	"context"
+	"math"
+	"testing"
+)
+
+func TestMigrateLegacyDataPoint(t *testing.T) {
+	row, err := legacyDataPoint(map[string]interface{}{
+		"Counter":           int64(3),
+		"Meters":            120.0,
+		"MetersPerSecond":   2.0,
+		"KilometersPerHour": 7.2,
+	})
+	if err != nil {
+		t.Fatalf("Failed to decode legacy document: %s", err)
+	}
+
+	migrated := migrateDataPoint(row)
+	if migrated.SchemaVersion != currentSchemaVersion {
+		t.Errorf("Expected schema version %d, got %d", currentSchemaVersion, migrated.SchemaVersion)
+	}
+	if migrated.Counter != 3 {
+		t.Errorf("Expected counter 3, got %d", migrated.Counter)
+	}
+	if migrated.MetersPerSecond != 2 || migrated.KilometersPerHour != 7.2 {
+		t.Errorf("Expected the legacy speeds to be kept, got %f m/s and %f km/h", migrated.MetersPerSecond, migrated.KilometersPerHour)
+	}
+	if migrated.MovingSeconds != 60 {
+		t.Errorf("Expected 60 moving seconds, got %f", migrated.MovingSeconds)
+	}
+	if migrated.Millimeters != 120000 || migrated.MillimetersPerSecond != 2000 {
+		t.Errorf("Expected the integers to be filled in, got %dmm and %dmm/s", migrated.Millimeters, migrated.MillimetersPerSecond)
+	}
+}
+
+func TestMigrateLegacyNaN(t *testing.T) {
+	row, err := legacyDataPoint(map[string]interface{}{
+		"Counter":           int64(1),
+		"Meters":            math.NaN(),
+		"MetersPerSecond":   math.NaN(),
+		"KilometersPerHour": math.NaN(),
+	})
+	if err != nil {
+		t.Fatalf("Failed to decode legacy document: %s", err)
+	}
+
+	migrated := migrateDataPoint(row)
+	if migrated.Meters != 0 || migrated.MetersPerSecond != 0 || migrated.KilometersPerHour != 0 || migrated.MovingSeconds != 0 {
+		t.Errorf("Expected NaNs to be zeroed, got %+v", migrated)
+	}
+}
+
+func TestLegacyDataPointInvalid(t *testing.T) {
+	_, err := legacyDataPoint(map[string]interface{}{
+		"Counter": "three",
+	})
+	if err == nil {
+		t.Error("Expected an error for a non-numeric field")
+	}
+}
+
+func TestMigrateCurrentUnchanged(t *testing.T) {
+	row := DBDataPoint{
+		SchemaVersion:     currentSchemaVersion,
+		Counter:           2,
+		Meters:            10,
+		MetersPerSecond:   1,
+		KilometersPerHour: 3.6,
+		MovingSeconds:     7,
+	}.withIntegers()
+
+	migrated := migrateDataPoint(row)
+	if migrated != row {
+		t.Errorf("Expected a current record to stay the same, got %+v from %+v", migrated, row)
+	}
+}
+
+func TestReadBaselineDocument(t *testing.T) {
+	srv, fake, _ := newTestServer(t, testNow)
+	// As written before schema versions, under the Go field names
+	baseline := map[string]interface{}{"Counter": int64(3), "Meters": 150.0, "MetersPerSecond": 2.5, "KilometersPerHour": 9.0}
+	if _, err := srv.db.Collection(collectionName("hours")).Doc("2024-03-06 11").Set(context.Background(), baseline); err != nil {
+		t.Fatalf("Failed to seed: %s", err)
+	}
+
+	records, err := srv.readRecords(context.Background(), collectionName("hours"), []string{"2024-03-06 11"})
+	if err != nil {
+		t.Fatalf("readRecords failed: %s", err)
+	}
+	row := records["2024-03-06 11"]
+	if row.Counter != 3 || row.Meters != 150 || row.MetersPerSecond != 2.5 || row.KilometersPerHour != 9 {
+		t.Errorf("Baseline document read as %s", recordStr(row))
+	}
+
+	// Migrated in place, keeping the field names
+	for field, expected := range map[string]float64{"Meters": 150, "MetersPerSecond": 2.5, "KilometersPerHour": 9} {
+		if got := fake.field("hours", "2024-03-06 11", field); got == nil || got.GetDoubleValue() != expected {
+			t.Errorf("Migrated document has %s %v, expected %v", field, got, expected)
+		}
+	}
+	if got := fake.field("hours", "2024-03-06 11", "schemaVersion"); got == nil || got.GetIntegerValue() != currentSchemaVersion {
+		t.Errorf("Migrated document has schemaVersion %v", got)
+	}
+}
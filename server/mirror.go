@@ -0,0 +1,109 @@
+package server
+
+import (
+	"context"
+	"fmt"
+
+	"cloud.google.com/go/firestore"
+	"go.uber.org/zap"
+)
+
+// A batched write to the DB that's also replayed on the mirrors once the DB has it, to keep another project up to
+// date while migrating to it. Reads only ever go to the DB.
+type mirroredBatch struct {
+	server *Server
+	batch  *firestore.WriteBatch
+	writes []mirroredWrite
+}
+
+type mirroredWrite struct {
+	collection string
+	id         string
+	data       interface{}
+	opts       []firestore.SetOption
+	delete     bool
+}
+
+// Write everything also to the Firestore of the given project. Only batched writes to the records are mirrored,
+// backfill the mirror with an export and import before relying on it.
+func (s *Server) AddMirror(projectId string) {
+	s.mirrors = append(s.mirrors, s.newClient(context.Background(), projectId))
+}
+
+func (s *Server) closeMirrors() {
+	for _, mirror := range s.mirrors {
+		err := mirror.Close()
+		if err != nil {
+			logger.Warn("Error closing mirror Firestore client", zap.Error(err))
+		}
+	}
+	s.mirrors = nil
+}
+
+// By default failing to write to a mirror is only logged, in strict mode it's returned as a MirrorError too. The DB
+// has already been written to by then either way, the error is just not hidden.
+func (s *Server) SetStrictMirroring(enabled bool) {
+	s.strictMirroring = enabled
+}
+
+// Writing to a mirror failed after the DB was written to, so unlike a CommitError the records are saved
+type MirrorError struct {
+	Err error
+}
+
+func (e *MirrorError) Error() string {
+	return fmt.Sprintf("writing to mirror: %s", e.Err)
+}
+
+func (e *MirrorError) Unwrap() error {
+	return e.Err
+}
+
+func (s *Server) batch() *mirroredBatch {
+	return &mirroredBatch{server: s, batch: s.db.Batch()}
+}
+
+func (b *mirroredBatch) Set(ref *firestore.DocumentRef, data interface{}, opts ...firestore.SetOption) {
+	b.batch.Set(ref, data, opts...)
+	if len(b.server.mirrors) > 0 {
+		b.writes = append(b.writes, mirroredWrite{collection: ref.Parent.ID, id: ref.ID, data: data, opts: opts})
+	}
+}
+
+func (b *mirroredBatch) Delete(ref *firestore.DocumentRef) {
+	b.batch.Delete(ref)
+	if len(b.server.mirrors) > 0 {
+		b.writes = append(b.writes, mirroredWrite{collection: ref.Parent.ID, id: ref.ID, delete: true})
+	}
+}
+
+func (b *mirroredBatch) Commit(ctx context.Context) ([]*firestore.WriteResult, error) {
+	results, err := b.batch.Commit(ctx)
+	if err != nil {
+		return results, err
+	}
+
+	var mirrorErr error
+	for _, mirror := range b.server.mirrors {
+		batch := mirror.Batch()
+		for _, w := range b.writes {
+			ref := mirror.Collection(w.collection).Doc(w.id)
+			if w.delete {
+				batch.Delete(ref)
+			} else {
+				batch.Set(ref, w.data, w.opts...)
+			}
+		}
+
+		_, err := batch.Commit(ctx)
+		if err != nil {
+			logger.Warn("Failed to write to mirror", zap.Int("writes", len(b.writes)), zap.Error(err))
+			// The other mirrors are still worth keeping up to date
+			if b.server.strictMirroring && mirrorErr == nil {
+				mirrorErr = &MirrorError{Err: err}
+			}
+		}
+	}
+
+	return results, mirrorErr
+}
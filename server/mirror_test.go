@@ -0,0 +1,96 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+
+	"cloud.google.com/go/firestore"
+	"github.com/lietu/godometer"
+)
+
+// A server mirroring its writes to fake Firestores of the given projects
+func newMirroredTestServer(t *testing.T, projectIds ...string) (*Server, *fakeFirestore, map[string]*fakeFirestore) {
+	srv, fake, _ := newTestServer(t, testNow)
+
+	mirrors := map[string]*fakeFirestore{}
+	for _, projectId := range projectIds {
+		mirrors[projectId], _ = newFakeFirestore(t)
+	}
+	srv.newClient = func(ctx context.Context, projectId string) *firestore.Client {
+		return mirrors[projectId].newClient(t)
+	}
+	for _, projectId := range projectIds {
+		srv.AddMirror(projectId)
+	}
+
+	return srv, fake, mirrors
+}
+
+func TestMirroredWrites(t *testing.T) {
+	srv, fake, mirrors := newMirroredTestServer(t, "mirror-1", "mirror-2")
+
+	minute := testMinute(testNow)
+	if rec := postStats(t, srv, godometer.UpdateDataPoint{Timestamp: minute, Meters: 120, MetersPerSecond: 2, KilometersPerHour: 7.2}); rec.Code != http.StatusOK {
+		t.Fatalf("Update failed with %d: %s", rec.Code, rec.Body.String())
+	}
+
+	for name, db := range map[string]*fakeFirestore{"DB": fake, "mirror-1": mirrors["mirror-1"], "mirror-2": mirrors["mirror-2"]} {
+		for _, period := range periods {
+			if ids := db.ids(period); len(ids) != 1 {
+				t.Errorf("%s has %s %v, expected one", name, period, ids)
+			}
+		}
+		if got := db.field("hours", "2024-03-06 12", "Meters"); got == nil || got.GetDoubleValue() != 120 {
+			t.Errorf("%s has the hour at %v meters, expected 120", name, got)
+		}
+	}
+}
+
+func TestMirrorFailures(t *testing.T) {
+	for _, strict := range []bool{false, true} {
+		srv, fake, mirrors := newMirroredTestServer(t, "mirror-1", "mirror-2")
+		srv.SetStrictMirroring(strict)
+		srv.EnableCircuitBreaker(1)
+		minute := testMinute(testNow)
+		mirrors["mirror-1"].fail(minute)
+
+		err := srv.writeStats(context.Background(), []godometer.UpdateDataPoint{{Timestamp: minute, Meters: 120, MetersPerSecond: 2, KilometersPerHour: 7.2}})
+		var mirrorErr *MirrorError
+		if !strict && err != nil {
+			t.Errorf("Failing mirror failed the update when not strict: %s", err)
+		}
+		if strict && !errors.As(err, &mirrorErr) {
+			t.Errorf("Failing mirror returned %v when strict, expected a MirrorError", err)
+		}
+
+		// The DB and memory have it either way, and the DB didn't fail
+		if !fake.has("minutes", minute) {
+			t.Errorf("Strict %v: the DB is missing minute %s", strict, minute)
+		}
+		if row := srv.minutes[minute]; row.Meters != 120 || !row.Exists {
+			t.Errorf("Strict %v: minute %s in memory is %s, expected it saved", strict, minute, recordStr(row))
+		}
+		if srv.breaker.isOpen() {
+			t.Errorf("Strict %v: the mirror failing opened the circuit breaker", strict)
+		}
+		if mirrors["mirror-1"].has("minutes", minute) {
+			t.Errorf("Strict %v: the failing mirror has minute %s", strict, minute)
+		}
+		if !mirrors["mirror-2"].has("minutes", minute) {
+			t.Errorf("Strict %v: the mirror after the failing one is missing minute %s", strict, minute)
+		}
+
+		// The data point was counted, so sending it again doesn't add it twice
+		if strict {
+			rec := postStats(t, srv, godometer.UpdateDataPoint{Timestamp: minute, Meters: 120, MetersPerSecond: 2, KilometersPerHour: 7.2})
+			if rec.Code != http.StatusOK {
+				t.Errorf("Resend failed with %d: %s", rec.Code, rec.Body.String())
+			}
+			if row := srv.hours["2024-03-06 12"]; row.Meters != 120 || row.Counter != 1 {
+				t.Errorf("Hour is %s after the resend, expected it counted once", recordStr(row))
+			}
+		}
+	}
+}
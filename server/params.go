@@ -0,0 +1,93 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+// How many items a single request may ask for, larger limits are lowered to this
+const defaultMaxLimit = 1000
+
+// How long a ?from= ?to= range may be, even a range of whole years is a read per year
+const maxRangeYears = 100
+
+type ErrorResponse struct {
+	Error string `json:"error"`
+}
+
+// Caps ?limit= on the read endpoints
+func (s *Server) SetMaxLimit(max int) {
+	s.maxLimit = max
+}
+
+// Rejects the request with the error as the message, for invalid parameters
+func badRequest(c *gin.Context, err error) {
+	logger.Warn("Invalid request", zap.String("path", c.FullPath()), zap.Error(err))
+	_ = c.Error(err)
+	c.AbortWithStatusJSON(http.StatusBadRequest, ErrorResponse{Error: err.Error()})
+}
+
+// A positive integer, defaultValue when not given and lowered to max when over it
+func queryPositiveInt(c *gin.Context, name string, defaultValue int, max int) (int, error) {
+	value := c.Query(name)
+	if value == "" {
+		value = strconv.Itoa(defaultValue)
+	}
+
+	i, err := strconv.Atoi(value)
+	if err != nil || i < 1 {
+		return 0, fmt.Errorf("%s must be a positive integer, got %q", name, value)
+	}
+
+	if i > max {
+		i = max
+	}
+	return i, nil
+}
+
+func (s *Server) queryLimit(c *gin.Context, defaultLimit int) (int, error) {
+	max := s.maxLimit
+	if max <= 0 {
+		max = defaultMaxLimit
+	}
+	return queryPositiveInt(c, "limit", defaultLimit, max)
+}
+
+// ?order= as either asc or desc, asc by default
+func queryOrder(c *gin.Context) (string, error) {
+	order := strings.ToLower(strings.TrimSpace(c.DefaultQuery("order", "asc")))
+	if order != "asc" && order != "desc" {
+		return "", fmt.Errorf("order must be asc or desc, got %q", c.Query("order"))
+	}
+	return order, nil
+}
+
+// ?from= and ?to= as minutes or days, returns the start of from and the end of to. The range is capped to
+// maxRangeYears.
+func queryRange(c *gin.Context) (time.Time, time.Time, error) {
+	start, _, err := parseRangeTime(c.Query("from"))
+	if err != nil {
+		return time.Time{}, time.Time{}, fmt.Errorf("invalid from: %w", err)
+	}
+
+	_, end, err := parseRangeTime(c.Query("to"))
+	if err != nil {
+		return time.Time{}, time.Time{}, fmt.Errorf("invalid to: %w", err)
+	}
+
+	if !start.Before(end) {
+		return time.Time{}, time.Time{}, fmt.Errorf("from %q must not be after to %q", c.Query("from"), c.Query("to"))
+	}
+
+	if end.After(start.AddDate(maxRangeYears, 0, 0)) {
+		return time.Time{}, time.Time{}, fmt.Errorf("range from %q to %q is longer than %d years", c.Query("from"), c.Query("to"), maxRangeYears)
+	}
+
+	return start, end, nil
+}
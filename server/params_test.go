@@ -0,0 +1,78 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func errorMessage(t *testing.T, srv *Server, path string) string {
+	t.Helper()
+	rec := request(srv, http.MethodGet, path, nil, nil)
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("%s returned %d, expected 400", path, rec.Code)
+		return ""
+	}
+
+	response := ErrorResponse{}
+	if err := json.Unmarshal(rec.Body.Bytes(), &response); err != nil {
+		t.Fatalf("Failed to parse the error of %s: %s", path, err)
+	}
+	return response.Error
+}
+
+func TestInvalidParams(t *testing.T) {
+	srv, _, _ := newTestServer(t, testNow)
+
+	tests := []struct {
+		path    string
+		message string
+	}{
+		{"/api/v1/events?limit=-1", "limit must be a positive integer"},
+		{"/api/v1/events?limit=0", "limit must be a positive integer"},
+		{"/api/v1/events?limit=lots", "limit must be a positive integer"},
+		{"/api/v1/total?from=2024-03-02&to=2024-03-01", "must not be after"},
+		{"/api/v1/total?from=2024-03-06%2012:30&to=2024-03-06%2012:29", "must not be after"},
+		{"/api/v1/total?from=0001-01-01&to=9999-12-31", "longer than 100 years"},
+		{"/api/v1/total?from=yesterday&to=2024-03-01", "invalid from"},
+		{"/api/v1/stream/hours?order=sideways", "order must be asc or desc"},
+	}
+	for _, test := range tests {
+		if message := errorMessage(t, srv, test.path); !strings.Contains(message, test.message) {
+			t.Errorf("%s failed with %q, expected it to mention %q", test.path, message, test.message)
+		}
+	}
+}
+
+func TestLimitIsClamped(t *testing.T) {
+	srv, _, _ := newTestServer(t, testNow)
+	srv.SetMaxLimit(2)
+	for _, key := range []string{"2024-03-06 12:30", "2024-03-06 12:10", "2024-03-06 11:45"} {
+		srv.minutes[key] = DBDataPoint{Counter: 1, Meters: 50, MetersPerSecond: 1}
+	}
+
+	response := EventHistoryResponse{}
+	if rec := getJSON(t, srv, "/api/v1/events?limit=100000", &response); rec.Code != http.StatusOK {
+		t.Fatalf("Over the max limit failed with %d: %s", rec.Code, rec.Body.String())
+	}
+	if len(response.Events) != 2 {
+		t.Errorf("Got %d events, expected the limit to be lowered to 2", len(response.Events))
+	}
+}
+
+func TestRangeAndOrderAccepted(t *testing.T) {
+	srv, _, _ := newTestServer(t, testNow)
+
+	// A single minute, and exactly the longest range
+	for _, path := range []string{"/api/v1/total?from=2024-03-06%2012:30&to=2024-03-06%2012:30", "/api/v1/total?from=1925-01-01&to=2024-12-31"} {
+		if rec := getJSON(t, srv, path, nil); rec.Code != http.StatusOK {
+			t.Errorf("%s failed with %d: %s", path, rec.Code, rec.Body.String())
+		}
+	}
+
+	desc := streamLines(t, srv, "/api/v1/stream/hours?order=%20DESC")
+	if len(desc) != 24 || desc[0].Timestamp != "2024-03-06 12" {
+		t.Errorf("Order isn't normalized, stream starts from %v", eventTimestamps(desc))
+	}
+}
@@ -0,0 +1,174 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	defaultPatternDays = 28
+	maxPatternDays     = 366
+)
+
+// Average activity of one hour of the day (0-23) or day of the week (0 is Sunday)
+type PatternGroup struct {
+	Group int `json:"group"`
+	// Hours in the range that fall into the group, and how many of them had any data
+	Buckets int `json:"buckets"`
+	Active  int `json:"active"`
+	// Per hour over all of the group's hours, so the groups are comparable
+	Meters float32 `json:"m"`
+	// Averaged over the time spent moving
+	MetersPerSecond   float32 `json:"mps"`
+	KilometersPerHour float32 `json:"kph"`
+}
+
+type PatternResponse struct {
+	By       string         `json:"by"`
+	Timezone string         `json:"timezone"`
+	From     string         `json:"from"`
+	To       string         `json:"to"`
+	Groups   []PatternGroup `json:"groups"`
+}
+
+// Which timezone "morning" and "Monday" are in for the activity patterns, hours are stored in UTC regardless
+func (s *Server) SetDisplayLocation(loc *time.Location) {
+	s.displayLocation = loc
+}
+
+func (s *Server) displayLoc() *time.Location {
+	if s.displayLocation == nil {
+		return utc
+	}
+	return s.displayLocation
+}
+
+// Groups the hour records by local hour of day or day of week. An hour bucket goes to the group its start is in.
+func patternGroups(by string, hours map[string]DBDataPoint, loc *time.Location) []PatternGroup {
+	count := 24
+	if by == "weekday" {
+		count = 7
+	}
+
+	groups := make([]PatternGroup, count)
+	seconds := make([]float32, count)
+	for i := range groups {
+		groups[i].Group = i
+	}
+
+	for id, row := range hours {
+		ts, err := time.ParseInLocation(hourLayout, id, utc)
+		if err != nil {
+			continue
+		}
+
+		local := ts.In(loc)
+		group := local.Hour()
+		if by == "weekday" {
+			group = int(local.Weekday())
+		}
+
+		g := &groups[group]
+		g.Buckets += 1
+		if row.Counter <= 0 && row.Meters <= 0 {
+			continue
+		}
+
+		g.Active += 1
+		g.Meters += zeroNaN(row.Meters)
+		g.MetersPerSecond += zeroNaN(row.MetersPerSecond) * row.MovingSeconds
+		g.KilometersPerHour += zeroNaN(row.KilometersPerHour) * row.MovingSeconds
+		seconds[group] += row.MovingSeconds
+	}
+
+	for i := range groups {
+		g := &groups[i]
+		if g.Buckets > 0 {
+			g.Meters /= float32(g.Buckets)
+		}
+		if seconds[i] > 0 {
+			g.MetersPerSecond /= seconds[i]
+			g.KilometersPerHour /= seconds[i]
+		} else {
+			g.MetersPerSecond = 0
+			g.KilometersPerHour = 0
+		}
+	}
+
+	return groups
+}
+
+// The hours from the memory where possible, the rest from the DB
+func (s *Server) readHourRange(ctx context.Context, ids []string) (map[string]DBDataPoint, error) {
+	records := map[string]DBDataPoint{}
+	var missing []string
+
+	s.writeMutex.RLock()
+	for _, id := range ids {
+		if row, ok := s.hours[id]; ok {
+			records[id] = row
+		} else {
+			missing = append(missing, id)
+		}
+	}
+	s.writeMutex.RUnlock()
+
+	if len(missing) > 0 {
+		stored, err := s.readRecords(ctx, collectionName("hours"), missing)
+		if err != nil {
+			return nil, err
+		}
+		for id, row := range stored {
+			records[id] = row
+		}
+	}
+
+	return records, nil
+}
+
+// Typical activity by ?by=hour or ?by=weekday over the last ?days=
+func (s *Server) returnPatterns(c *gin.Context) {
+	by := c.DefaultQuery("by", "hour")
+	if by != "hour" && by != "weekday" {
+		badRequest(c, fmt.Errorf("by must be hour or weekday, got %q", by))
+		return
+	}
+
+	days, err := queryPositiveInt(c, "days", defaultPatternDays, maxPatternDays)
+	if err != nil {
+		badRequest(c, err)
+		return
+	}
+
+	end := s.clock.Now().In(utc).Truncate(time.Hour)
+	var ids []string
+	for i := days*24 - 1; i >= 0; i-- {
+		ids = append(ids, end.Add(-time.Duration(i)*time.Hour).Format(hourLayout))
+	}
+
+	hours, err := s.readHourRange(c.Request.Context(), ids)
+	if err != nil {
+		_ = c.AbortWithError(errorStatus(err), err)
+		return
+	}
+
+	groups := patternGroups(by, hours, s.displayLoc())
+	if s.precision != nil {
+		for i := range groups {
+			groups[i].Meters = float32(roundTo(groups[i].Meters, s.precision.meters))
+			groups[i].MetersPerSecond = float32(roundTo(groups[i].MetersPerSecond, s.precision.speed))
+			groups[i].KilometersPerHour = float32(roundTo(groups[i].KilometersPerHour, s.precision.speed))
+		}
+	}
+
+	c.JSON(200, PatternResponse{
+		By:       by,
+		Timezone: s.displayLoc().String(),
+		From:     ids[0],
+		To:       ids[len(ids)-1],
+		Groups:   groups,
+	})
+}
@@ -0,0 +1,108 @@
+package server
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+// Hours at 06 UTC on three days, and one late Monday evening UTC that's already Tuesday further east
+func seedPatternHours(t *testing.T, srv *Server) {
+	t.Helper()
+	hours := map[string]DBDataPoint{
+		"2024-03-04 06": {Counter: 1, Meters: 100, MetersPerSecond: 2, MovingSeconds: 50},
+		"2024-03-05 06": {Counter: 1, Meters: 200, MetersPerSecond: 2, MovingSeconds: 100},
+		"2024-03-06 06": {Counter: 1, Meters: 300, MetersPerSecond: 4, MovingSeconds: 75},
+		"2024-03-04 23": {Counter: 1, Meters: 60, MetersPerSecond: 1, MovingSeconds: 60},
+	}
+	for id, row := range hours {
+		// The last 24 hours come from memory, the rest from the DB
+		if _, ok := srv.hours[id]; ok {
+			srv.hours[id] = row
+		} else {
+			seedRecord(t, srv, "hours", id, row.withIntegers())
+		}
+	}
+}
+
+func getPatterns(t *testing.T, srv *Server, path string) PatternResponse {
+	t.Helper()
+	response := PatternResponse{}
+	if rec := getJSON(t, srv, path, &response); rec.Code != http.StatusOK {
+		t.Fatalf("%s failed with %d: %s", path, rec.Code, rec.Body.String())
+	}
+	return response
+}
+
+func TestPatternsByHour(t *testing.T) {
+	for _, loc := range []*time.Location{utc, time.FixedZone("EET", 2*60*60)} {
+		srv, _, _ := newTestServer(t, testNow)
+		srv.SetDisplayLocation(loc)
+		seedPatternHours(t, srv)
+
+		response := getPatterns(t, srv, "/api/v1/patterns?by=hour&days=3")
+		if response.Timezone != loc.String() || response.From != "2024-03-03 13" || response.To != "2024-03-06 12" {
+			t.Errorf("Patterns are in %s from %s to %s", response.Timezone, response.From, response.To)
+		}
+		if len(response.Groups) != 24 {
+			t.Fatalf("Got %d groups, expected 24", len(response.Groups))
+		}
+
+		// 06 UTC is 08 in EET, and 23 UTC is 01 the next day
+		morning, night, other := 6, 23, 8
+		if loc != utc {
+			morning, night, other = 8, 1, 6
+		}
+
+		g := response.Groups[morning]
+		if g.Group != morning || g.Buckets != 3 || g.Active != 3 {
+			t.Errorf("%s: hour %d is %+v, expected 3 active buckets", loc, morning, g)
+		}
+		// Per hour over all three, and the speed over the 225 seconds spent moving
+		if !closeTo(g.Meters, 200) || !closeTo(g.MetersPerSecond, 600.0/225) {
+			t.Errorf("%s: hour %d averages %vm @ %vm/s, expected 200m @ %vm/s", loc, morning, g.Meters, g.MetersPerSecond, 600.0/225)
+		}
+
+		g = response.Groups[night]
+		if g.Active != 1 || !closeTo(g.Meters, 20) || !closeTo(g.MetersPerSecond, 1) {
+			t.Errorf("%s: hour %d is %+v, expected 20m @ 1m/s", loc, night, g)
+		}
+
+		g = response.Groups[other]
+		if g.Buckets != 3 || g.Active != 0 || g.Meters != 0 || g.MetersPerSecond != 0 {
+			t.Errorf("%s: hour %d is %+v, expected nothing", loc, other, g)
+		}
+	}
+}
+
+func TestPatternsByWeekday(t *testing.T) {
+	srv, _, _ := newTestServer(t, testNow)
+	srv.SetDisplayLocation(time.FixedZone("EET", 2*60*60))
+	seedPatternHours(t, srv)
+
+	response := getPatterns(t, srv, "/api/v1/patterns?by=weekday&days=3")
+	if len(response.Groups) != 7 {
+		t.Fatalf("Got %d groups, expected 7", len(response.Groups))
+	}
+
+	// Local time goes from Sunday 15:00 to Wednesday 14:00
+	expected := []struct {
+		weekday time.Weekday
+		buckets int
+		active  int
+		meters  float32
+	}{
+		{time.Sunday, 9, 0, 0},
+		{time.Monday, 24, 1, 100.0 / 24},
+		// Late Monday UTC is early Tuesday
+		{time.Tuesday, 24, 2, 260.0 / 24},
+		{time.Wednesday, 15, 1, 300.0 / 15},
+		{time.Thursday, 0, 0, 0},
+	}
+	for _, e := range expected {
+		g := response.Groups[e.weekday]
+		if g.Buckets != e.buckets || g.Active != e.active || !closeTo(g.Meters, e.meters) {
+			t.Errorf("%s is %+v, expected %d buckets, %d active, %vm per hour", e.weekday, g, e.buckets, e.active, e.meters)
+		}
+	}
+}
@@ -0,0 +1,55 @@
+package server
+
+import (
+	"math"
+	"strconv"
+)
+
+// How many decimals to round distance and speed to in responses, -1 to leave as is
+type precision struct {
+	meters int
+	speed  int
+}
+
+// Rounds half to even, so rounding many values doesn't push the total one way. Responses convert the result back
+// to a float32, which JSON encodes as its shortest decimal.
+func roundTo(value float32, decimals int) float64 {
+	if decimals < 0 {
+		return float64(value)
+	}
+
+	scale := math.Pow(10, float64(decimals))
+	// From the decimal the float32 stands for, as float64(value) carries its noise, e.g. 12.345 is 12.345000267
+	exact, _ := strconv.ParseFloat(strconv.FormatFloat(float64(value), 'g', -1, 32), 64)
+	return math.RoundToEven(exact*scale) / scale
+}
+
+// Round reported values to the given decimals. Records in memory and in the DB keep full precision, so rounding
+// doesn't add up over updates or change the totals after a restart. Use -1 to not round one of them.
+func (s *Server) SetPrecision(metersDecimals int, speedDecimals int) {
+	if metersDecimals < 0 && speedDecimals < 0 {
+		s.precision = nil
+		return
+	}
+	s.precision = &precision{meters: metersDecimals, speed: speedDecimals}
+}
+
+func (s *Server) roundResponse(rdp ResponseDataPoint) ResponseDataPoint {
+	if s.precision == nil {
+		return rdp
+	}
+
+	rdp.Meters = float32(roundTo(rdp.Meters, s.precision.meters))
+	rdp.MetersPerSecond = float32(roundTo(rdp.MetersPerSecond, s.precision.speed))
+	rdp.KilometersPerHour = float32(roundTo(rdp.KilometersPerHour, s.precision.speed))
+	if rdp.PaceMinPerKm != nil {
+		pace := float32(roundTo(*rdp.PaceMinPerKm, s.precision.speed))
+		rdp.PaceMinPerKm = &pace
+	}
+	if rdp.Derived != nil {
+		derived := *rdp.Derived
+		derived.Value = float32(roundTo(derived.Value, s.precision.meters))
+		rdp.Derived = &derived
+	}
+	return rdp
+}
@@ -0,0 +1,76 @@
+package server
+
+import (
+	"context"
+	"testing"
+
+	"github.com/lietu/godometer"
+)
+
+func TestRoundTo(t *testing.T) {
+	cases := []struct {
+		value    float32
+		decimals int
+		expected float64
+	}{
+		{12.34, 2, 12.34},
+		{12.345, 2, 12.34},
+		{12.355, 2, 12.36},
+		{2.5, 0, 2},
+		{3.5, 0, 4},
+		{-1.25, 1, -1.2},
+	}
+	for _, c := range cases {
+		if got := roundTo(c.value, c.decimals); got != c.expected {
+			t.Errorf("%v to %d decimals is %v, expected %v", c.value, c.decimals, got, c.expected)
+		}
+	}
+}
+
+func TestPrecisionOnlyInResponses(t *testing.T) {
+	srv, fake, _ := newTestServer(t, testNow)
+	srv.SetPrecision(2, 1)
+
+	var dataPoints []godometer.UpdateDataPoint
+	for _, minute := range []string{"2024-03-06 12:10", "2024-03-06 12:11", "2024-03-06 12:12"} {
+		dataPoints = append(dataPoints, godometer.UpdateDataPoint{Timestamp: minute, Meters: 12.3456, MetersPerSecond: 2.3456, KilometersPerHour: 8.44416})
+	}
+	if err := srv.writeStats(context.Background(), dataPoints); err != nil {
+		t.Fatalf("writeStats failed: %s", err)
+	}
+
+	// Accumulated and stored at full precision, 3 * 12.346
+	if hour := srv.hours["2024-03-06 12"]; hour.Millimeters != 37038 || !closeTo(hour.Meters, 37.038) {
+		t.Errorf("Hour in memory is %s (%dmm), expected 37.038m", recordStr(hour), hour.Millimeters)
+	}
+	if field := fake.field("hours", "2024-03-06 12", "Meters"); field == nil || float32(field.GetDoubleValue()) != srv.hours["2024-03-06 12"].Meters {
+		t.Errorf("Stored Meters is %v, expected the unrounded %v", field, srv.hours["2024-03-06 12"].Meters)
+	}
+
+	reportedHour := func() ResponseDataPoint {
+		response := StatsResponse{}
+		getJSON(t, srv, "/api/v1/stats/hours", &response)
+		return response.DataPoints[len(response.DataPoints)-1]
+	}
+	if latest := reportedHour(); latest.Meters != 37.04 || latest.MetersPerSecond != 2.3 || latest.KilometersPerHour != 8.4 {
+		t.Errorf("Reported hour is %+v, expected 37.04m @ 2.3m/s or 8.4km/h", latest)
+	}
+
+	// A restart reads back what was in memory, so adding to it gives the same totals as without one
+	if err := srv.loadData(context.Background()); err != nil {
+		t.Fatalf("loadData failed: %s", err)
+	}
+	if hour := srv.hours["2024-03-06 12"]; hour.Millimeters != 37038 || hour.Counter != 3 {
+		t.Errorf("Hour after a restart is %s (%dmm), expected 37.038m", recordStr(hour), hour.Millimeters)
+	}
+	next := godometer.UpdateDataPoint{Timestamp: "2024-03-06 12:13", Meters: 12.3456, MetersPerSecond: 2.3456, KilometersPerHour: 8.44416}
+	if err := srv.writeStats(context.Background(), []godometer.UpdateDataPoint{next}); err != nil {
+		t.Fatalf("writeStats failed: %s", err)
+	}
+	if hour := srv.hours["2024-03-06 12"]; hour.Millimeters != 49384 {
+		t.Errorf("Hour is %s (%dmm), expected 49.384m", recordStr(hour), hour.Millimeters)
+	}
+	if latest := reportedHour(); latest.Meters != 49.38 {
+		t.Errorf("Reported hour is %+v, expected 49.38m", latest)
+	}
+}
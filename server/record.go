@@ -0,0 +1,60 @@
+package server
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/gin-gonic/gin"
+)
+
+// A record in memory is real if it's in the DB or has data that is, the rest of the window is just zero filled
+func (s *Server) memoryRecord(period string, id string) (DBDataPoint, bool) {
+	s.writeMutex.RLock()
+	defer s.writeMutex.RUnlock()
+
+	available, _ := s.periodRecords(period)
+	row, ok := available[id]
+	if !ok || !(row.Exists || row.Counter > 0 || row.Meters != 0) {
+		return DBDataPoint{}, false
+	}
+	return row, true
+}
+
+// Looks the record up from memory, falling back to the DB. Fails with ErrNotFound when neither has it.
+func (s *Server) findRecord(ctx context.Context, period string, id string) (DBDataPoint, error) {
+	if row, ok := s.memoryRecord(period, id); ok {
+		return row, nil
+	}
+
+	records, err := s.readRecords(ctx, collectionName(period), []string{id})
+	if err != nil {
+		return DBDataPoint{}, err
+	}
+
+	row := records[id]
+	if !row.Exists {
+		return DBDataPoint{}, fmt.Errorf("%w: %s record %q", ErrNotFound, period, id)
+	}
+	return row, nil
+}
+
+func (s *Server) returnRecord(c *gin.Context) {
+	period := c.Param("period")
+	id := c.Param("id")
+	if err := validRecordIds(period, id); err != nil {
+		badRequest(c, err)
+		return
+	}
+
+	row, err := s.findRecord(c.Request.Context(), period, id)
+	if err != nil {
+		_ = c.AbortWithError(errorStatus(err), err)
+		return
+	}
+
+	row.Meters = zeroNaN(row.Meters)
+	row.MetersPerSecond = zeroNaN(row.MetersPerSecond)
+	row.KilometersPerHour = zeroNaN(row.KilometersPerHour)
+
+	c.JSON(200, s.formatResponse(row.toResponseDataPoint(id)))
+}
@@ -0,0 +1,76 @@
+package server
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+
+	"github.com/lietu/godometer"
+)
+
+func recordPath(period string, id string) string {
+	return "/api/v1/record/" + period + "/" + url.PathEscape(id)
+}
+
+func TestRecordFromMemory(t *testing.T) {
+	srv, fake, _ := newTestServer(t, testNow)
+	minute := testMinute(testNow)
+	if rec := postStats(t, srv, godometer.UpdateDataPoint{Timestamp: minute, Meters: 120, MetersPerSecond: 2, KilometersPerHour: 7.2}); rec.Code != http.StatusOK {
+		t.Fatalf("Update failed with %d: %s", rec.Code, rec.Body.String())
+	}
+
+	// Records in memory don't need the DB
+	fake.setUnavailable(true)
+
+	response := ResponseDataPoint{}
+	if rec := getJSON(t, srv, recordPath("minutes", minute), &response); rec.Code != http.StatusOK {
+		t.Fatalf("Request failed with %d: %s", rec.Code, rec.Body.String())
+	}
+	if response.Timestamp != minute || response.Counter != 1 || response.Meters != 120 {
+		t.Errorf("Minute is %+v, expected 120 meters at %s", response, minute)
+	}
+}
+
+func TestRecordFromDB(t *testing.T) {
+	srv, _, _ := newTestServer(t, testNow)
+	// Long out of the window in memory
+	seedRecord(t, srv, "days", "2024-01-15", DBDataPoint{Counter: 4, Meters: 400, MetersPerSecond: 2, KilometersPerHour: 7.2}.withIntegers())
+	// Stored zeroes are still a record
+	seedRecord(t, srv, "days", "2024-01-16", DBDataPoint{}.withIntegers())
+
+	response := ResponseDataPoint{}
+	if rec := getJSON(t, srv, recordPath("days", "2024-01-15"), &response); rec.Code != http.StatusOK {
+		t.Fatalf("Request failed with %d: %s", rec.Code, rec.Body.String())
+	}
+	if response.Timestamp != "2024-01-15" || response.Counter != 4 || response.Meters != 400 {
+		t.Errorf("Day is %+v, expected 400 meters on 2024-01-15", response)
+	}
+
+	response = ResponseDataPoint{}
+	if rec := getJSON(t, srv, recordPath("days", "2024-01-16"), &response); rec.Code != http.StatusOK {
+		t.Fatalf("Request for a stored zero failed with %d: %s", rec.Code, rec.Body.String())
+	}
+	if response.Counter != 0 || response.Meters != 0 {
+		t.Errorf("Stored zero day is %+v", response)
+	}
+}
+
+func TestRecordMissing(t *testing.T) {
+	srv, _, _ := newTestServer(t, testNow)
+
+	paths := []string{
+		// Not anywhere
+		recordPath("days", "2024-01-17"),
+		// In the window in memory, but only zero filled
+		recordPath("hours", "2024-03-06 09"),
+	}
+	for _, path := range paths {
+		if rec := request(srv, http.MethodGet, path, nil, nil); rec.Code != http.StatusNotFound {
+			t.Errorf("%s returned %d, expected 404", path, rec.Code)
+		}
+	}
+
+	if rec := request(srv, http.MethodGet, recordPath("days", "2024-03-06 09"), nil, nil); rec.Code != http.StatusBadRequest {
+		t.Errorf("Hour key for a day returned %d, expected 400", rec.Code)
+	}
+}
@@ -0,0 +1,70 @@
+package server
+
+import (
+	"context"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+type ReloadResponse struct {
+	Reloaded bool `json:"reloaded"`
+}
+
+// Reload the records from the DB on this interval, to pick up changes made elsewhere
+func (s *Server) EnableRefresh(interval time.Duration) {
+	if interval <= 0 {
+		logger.Warn("Ignoring invalid refresh interval", zap.Duration("interval", interval))
+		return
+	}
+	s.refreshInterval = interval
+}
+
+// Replaces the records in memory with the ones in the DB. If reading fails the records are kept as they were, as
+// zeroed out records would get written over the ones in the DB. Buffered updates aren't in the records yet, so
+// they'll be applied on top of the reloaded ones when flushed.
+func (s *Server) reload(ctx context.Context) error {
+	s.writeMutex.Lock()
+	defer s.writeMutex.Unlock()
+
+	years, months, weeks, days, hours, minutes := s.years, s.months, s.weeks, s.days, s.hours, s.minutes
+	lastEvents := s.lastEvents
+
+	err := s.loadData(ctx)
+	if err != nil {
+		s.years, s.months, s.weeks, s.days, s.hours, s.minutes = years, months, weeks, days, hours, minutes
+		s.lastEvents = lastEvents
+		return err
+	}
+
+	logger.Info("Reloaded records")
+	return nil
+}
+
+func (s *Server) reloadStats(c *gin.Context) {
+	err := s.reload(c.Request.Context())
+	if err != nil {
+		logger.Warn("Failed to reload stats", zap.Error(err))
+		_ = c.AbortWithError(errorStatus(err), err)
+		return
+	}
+
+	c.JSON(200, ReloadResponse{Reloaded: true})
+}
+
+func (s *Server) runRefresh(ctx context.Context) {
+	ticker := time.NewTicker(s.refreshInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			err := s.reload(ctx)
+			if err != nil {
+				logger.Warn("Failed to refresh stats", zap.Error(err))
+			}
+		}
+	}
+}
@@ -0,0 +1,55 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/lietu/godometer"
+)
+
+func TestReloadPicksUpStorageChanges(t *testing.T) {
+	srv, fake, _ := newTestServer(t, testNow)
+	srv.EnableAdmin(testAdminAuth)
+	srv.EnableBuffering(time.Hour, 0)
+
+	// Still in the buffer when reloading
+	rec := postStats(t, srv, godometer.UpdateDataPoint{Timestamp: testMinute(testNow), Meters: 100, MetersPerSecond: 2, KilometersPerHour: 7.2})
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Update failed with %d", rec.Code)
+	}
+
+	// Changed underneath, e.g. by a repair job
+	seedRecord(t, srv, "hours", "2024-03-06 11", DBDataPoint{Counter: 5, Meters: 500, MetersPerSecond: 2}.withIntegers())
+	seedRecord(t, srv, "days", "2024-03-06", DBDataPoint{Counter: 5, Meters: 500, MetersPerSecond: 2}.withIntegers())
+
+	if status := request(srv, http.MethodPost, "/api/v1/admin/reload", nil, nil).Code; status != http.StatusForbidden {
+		t.Errorf("Reload without admin auth returned %d, expected 403", status)
+	}
+	if status := adminRequest(srv, http.MethodPost, "/api/v1/admin/reload", nil); status != http.StatusOK {
+		t.Fatalf("Reload failed with %d", status)
+	}
+	if row := srv.hours["2024-03-06 11"]; row.Meters != 500 || row.Counter != 5 {
+		t.Errorf("Hour is %s after reloading, expected the 500m from storage", recordStr(row))
+	}
+
+	if err := srv.flushBuffer(context.Background()); err != nil {
+		t.Fatalf("Flushing failed: %s", err)
+	}
+	if row := srv.days["2024-03-06"]; row.Meters != 600 {
+		t.Errorf("Day is %s, expected the buffered 100m on top of the reloaded 500m", recordStr(row))
+	}
+	if row := srv.hours["2024-03-06 12"]; row.Meters != 100 {
+		t.Errorf("Hour is %s, expected the buffered 100m", recordStr(row))
+	}
+
+	// Failing to read keeps what's in memory
+	fake.setUnavailable(true)
+	if status := adminRequest(srv, http.MethodPost, "/api/v1/admin/reload", nil); status != http.StatusServiceUnavailable {
+		t.Errorf("Reload with storage down returned %d, expected 503", status)
+	}
+	if row := srv.days["2024-03-06"]; row.Meters != 600 {
+		t.Errorf("Day is %s after a failed reload, expected it kept", recordStr(row))
+	}
+}
@@ -0,0 +1,103 @@
+package server
+
+import (
+	"context"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+	"google.golang.org/api/iterator"
+)
+
+// Everything the dashboard shows, so one request is enough to render it
+type SnapshotResponse struct {
+	Periods map[string][]ResponseDataPoint `json:"periods"`
+	Events  []ResponseDataPoint            `json:"events"`
+	// Over all the years on record
+	Total    TotalResponse `json:"total"`
+	ReadOnly bool          `json:"readOnly"`
+}
+
+// All the year records in the DB, the collection only grows by one a year so reading it whole is fine
+func (s *Server) readAllYears(ctx context.Context) (map[string]DBDataPoint, error) {
+	iter := s.db.Collection(collectionName("years")).Documents(ctx)
+	defer iter.Stop()
+
+	records := map[string]DBDataPoint{}
+	for {
+		doc, err := iter.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			logger.Warn("Error fetching years from DB", zap.Error(err))
+			return nil, storageError(err)
+		}
+
+		row, err := decodeDataPoint(doc)
+		if err != nil {
+			logger.Warn("Failed to read data from DB to record. This is probably not great.", zap.Error(err))
+			continue
+		}
+		records[doc.Ref.ID] = migrateDataPoint(row)
+	}
+
+	return records, nil
+}
+
+// Reads all the periods and events under one lock, so they agree with each other
+func (s *Server) snapshot(storedYears map[string]DBDataPoint) SnapshotResponse {
+	s.writeMutex.RLock()
+	defer s.writeMutex.RUnlock()
+
+	response := SnapshotResponse{
+		Periods:  map[string][]ResponseDataPoint{},
+		Events:   []ResponseDataPoint{},
+		ReadOnly: s.breaker.isOpen(),
+	}
+
+	for _, period := range periods {
+		events, _ := s.periodDataPoints(period)
+		response.Periods[period] = s.formatResponses(events)
+	}
+
+	for _, e := range s.lastEvents {
+		response.Events = append(response.Events, s.formatResponse(e.withPace()))
+	}
+
+	// The years in memory may be newer than what the DB had when it was read
+	years := map[string]DBDataPoint{}
+	for id, row := range storedYears {
+		years[id] = row
+	}
+	for id, row := range s.years {
+		years[id] = row
+	}
+
+	var rows []DBDataPoint
+	from := ""
+	to := ""
+	for id, row := range years {
+		rows = append(rows, row)
+		if from == "" || id < from {
+			from = id
+		}
+		if id > to {
+			to = id
+		}
+	}
+	response.Total = s.totalOf(rows)
+	response.Total.From = from
+	response.Total.To = to
+
+	return response
+}
+
+func (s *Server) returnSnapshot(c *gin.Context) {
+	storedYears, err := s.readAllYears(c.Request.Context())
+	if err != nil {
+		_ = c.AbortWithError(errorStatus(err), err)
+		return
+	}
+
+	c.JSON(200, s.snapshot(storedYears))
+}
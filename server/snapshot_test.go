@@ -0,0 +1,66 @@
+package server
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/lietu/godometer"
+)
+
+func TestSnapshot(t *testing.T) {
+	srv, _, _ := newTestServer(t, testNow)
+
+	minute := testMinute(testNow)
+	if rec := postStats(t, srv, godometer.UpdateDataPoint{Timestamp: minute, Meters: 120, MetersPerSecond: 2, KilometersPerHour: 7.2}); rec.Code != http.StatusOK {
+		t.Fatalf("Update failed with %d: %s", rec.Code, rec.Body.String())
+	}
+	// Out of the window in memory, but part of the total
+	seedRecord(t, srv, "years", "2019", DBDataPoint{Counter: 10, Meters: 1000, MetersPerSecond: 2, KilometersPerHour: 7.2}.withIntegers())
+
+	rec := request(srv, http.MethodGet, "/api/v1/snapshot", nil, map[string]string{"Accept-Encoding": "gzip"})
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Snapshot failed with %d: %s", rec.Code, rec.Body.String())
+	}
+	if encoding := rec.Header().Get("Content-Encoding"); encoding != "gzip" {
+		t.Fatalf("Snapshot isn't gzipped, Content-Encoding is %q", encoding)
+	}
+
+	reader, err := gzip.NewReader(rec.Body)
+	if err != nil {
+		t.Fatalf("Failed to read gzip: %s", err)
+	}
+	response := SnapshotResponse{}
+	if err := json.NewDecoder(reader).Decode(&response); err != nil {
+		t.Fatalf("Failed to parse snapshot: %s", err)
+	}
+
+	counts := map[string]int{"years": 4, "months": 12, "weeks": 5, "days": 7, "hours": 24, "minutes": 60}
+	for period, count := range counts {
+		if got := len(response.Periods[period]); got != count {
+			t.Errorf("Snapshot has %d %s, expected %d", got, period, count)
+		}
+	}
+
+	minutes := response.Periods["minutes"]
+	latest := minutes[len(minutes)-1]
+	if latest.Timestamp != minute || latest.Meters != 120 {
+		t.Errorf("Latest minute is %s with %v meters, expected %s with 120", latest.Timestamp, latest.Meters, minute)
+	}
+	if len(response.Events) != 1 || response.Events[0].Timestamp != latest.Timestamp || response.Events[0].Meters != latest.Meters {
+		t.Errorf("Events %v don't match the latest minute %+v", eventTimestamps(response.Events), latest)
+	}
+
+	// Every period has the update in its latest bucket
+	for period := range counts {
+		dataPoints := response.Periods[period]
+		if got := dataPoints[len(dataPoints)-1].Meters; got != 120 {
+			t.Errorf("Latest of %s has %v meters, expected 120", period, got)
+		}
+	}
+
+	if response.Total.Meters != 1120 || response.Total.From != "2019" || response.Total.To != "2024" {
+		t.Errorf("Total is %v meters from %s to %s, expected 1120 from 2019 to 2024", response.Total.Meters, response.Total.From, response.Total.To)
+	}
+}
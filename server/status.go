@@ -0,0 +1,65 @@
+package server
+
+import (
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Set at build time with -ldflags "-X github.com/lietu/godometer/server.Version=..."
+var Version = "dev"
+
+type StatusResponse struct {
+	Version       string  `json:"version"`
+	UptimeSeconds float64 `json:"uptimeSeconds"`
+	// Timestamp of the newest processed event, empty if there are none
+	LatestEvent string `json:"latestEvent"`
+	// Newest minute in memory with data in it, empty if there are none
+	LatestMinute string `json:"latestMinute"`
+	// How far behind now the latest event is, null without events
+	LagSeconds *float64 `json:"lagSeconds"`
+}
+
+// Newest minute with data, the keys themselves exist for the whole window
+func latestActiveKey(records map[string]DBDataPoint) string {
+	latest := ""
+	for key, row := range records {
+		if row.Counter > 0 && key > latest {
+			latest = key
+		}
+	}
+	return latest
+}
+
+// Which version is running and how fresh the data is, to spot stalled ingest
+func (s *Server) status() StatusResponse {
+	s.writeMutex.RLock()
+	defer s.writeMutex.RUnlock()
+
+	now := s.clock.Now()
+	status := StatusResponse{
+		Version:       Version,
+		UptimeSeconds: now.Sub(s.startedAt).Seconds(),
+		LatestMinute:  latestActiveKey(s.minutes),
+	}
+
+	for _, e := range s.lastEvents {
+		if e.Timestamp > status.LatestEvent {
+			status.LatestEvent = e.Timestamp
+		}
+	}
+
+	if status.LatestEvent != "" {
+		ts, err := time.Parse(minuteLayout, status.LatestEvent)
+		if err == nil {
+			lag := now.Sub(ts).Seconds()
+			status.LagSeconds = &lag
+		}
+	}
+
+	return status
+}
+
+func (s *Server) returnStatus(c *gin.Context) {
+	c.JSON(200, s.status())
+}
@@ -0,0 +1,54 @@
+package server
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/lietu/godometer"
+)
+
+func TestStatusLatestEvent(t *testing.T) {
+	srv, _, clock := newTestServer(t, testNow)
+
+	response := StatusResponse{}
+	if rec := getJSON(t, srv, "/api/v1/status", &response); rec.Code != http.StatusOK {
+		t.Fatalf("Status failed with %d: %s", rec.Code, rec.Body.String())
+	}
+	if response.LatestEvent != "" || response.LagSeconds != nil {
+		t.Errorf("Status without events reports latest event %q, lag %v", response.LatestEvent, response.LagSeconds)
+	}
+
+	latest := testMinute(testNow.Add(-2 * time.Minute))
+	// The most recent one is processed first
+	for _, ts := range []time.Time{testNow.Add(-2 * time.Minute), testNow.Add(-5 * time.Minute)} {
+		if rec := postStats(t, srv, godometer.UpdateDataPoint{Timestamp: testMinute(ts), Meters: 100, MetersPerSecond: 2, KilometersPerHour: 7.2}); rec.Code != http.StatusOK {
+			t.Fatalf("Update failed with %d: %s", rec.Code, rec.Body.String())
+		}
+	}
+	clock.Add(time.Minute)
+
+	response = StatusResponse{}
+	if rec := getJSON(t, srv, "/api/v1/status", &response); rec.Code != http.StatusOK {
+		t.Fatalf("Status failed with %d: %s", rec.Code, rec.Body.String())
+	}
+
+	if response.LatestEvent != latest {
+		t.Errorf("Latest event is %q, expected %q", response.LatestEvent, latest)
+	}
+	if response.LatestMinute != latest {
+		t.Errorf("Latest minute is %q, expected %q", response.LatestMinute, latest)
+	}
+
+	latestTime, _ := time.Parse(minuteLayout, latest)
+	expectedLag := clock.Now().Sub(latestTime).Seconds()
+	if response.LagSeconds == nil || *response.LagSeconds != expectedLag {
+		t.Errorf("Lag is %v, expected %v", response.LagSeconds, expectedLag)
+	}
+	if response.UptimeSeconds != 60 {
+		t.Errorf("Uptime is %v, expected 60", response.UptimeSeconds)
+	}
+	if response.Version != Version {
+		t.Errorf("Version is %q, expected %q", response.Version, Version)
+	}
+}
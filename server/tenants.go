@@ -0,0 +1,246 @@
+package server
+
+import (
+	"context"
+	"log"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// Requests to Host use the Firestore project ProjectId, and writes need ApiAuth. Writes are also mirrored to the
+// projects in MirrorProjectIds, if any.
+type Tenant struct {
+	Host             string   `json:"host"`
+	ProjectId        string   `json:"projectId"`
+	ApiAuth          string   `json:"apiAuth"`
+	MirrorProjectIds []string `json:"mirrorProjectIds,omitempty"`
+}
+
+// Serves several projects from one process. Each project gets its own Server, with its own Firestore client and
+// records in memory, picked by the Host of the request. Only maxServers are kept around, to make room the least
+// recently used idle one is shut down and its client closed. It'll load its data again when next needed.
+type MultiServer struct {
+	dev        bool
+	fakeData   bool
+	tenants    map[string]Tenant
+	maxServers int
+	configure  func(*Server)
+	newServer  func(Tenant) *Server
+	clock      Clock
+	mutex      sync.Mutex
+	servers    map[string]*tenantServer
+	// Closed once the evicted server of the project has saved everything and shut down
+	closing    map[string]chan bool
+	httpServer *http.Server
+}
+
+type tenantServer struct {
+	srv *Server
+	// Closed once srv has loaded its data and can be used
+	ready    chan bool
+	active   int
+	lastUsed time.Time
+}
+
+// Configure is called for each new Server, to apply the same settings to all of them. Mirrors are per tenant, so
+// they're added from the tenant instead.
+func NewMultiServer(dev bool, tenants []Tenant, maxServers int, configure func(*Server)) *MultiServer {
+	ms := &MultiServer{
+		dev:        dev,
+		tenants:    map[string]Tenant{},
+		maxServers: maxServers,
+		configure:  configure,
+		clock:      RealClock{},
+		servers:    map[string]*tenantServer{},
+		closing:    map[string]chan bool{},
+	}
+	ms.newServer = func(tenant Tenant) *Server {
+		return NewServer(ms.dev, tenant.ProjectId, tenant.ApiAuth)
+	}
+
+	if ms.maxServers < 1 {
+		ms.maxServers = 1
+	}
+
+	for _, t := range tenants {
+		ms.tenants[t.Host] = t
+	}
+
+	return ms
+}
+
+// Used to tell which tenant server was used least recently
+func (ms *MultiServer) SetClock(clock Clock) {
+	ms.clock = clock
+}
+
+func (ms *MultiServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	host, _, err := net.SplitHostPort(r.Host)
+	if err != nil {
+		host = r.Host
+	}
+
+	tenant, ok := ms.tenants[host]
+	if !ok {
+		logger.Warn("Unknown tenant", zap.String("host", host))
+		http.NotFound(w, r)
+		return
+	}
+
+	ts := ms.acquire(tenant)
+	defer ms.release(ts)
+
+	ts.srv.engine.ServeHTTP(w, r)
+}
+
+// Creating a server loads its data, other requests for the same tenant wait for it while other tenants carry on
+func (ms *MultiServer) acquire(tenant Tenant) *tenantServer {
+	ms.mutex.Lock()
+	ts, ok := ms.servers[tenant.ProjectId]
+	if !ok {
+		ms.evict()
+		ts = &tenantServer{ready: make(chan bool)}
+		ms.servers[tenant.ProjectId] = ts
+	}
+	ts.active += 1
+	ts.lastUsed = ms.clock.Now()
+	closing := ms.closing[tenant.ProjectId]
+	ms.mutex.Unlock()
+
+	if ok {
+		<-ts.ready
+		return ts
+	}
+
+	// Loading the data before an evicted server of the same project has saved its own would lose it
+	if closing != nil {
+		<-closing
+		ms.mutex.Lock()
+		if ms.closing[tenant.ProjectId] == closing {
+			delete(ms.closing, tenant.ProjectId)
+		}
+		ms.mutex.Unlock()
+	}
+
+	logger.Info("Starting server for tenant", zap.String("host", tenant.Host), zap.String("projectId", tenant.ProjectId))
+	srv := ms.newServer(tenant)
+	if ms.configure != nil {
+		ms.configure(srv)
+	}
+	for _, projectId := range tenant.MirrorProjectIds {
+		srv.AddMirror(projectId)
+	}
+	srv.start(ms.fakeData)
+
+	ts.srv = srv
+	close(ts.ready)
+	return ts
+}
+
+func (ms *MultiServer) release(ts *tenantServer) {
+	ms.mutex.Lock()
+	defer ms.mutex.Unlock()
+
+	ts.active -= 1
+}
+
+// Servers handling requests, or still loading for one, are never evicted, so we might go over the limit for a while
+func (ms *MultiServer) evict() {
+	if len(ms.servers) < ms.maxServers {
+		return
+	}
+
+	oldest := ""
+	for projectId, ts := range ms.servers {
+		if ts.active > 0 {
+			continue
+		}
+		if oldest == "" || ts.lastUsed.Before(ms.servers[oldest].lastUsed) {
+			oldest = projectId
+		}
+	}
+
+	if oldest == "" {
+		logger.Warn("All tenant servers are busy, going over the limit", zap.Int("max", ms.maxServers))
+		return
+	}
+
+	srv := ms.servers[oldest].srv
+	delete(ms.servers, oldest)
+
+	done := make(chan bool)
+	ms.closing[oldest] = done
+	go func() {
+		closeTenantServer(oldest, srv)
+		close(done)
+	}()
+}
+
+func closeTenantServer(projectId string, srv *Server) {
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+
+	err := srv.Shutdown(ctx)
+	if err != nil {
+		logger.Warn("Error shutting down tenant server", zap.String("projectId", projectId), zap.Error(err))
+	}
+
+	err = srv.db.Close()
+	if err != nil {
+		logger.Warn("Error closing Firestore client", zap.String("projectId", projectId), zap.Error(err))
+	}
+	srv.closeMirrors()
+
+	logger.Info("Closed server for tenant", zap.String("projectId", projectId))
+}
+
+// Runs until SIGINT or SIGTERM, then shuts down all the tenant servers
+func (ms *MultiServer) Run(listenAddr string, fakeData bool) {
+	ms.fakeData = fakeData
+	ms.httpServer = &http.Server{
+		Addr:    listenAddr,
+		Handler: ms,
+	}
+
+	go func() {
+		logger.Info("Listening", zap.String("address", listenAddr), zap.Int("tenants", len(ms.tenants)))
+		err := ms.httpServer.ListenAndServe()
+		if err != nil && err != http.ErrServerClosed {
+			log.Panicf("Failed to run server: %s", err)
+		}
+	}()
+
+	waitForSignal()
+
+	logger.Info("Shutting down")
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+
+	err := ms.httpServer.Shutdown(ctx)
+	if err != nil {
+		logger.Warn("Error shutting down", zap.Error(err))
+	}
+
+	ms.closeAll()
+}
+
+// Shuts down all the tenant servers, after any still loading or being evicted are done with it
+func (ms *MultiServer) closeAll() {
+	ms.mutex.Lock()
+	servers, closing := ms.servers, ms.closing
+	ms.servers = map[string]*tenantServer{}
+	ms.closing = map[string]chan bool{}
+	ms.mutex.Unlock()
+
+	for projectId, ts := range servers {
+		<-ts.ready
+		closeTenantServer(projectId, ts.srv)
+	}
+	for _, done := range closing {
+		<-done
+	}
+}
@@ -0,0 +1,225 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"github.com/lietu/godometer"
+)
+
+// Each project has its own fake Firestore, which outlives the servers using it
+func newTestMultiServer(t *testing.T, maxServers int, configure func(*Server)) (*MultiServer, map[string]*fakeFirestore) {
+	tenants := []Tenant{
+		{Host: "a.example.com", ProjectId: "project-a", MirrorProjectIds: []string{"project-a-mirror"}},
+		{Host: "b.example.com", ProjectId: "project-b"},
+	}
+
+	fakes := map[string]*fakeFirestore{}
+	for _, projectId := range []string{"project-a", "project-a-mirror", "project-b"} {
+		fakes[projectId], _ = newFakeFirestore(t)
+	}
+
+	ms := NewMultiServer(false, tenants, maxServers, configure)
+	ms.newServer = func(tenant Tenant) *Server {
+		srv := NewServerWithClient(false, fakes[tenant.ProjectId].newClient(t), tenant.ApiAuth)
+		srv.newClient = func(ctx context.Context, projectId string) *firestore.Client {
+			return fakes[projectId].newClient(t)
+		}
+		srv.SetClock(NewFakeClock(testNow))
+		srv.initRecords(testNow)
+		return srv
+	}
+
+	ms.SetClock(NewFakeClock(testNow))
+	t.Cleanup(ms.closeAll)
+
+	return ms, fakes
+}
+
+func tenantRequest(ms *MultiServer, method string, url string, body interface{}) *httptest.ResponseRecorder {
+	var buf bytes.Buffer
+	if body != nil {
+		_ = json.NewEncoder(&buf).Encode(body)
+	}
+
+	req := httptest.NewRequest(method, url, &buf)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Forwarded-Proto", "https")
+
+	rec := httptest.NewRecorder()
+	ms.ServeHTTP(rec, req)
+	return rec
+}
+
+func hourMeters(t *testing.T, ms *MultiServer, host string, hour string) float32 {
+	t.Helper()
+	rec := tenantRequest(ms, http.MethodGet, "http://"+host+"/api/v1/stats/hours", nil)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Reading hours from %s failed with %d", host, rec.Code)
+	}
+
+	response := StatsResponse{}
+	if err := json.Unmarshal(rec.Body.Bytes(), &response); err != nil {
+		t.Fatalf("Failed to parse response: %s", err)
+	}
+	for _, rdp := range response.DataPoints {
+		if rdp.Timestamp == hour {
+			return rdp.Meters
+		}
+	}
+	t.Fatalf("No hour %s from %s", hour, host)
+	return 0
+}
+
+func TestTenantsAreIsolated(t *testing.T) {
+	ms, fakes := newTestMultiServer(t, 2, nil)
+
+	update := godometer.UpdateStatsRequest{DataPoints: []godometer.UpdateDataPoint{
+		{Timestamp: testMinute(testNow), Meters: 100, MetersPerSecond: 2, KilometersPerHour: 7.2},
+	}}
+	if rec := tenantRequest(ms, http.MethodPost, "http://a.example.com/api/v1/updateStats", update); rec.Code != http.StatusOK {
+		t.Fatalf("Update failed with %d", rec.Code)
+	}
+
+	if got := hourMeters(t, ms, "a.example.com", "2024-03-06 12"); got != 100 {
+		t.Errorf("Tenant a has %vm, expected 100m", got)
+	}
+	if got := hourMeters(t, ms, "b.example.com", "2024-03-06 12"); got != 0 {
+		t.Errorf("Tenant b has %vm, expected nothing", got)
+	}
+
+	if ms.servers["project-a"].srv.db == ms.servers["project-b"].srv.db {
+		t.Errorf("Tenants share a Firestore client")
+	}
+	if !fakes["project-a"].has("hours", "2024-03-06 12") {
+		t.Errorf("Tenant a's update wasn't saved in its project")
+	}
+	if ids := fakes["project-b"].ids("hours"); len(ids) != 0 {
+		t.Errorf("Tenant a's update was saved in tenant b's project: %v", ids)
+	}
+
+	if rec := tenantRequest(ms, http.MethodGet, "http://c.example.com/api/v1/stats/hours", nil); rec.Code != http.StatusNotFound {
+		t.Errorf("Unknown tenant got %d, expected 404", rec.Code)
+	}
+}
+
+func TestEvictedTenantSavesBeforeRestart(t *testing.T) {
+	// Buffered data is only saved when the evicted server shuts down, which takes a while
+	ms, fakes := newTestMultiServer(t, 1, func(srv *Server) {
+		srv.EnableBuffering(time.Hour, 0)
+	})
+	fakes["project-a"].setCommitDelay(100 * time.Millisecond)
+
+	update := godometer.UpdateStatsRequest{DataPoints: []godometer.UpdateDataPoint{
+		{Timestamp: testMinute(testNow), Meters: 100, MetersPerSecond: 2, KilometersPerHour: 7.2},
+	}}
+	if rec := tenantRequest(ms, http.MethodPost, "http://a.example.com/api/v1/updateStats", update); rec.Code != http.StatusOK {
+		t.Fatalf("Update failed with %d", rec.Code)
+	}
+
+	// Evicts a, and then b to bring a back
+	hourMeters(t, ms, "b.example.com", "2024-03-06 12")
+	if got := hourMeters(t, ms, "a.example.com", "2024-03-06 12"); got != 100 {
+		t.Errorf("Tenant a has %vm after coming back, expected the 100m its evicted server saved", got)
+	}
+}
+
+func TestLoadingTenantDoesntBlockOthers(t *testing.T) {
+	ms, _ := newTestMultiServer(t, 2, nil)
+	hourMeters(t, ms, "a.example.com", "2024-03-06 12")
+
+	newServer := ms.newServer
+	loading := make(chan bool)
+	created := 0
+	ms.newServer = func(tenant Tenant) *Server {
+		if tenant.ProjectId == "project-b" {
+			created += 1
+			<-loading
+		}
+		return newServer(tenant)
+	}
+
+	codes := make(chan int)
+	for i := 0; i < 2; i++ {
+		go func() {
+			codes <- tenantRequest(ms, http.MethodGet, "http://b.example.com/api/v1/stats/hours", nil).Code
+		}()
+	}
+
+	// Tenant a is served while b is still loading
+	served := make(chan int)
+	go func() {
+		served <- tenantRequest(ms, http.MethodGet, "http://a.example.com/api/v1/stats/hours", nil).Code
+	}()
+	select {
+	case code := <-served:
+		if code != http.StatusOK {
+			t.Errorf("Tenant a got %d while b was loading", code)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Tenant a waited for b to load")
+	}
+
+	close(loading)
+	for i := 0; i < 2; i++ {
+		if code := <-codes; code != http.StatusOK {
+			t.Errorf("Tenant b got %d", code)
+		}
+	}
+	if created != 1 {
+		t.Errorf("Created %d servers for tenant b, expected the second request to wait for the first", created)
+	}
+	if lastUsed := ms.servers["project-a"].lastUsed; !lastUsed.Equal(testNow) {
+		t.Errorf("Tenant a was last used at %s, expected the time from the clock", lastUsed)
+	}
+}
+
+func TestTenantMirrors(t *testing.T) {
+	ms, fakes := newTestMultiServer(t, 1, nil)
+
+	update := godometer.UpdateStatsRequest{DataPoints: []godometer.UpdateDataPoint{
+		{Timestamp: testMinute(testNow), Meters: 100, MetersPerSecond: 2, KilometersPerHour: 7.2},
+	}}
+	if rec := tenantRequest(ms, http.MethodPost, "http://a.example.com/api/v1/updateStats", update); rec.Code != http.StatusOK {
+		t.Fatalf("Update to a failed with %d", rec.Code)
+	}
+	first := ms.servers["project-a"].srv
+	if len(first.mirrors) != 1 {
+		t.Fatalf("Tenant a has %d mirrors, expected 1", len(first.mirrors))
+	}
+
+	// Evicts a
+	if rec := tenantRequest(ms, http.MethodPost, "http://b.example.com/api/v1/updateStats", update); rec.Code != http.StatusOK {
+		t.Fatalf("Update to b failed with %d", rec.Code)
+	}
+
+	// Only a's writes are mirrored, and only to a's mirror
+	if !fakes["project-a-mirror"].has("hours", "2024-03-06 12") {
+		t.Errorf("Tenant a's update wasn't mirrored")
+	}
+	if got := fakes["project-a-mirror"].commitCount(); got != fakes["project-a"].commitCount() {
+		t.Errorf("Tenant a's mirror got %d commits, expected the %d tenant a got", got, fakes["project-a"].commitCount())
+	}
+	if !fakes["project-b"].has("hours", "2024-03-06 12") {
+		t.Errorf("Tenant b's update wasn't saved")
+	}
+
+	if mirrors := ms.servers["project-b"].srv.mirrors; len(mirrors) != 0 {
+		t.Errorf("Tenant b has %d mirrors", len(mirrors))
+	}
+
+	// Evicting a closed its mirror along with it
+	ms.mutex.Lock()
+	done := ms.closing["project-a"]
+	ms.mutex.Unlock()
+	<-done
+	if len(first.mirrors) != 0 {
+		t.Errorf("Evicted tenant a still has %d mirrors open", len(first.mirrors))
+	}
+}
@@ -0,0 +1,156 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+type TotalResponse struct {
+	From              string  `json:"from"`
+	To                string  `json:"to"`
+	Meters            float32 `json:"m"`
+	MetersPerSecond   float32 `json:"mps"`
+	KilometersPerHour float32 `json:"kph"`
+	Buckets           int     `json:"buckets"`
+}
+
+// Parses either a minute or a day, returning the start and the end of it
+func parseRangeTime(value string) (time.Time, time.Time, error) {
+	ts, err := time.ParseInLocation(minuteLayout, value, utc)
+	if err == nil {
+		return ts, ts.Add(time.Minute), nil
+	}
+
+	ts, err = time.ParseInLocation(dayLayout, value, utc)
+	if err == nil {
+		return ts, ts.AddDate(0, 0, 1), nil
+	}
+
+	return time.Time{}, time.Time{}, fmt.Errorf("invalid time %q, expected %q or %q", value, minuteLayout, dayLayout)
+}
+
+// Splits [start, end) into as few stored buckets as possible, using the coarsest period that fits at each step.
+// Weeks don't line up with months or years so they're not used.
+func rangeBuckets(start time.Time, end time.Time) map[string][]string {
+	buckets := map[string][]string{}
+	current := start
+	for current.Before(end) {
+		year := time.Date(current.Year(), 1, 1, 0, 0, 0, 0, utc)
+		month := time.Date(current.Year(), current.Month(), 1, 0, 0, 0, 0, utc)
+		day := time.Date(current.Year(), current.Month(), current.Day(), 0, 0, 0, 0, utc)
+		hour := current.Truncate(time.Hour)
+
+		var next time.Time
+		if current.Equal(year) && !year.AddDate(1, 0, 0).After(end) {
+			buckets["years"] = append(buckets["years"], current.Format(yearLayout))
+			next = year.AddDate(1, 0, 0)
+		} else if current.Equal(month) && !month.AddDate(0, 1, 0).After(end) {
+			buckets["months"] = append(buckets["months"], current.Format(monthLayout))
+			next = month.AddDate(0, 1, 0)
+		} else if current.Equal(day) && !day.AddDate(0, 0, 1).After(end) {
+			buckets["days"] = append(buckets["days"], current.Format(dayLayout))
+			next = day.AddDate(0, 0, 1)
+		} else if current.Equal(hour) && !hour.Add(time.Hour).After(end) {
+			buckets["hours"] = append(buckets["hours"], current.Format(hourLayout))
+			next = hour.Add(time.Hour)
+		} else {
+			buckets["minutes"] = append(buckets["minutes"], current.Format(minuteLayout))
+			next = current.Add(time.Minute)
+		}
+		current = next
+	}
+
+	return buckets
+}
+
+// Looks up the records from memory where possible, the rest from the DB
+func (s *Server) lookupRecords(ctx context.Context, period string, ids []string) ([]DBDataPoint, error) {
+	var rows []DBDataPoint
+	var missing []string
+	s.writeMutex.RLock()
+	available, _ := s.periodRecords(period)
+	for _, id := range ids {
+		row, ok := available[id]
+		if ok {
+			rows = append(rows, row)
+		} else {
+			missing = append(missing, id)
+		}
+	}
+	s.writeMutex.RUnlock()
+
+	if len(missing) > 0 {
+		records, err := s.readRecords(ctx, collectionName(period), missing)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, row := range records {
+			rows = append(rows, row)
+		}
+	}
+
+	return rows, nil
+}
+
+// Adds up the rows, skipping empty ones
+func (s *Server) totalOf(rows []DBDataPoint) TotalResponse {
+	var response TotalResponse
+
+	// Speeds are averages over the time spent moving, so weigh them by it
+	var totalMPS float32
+	var totalKPH float32
+	var seconds float32
+	for _, row := range rows {
+		if row.Counter <= 0 && row.Meters <= 0 {
+			continue
+		}
+
+		response.Buckets += 1
+		response.Meters += zeroNaN(row.Meters)
+		totalMPS += zeroNaN(row.MetersPerSecond) * row.MovingSeconds
+		totalKPH += zeroNaN(row.KilometersPerHour) * row.MovingSeconds
+		seconds += row.MovingSeconds
+	}
+
+	if seconds > 0 {
+		response.MetersPerSecond = totalMPS / seconds
+		response.KilometersPerHour = totalKPH / seconds
+	}
+
+	if s.precision != nil {
+		response.Meters = float32(roundTo(response.Meters, s.precision.meters))
+		response.MetersPerSecond = float32(roundTo(response.MetersPerSecond, s.precision.speed))
+		response.KilometersPerHour = float32(roundTo(response.KilometersPerHour, s.precision.speed))
+	}
+
+	return response
+}
+
+func (s *Server) returnTotal(c *gin.Context) {
+	start, end, err := queryRange(c)
+	if err != nil {
+		badRequest(c, err)
+		return
+	}
+
+	ctx := c.Request.Context()
+	var rows []DBDataPoint
+	for period, ids := range rangeBuckets(start, end) {
+		periodRows, err := s.lookupRecords(ctx, period, ids)
+		if err != nil {
+			_ = c.AbortWithError(errorStatus(err), err)
+			return
+		}
+		rows = append(rows, periodRows...)
+	}
+
+	response := s.totalOf(rows)
+	response.From = c.Query("from")
+	response.To = c.Query("to")
+
+	c.JSON(200, response)
+}
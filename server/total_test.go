@@ -0,0 +1,74 @@
+package server
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestRangeBucketsAcrossMonthBoundary(t *testing.T) {
+	start := time.Date(2024, 1, 30, 0, 0, 0, 0, time.UTC)
+	end := time.Date(2024, 3, 3, 0, 0, 0, 0, time.UTC)
+
+	expected := map[string][]string{
+		"days":   {"2024-01-30", "2024-01-31", "2024-03-01", "2024-03-02"},
+		"months": {"2024-02"},
+	}
+	if got := rangeBuckets(start, end); !reflect.DeepEqual(got, expected) {
+		t.Errorf("Buckets are %v, expected %v", got, expected)
+	}
+}
+
+func TestRangeBucketsPartialHours(t *testing.T) {
+	start := time.Date(2024, 3, 6, 10, 58, 0, 0, time.UTC)
+	end := time.Date(2024, 3, 6, 12, 2, 0, 0, time.UTC)
+
+	expected := map[string][]string{
+		"minutes": {"2024-03-06 10:58", "2024-03-06 10:59", "2024-03-06 12:00", "2024-03-06 12:01"},
+		"hours":   {"2024-03-06 11"},
+	}
+	if got := rangeBuckets(start, end); !reflect.DeepEqual(got, expected) {
+		t.Errorf("Buckets are %v, expected %v", got, expected)
+	}
+}
+
+func TestTotalAcrossMonthBoundary(t *testing.T) {
+	srv, _, _ := newTestServer(t, testNow)
+
+	// Older days only in the DB, the rest in memory
+	seedRecord(t, srv, "days", "2024-01-30", DBDataPoint{Counter: 10, Meters: 1000, MetersPerSecond: 2, MovingSeconds: 500})
+	srv.months["2024-02"] = DBDataPoint{Counter: 20, Meters: 2000, MetersPerSecond: 2, MovingSeconds: 1000}
+	srv.days["2024-03-01"] = DBDataPoint{Counter: 5, Meters: 500, MetersPerSecond: 1, MovingSeconds: 500}
+
+	response := TotalResponse{}
+	rec := getJSON(t, srv, "/api/v1/total?from=2024-01-30&to=2024-03-02", &response)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Total failed with %d: %s", rec.Code, rec.Body.String())
+	}
+
+	if response.Meters != 3500 || response.Buckets != 3 {
+		t.Errorf("Total is %vm from %d buckets, expected 3500m from 3", response.Meters, response.Buckets)
+	}
+	if response.MetersPerSecond != 1.75 {
+		t.Errorf("Average speed is %v m/s, expected 1.75", response.MetersPerSecond)
+	}
+}
+
+func TestTotalOfEmptyRange(t *testing.T) {
+	srv, _, _ := newTestServer(t, testNow)
+
+	response := TotalResponse{}
+	rec := getJSON(t, srv, "/api/v1/total?from=2023-06-01%2010:00&to=2023-06-01%2010:30", &response)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Total failed with %d: %s", rec.Code, rec.Body.String())
+	}
+	if response.Meters != 0 || response.Buckets != 0 || response.MetersPerSecond != 0 {
+		t.Errorf("Expected nothing for an empty range, got %+v", response)
+	}
+
+	rec = getJSON(t, srv, "/api/v1/total?from=2024-03-02&to=2024-03-01", nil)
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("Reversed range returned %d, expected 400", rec.Code)
+	}
+}
@@ -0,0 +1,17 @@
+package server
+
+import (
+	"go.opentelemetry.io/otel/api/global"
+	"go.opentelemetry.io/otel/api/trace"
+)
+
+const tracerName = "github.com/lietu/godometer/server"
+
+// Uses the global provider by default, which does nothing unless one is registered
+func defaultTracer() trace.Tracer {
+	return global.Tracer(tracerName)
+}
+
+func (s *Server) SetTraceProvider(tp trace.Provider) {
+	s.tracer = tp.Tracer(tracerName)
+}
@@ -0,0 +1,149 @@
+package server
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/lietu/godometer"
+	"go.opentelemetry.io/otel/api/trace"
+	"go.opentelemetry.io/otel/label"
+)
+
+// Keeps every span started through it in memory
+type recordingProvider struct {
+	mutex sync.Mutex
+	spans []*recordedSpan
+}
+
+type recordedSpan struct {
+	trace.NoopSpan
+	provider   *recordingProvider
+	name       string
+	parent     *recordedSpan
+	attributes map[label.Key]string
+	ended      bool
+}
+
+func (rp *recordingProvider) Tracer(name string, opts ...trace.TracerOption) trace.Tracer {
+	return rp
+}
+
+func (rp *recordingProvider) Start(ctx context.Context, name string, opts ...trace.StartOption) (context.Context, trace.Span) {
+	config := trace.StartConfig{}
+	for _, opt := range opts {
+		opt(&config)
+	}
+
+	span := &recordedSpan{provider: rp, name: name, attributes: map[label.Key]string{}}
+	if parent, ok := trace.SpanFromContext(ctx).(*recordedSpan); ok {
+		span.parent = parent
+	}
+	span.SetAttributes(config.Attributes...)
+
+	rp.mutex.Lock()
+	rp.spans = append(rp.spans, span)
+	rp.mutex.Unlock()
+
+	return trace.ContextWithSpan(ctx, span), span
+}
+
+func (rp *recordingProvider) named(name string) []*recordedSpan {
+	rp.mutex.Lock()
+	defer rp.mutex.Unlock()
+
+	var spans []*recordedSpan
+	for _, span := range rp.spans {
+		if span.name == name {
+			spans = append(spans, span)
+		}
+	}
+	return spans
+}
+
+func (rs *recordedSpan) SetAttributes(attributes ...label.KeyValue) {
+	rs.provider.mutex.Lock()
+	defer rs.provider.mutex.Unlock()
+	for _, kv := range attributes {
+		rs.attributes[kv.Key] = kv.Value.Emit()
+	}
+}
+
+func (rs *recordedSpan) End(options ...trace.EndOption) {
+	rs.provider.mutex.Lock()
+	defer rs.provider.mutex.Unlock()
+	rs.ended = true
+}
+
+func TestWriteStatsSpan(t *testing.T) {
+	srv, _, _ := newTestServer(t, testNow)
+	provider := &recordingProvider{}
+	srv.SetTraceProvider(provider)
+
+	ctx, parent := provider.Start(context.Background(), "request")
+	err := srv.writeStats(ctx, []godometer.UpdateDataPoint{
+		{Timestamp: testMinute(testNow), Meters: 60, MetersPerSecond: 1, KilometersPerHour: 3.6},
+		{Timestamp: testMinute(testNow.Add(-time.Minute)), Meters: 60, MetersPerSecond: 1, KilometersPerHour: 3.6},
+	})
+	if err != nil {
+		t.Fatalf("writeStats failed: %s", err)
+	}
+
+	spans := provider.named("writeStats")
+	if len(spans) != 1 {
+		t.Fatalf("Expected one writeStats span, got %d", len(spans))
+	}
+
+	span := spans[0]
+	if span.parent != parent {
+		t.Errorf("writeStats span isn't a child of the caller's span")
+	}
+	if !span.ended {
+		t.Errorf("writeStats span wasn't ended")
+	}
+
+	expected := map[label.Key]string{
+		"godometer.events":  "2",
+		"godometer.records": "8",
+	}
+	for key, value := range expected {
+		if got := span.attributes[key]; got != value {
+			t.Errorf("Attribute %s is %q, expected %q", key, got, value)
+		}
+	}
+	if _, ok := span.attributes["godometer.commit_duration_ms"]; !ok {
+		t.Errorf("Missing the commit duration attribute")
+	}
+}
+
+func TestLoadDataSpans(t *testing.T) {
+	srv, _, _ := newTestServer(t, testNow)
+	provider := &recordingProvider{}
+	srv.SetTraceProvider(provider)
+
+	ctx, parent := provider.Start(context.Background(), "startup")
+	err := srv.loadData(ctx)
+	if err != nil {
+		t.Fatalf("loadData failed: %s", err)
+	}
+
+	loads := provider.named("loadData")
+	if len(loads) != 1 || loads[0].parent != parent {
+		t.Fatalf("Expected one loadData span under the caller's span, got %d", len(loads))
+	}
+
+	collections := map[string]bool{}
+	for _, span := range provider.named("readRecords") {
+		if span.parent != loads[0] {
+			t.Errorf("readRecords span for %s isn't a child of loadData", span.attributes["godometer.collection"])
+		}
+		collections[span.attributes["godometer.collection"]] = true
+	}
+
+	for _, period := range periods {
+		if !collections[collectionName(period)] {
+			t.Errorf("No readRecords span for %s", period)
+		}
+	}
+}
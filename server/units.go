@@ -0,0 +1,140 @@
+package server
+
+import (
+	"math"
+)
+
+// Documents stored with integer storage only have the integer fields, distance and speed in millimeters so adding
+// them up is exact. Speed in km/h is derived from mm/s when read.
+type integerDataPoint struct {
+	SchemaVersion        int     `firestore:"schemaVersion"`
+	Integer              bool    `firestore:"int"`
+	Counter              int64   `firestore:"Counter"`
+	Millimeters          int64   `firestore:"mm"`
+	MillimetersPerSecond int64   `firestore:"mmps"`
+	MovingSeconds        float32 `firestore:"movingSeconds"`
+	Events               string  `firestore:"events,omitempty"`
+}
+
+// Documents stored with the default float storage have the fields they had before integer storage. The integer
+// fields are only kept in memory, filled in from the floats when read.
+type floatDataPoint struct {
+	SchemaVersion     int     `firestore:"schemaVersion"`
+	Counter           int64   `firestore:"Counter"`
+	Meters            float32 `firestore:"Meters"`
+	MetersPerSecond   float32 `firestore:"MetersPerSecond"`
+	KilometersPerHour float32 `firestore:"KilometersPerHour"`
+	MovingSeconds     float32 `firestore:"movingSeconds"`
+	Events            string  `firestore:"events,omitempty"`
+}
+
+func toMillis(value float32) int64 {
+	return int64(math.Round(float64(value) * 1000))
+}
+
+// Fills in the integer fields from the floats, for new data and migrating old documents
+func (ddp DBDataPoint) withIntegers() DBDataPoint {
+	ddp.Millimeters = toMillis(ddp.Meters)
+	ddp.MillimetersPerSecond = toMillis(ddp.MetersPerSecond)
+	return ddp
+}
+
+// Fills in the floats from the integer fields, for reading documents stored with integer storage
+func (ddp DBDataPoint) withFloats() DBDataPoint {
+	ddp.Meters = float32(ddp.Millimeters) / 1000
+	ddp.MetersPerSecond = float32(ddp.MillimetersPerSecond) / 1000
+	ddp.KilometersPerHour = float32(ddp.MillimetersPerSecond) * 3600 / 1000 / 1000
+	ddp.Integer = false
+	return ddp
+}
+
+// Whatever way the document was stored, returns it with both floats and integers filled in
+func (ddp DBDataPoint) decoded() DBDataPoint {
+	if ddp.Integer {
+		return ddp.withFloats()
+	}
+	return ddp.withIntegers()
+}
+
+// Store distance and speed as integer millimeters instead of floats. This makes documents smaller and totals exact,
+// but only versions of godometer that know about integer storage can read them.
+func (s *Server) SetIntegerStorage(enabled bool) {
+	s.integerStorage = enabled
+}
+
+// The document to write for the record, in whichever format is configured
+func (s *Server) storable(ddp DBDataPoint) interface{} {
+	ddp = ddp.stamped()
+	if !s.integerStorage {
+		return floatDataPoint{
+			SchemaVersion:     ddp.SchemaVersion,
+			Counter:           ddp.Counter,
+			Meters:            ddp.Meters,
+			MetersPerSecond:   ddp.MetersPerSecond,
+			KilometersPerHour: ddp.KilometersPerHour,
+			MovingSeconds:     ddp.MovingSeconds,
+			Events:            ddp.Events,
+		}
+	}
+
+	return integerDataPoint{
+		SchemaVersion:        ddp.SchemaVersion,
+		Integer:              true,
+		Counter:              ddp.Counter,
+		Millimeters:          ddp.Millimeters,
+		MillimetersPerSecond: ddp.MillimetersPerSecond,
+		MovingSeconds:        ddp.MovingSeconds,
+		Events:               ddp.Events,
+	}
+}
+
+// A linear conversion of meters to some other unit, e.g. steps from the stride length, shown next to the meters
+// in responses. Nothing about it is stored, so it can be changed at any time.
+type derivedUnit struct {
+	label  string
+	factor float32
+	offset float32
+}
+
+type DerivedValue struct {
+	Unit  string  `json:"unit"`
+	Value float32 `json:"value"`
+}
+
+// Report meters * factor + offset as the unit with the given label too, an empty label turns it off
+func (s *Server) SetDerivedUnit(label string, factor float32, offset float32) {
+	if label == "" {
+		s.derivedUnit = nil
+		return
+	}
+	s.derivedUnit = &derivedUnit{label: label, factor: factor, offset: offset}
+}
+
+func (s *Server) withDerived(rdp ResponseDataPoint) ResponseDataPoint {
+	if s.derivedUnit == nil {
+		return rdp
+	}
+
+	rdp.Derived = &DerivedValue{
+		Unit:  s.derivedUnit.label,
+		Value: zeroNaN(rdp.Meters)*s.derivedUnit.factor + s.derivedUnit.offset,
+	}
+	return rdp
+}
+
+// Everything done to data points on the way out: derived units and rounding
+func (s *Server) formatResponse(rdp ResponseDataPoint) ResponseDataPoint {
+	return s.roundResponse(s.withDerived(rdp))
+}
+
+func (s *Server) formatResponses(events []ResponseDataPoint) []ResponseDataPoint {
+	if s.precision == nil && s.derivedUnit == nil {
+		return events
+	}
+
+	formatted := make([]ResponseDataPoint, len(events))
+	for i, e := range events {
+		formatted[i] = s.formatResponse(e)
+	}
+	return formatted
+}
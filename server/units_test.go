@@ -0,0 +1,154 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/lietu/godometer"
+)
+
+func TestIntegerAccumulationIsExact(t *testing.T) {
+	var row DBDataPoint
+	ok := false
+	floatTotal := float32(0)
+	for i := 0; i < 1000; i++ {
+		row, _ = calculateUpdate(row, ok, sample(0.1, 1), false, sampleThreshold{})
+		ok = true
+		floatTotal += 0.1
+	}
+
+	// Adding up float32s drifts, millimeters don't
+	if floatTotal == 100 {
+		t.Fatalf("Expected float32 addition to drift, got exactly %v", floatTotal)
+	}
+	if row.Millimeters != 100000 || row.Meters != 100 {
+		t.Errorf("Total is %vmm or %vm, expected exactly 100m", row.Millimeters, row.Meters)
+	}
+}
+
+func TestIntegerStorageRoundTrip(t *testing.T) {
+	srv, _, _ := newTestServer(t, testNow)
+	srv.SetIntegerStorage(true)
+
+	var dataPoints []godometer.UpdateDataPoint
+	for minute := 0; minute < 30; minute++ {
+		dataPoints = append(dataPoints, godometer.UpdateDataPoint{
+			Timestamp:         fmt.Sprintf("2024-03-06 12:%02d", minute),
+			Meters:            33.3,
+			MetersPerSecond:   0.555,
+			KilometersPerHour: 1.998,
+		})
+	}
+	if err := srv.writeStats(context.Background(), dataPoints); err != nil {
+		t.Fatalf("writeStats failed: %s", err)
+	}
+
+	doc, err := srv.db.Collection(collectionName("hours")).Doc("2024-03-06 12").Get(context.Background())
+	if err != nil {
+		t.Fatalf("Failed to read the hour: %s", err)
+	}
+	if _, ok := doc.Data()["Meters"]; ok {
+		t.Errorf("Integer storage wrote float fields: %v", doc.Data())
+	}
+
+	records, err := srv.readRecords(context.Background(), collectionName("hours"), []string{"2024-03-06 12"})
+	if err != nil {
+		t.Fatalf("readRecords failed: %s", err)
+	}
+	row := records["2024-03-06 12"]
+	if row.Millimeters != 999000 || row.Meters != 999 || row.MillimetersPerSecond != 555 {
+		t.Errorf("Hour read back as %s, %vmm @ %vmm/s, expected 999000mm @ 555mm/s", recordStr(row), row.Millimeters, row.MillimetersPerSecond)
+	}
+	if !closeTo(row.KilometersPerHour, 1.998) {
+		t.Errorf("Hour has %v km/h, expected 1.998", row.KilometersPerHour)
+	}
+}
+
+func TestFloatStorageHasNoIntegerFields(t *testing.T) {
+	srv, _, _ := newTestServer(t, testNow)
+
+	update := godometer.UpdateDataPoint{Timestamp: "2024-03-06 12:10", Meters: 33.3, MetersPerSecond: 0.555, KilometersPerHour: 1.998}
+	if err := srv.writeStats(context.Background(), []godometer.UpdateDataPoint{update}); err != nil {
+		t.Fatalf("writeStats failed: %s", err)
+	}
+
+	doc, err := srv.db.Collection(collectionName("hours")).Doc("2024-03-06 12").Get(context.Background())
+	if err != nil {
+		t.Fatalf("Failed to read the hour: %s", err)
+	}
+	for _, name := range []string{"mm", "mmps", "int"} {
+		if _, ok := doc.Data()[name]; ok {
+			t.Errorf("Float storage wrote %s: %v", name, doc.Data())
+		}
+	}
+
+	// The millimeters are filled in when read, so adding to it after a restart still works
+	if err := srv.loadData(context.Background()); err != nil {
+		t.Fatalf("loadData failed: %s", err)
+	}
+	update.Timestamp = "2024-03-06 12:11"
+	if err := srv.writeStats(context.Background(), []godometer.UpdateDataPoint{update}); err != nil {
+		t.Fatalf("writeStats failed: %s", err)
+	}
+	if row := srv.hours["2024-03-06 12"]; row.Millimeters != 66600 || !closeTo(row.Meters, 66.6) {
+		t.Errorf("Hour is %s (%dmm), expected 66.6m", recordStr(row), row.Millimeters)
+	}
+}
+
+func TestDerivedUnit(t *testing.T) {
+	tests := []struct {
+		label    string
+		factor   float32
+		offset   float32
+		expected float32
+	}{
+		// 0.75m stride
+		{"steps", 1 / 0.75, 0, 160},
+		{"laps", 0.0025, 0, 0.3},
+		{"kcal", 0.06, 10, 17.2},
+		// Identity, the same as the meters
+		{"m", 1, 0, 120},
+	}
+
+	minute := testMinute(testNow)
+	for _, test := range tests {
+		srv, _, _ := newTestServer(t, testNow)
+		srv.SetDerivedUnit(test.label, test.factor, test.offset)
+		if rec := postStats(t, srv, godometer.UpdateDataPoint{Timestamp: minute, Meters: 120, MetersPerSecond: 2, KilometersPerHour: 7.2}); rec.Code != http.StatusOK {
+			t.Fatalf("Update failed with %d: %s", rec.Code, rec.Body.String())
+		}
+
+		response := StatsResponse{}
+		if rec := getJSON(t, srv, "/api/v1/stats/minutes", &response); rec.Code != http.StatusOK {
+			t.Fatalf("Request failed with %d", rec.Code)
+		}
+		latest := response.DataPoints[len(response.DataPoints)-1]
+		if latest.Derived == nil || latest.Derived.Unit != test.label || !closeTo(latest.Derived.Value, test.expected) {
+			t.Errorf("120m in %s is %+v, expected %v", test.label, latest.Derived, test.expected)
+		}
+		if latest.Meters != 120 {
+			t.Errorf("Meters changed to %v with %s", latest.Meters, test.label)
+		}
+
+		// Only shown, never stored
+		if got := srv.minutes[minute].Meters; got != 120 {
+			t.Errorf("Stored minute has %v meters with %s, expected 120", got, test.label)
+		}
+	}
+}
+
+func TestNoDerivedUnit(t *testing.T) {
+	srv, _, _ := newTestServer(t, testNow)
+	srv.SetDerivedUnit("steps", 1/0.75, 0)
+	srv.SetDerivedUnit("", 1, 0)
+
+	rdp := srv.formatResponse(ResponseDataPoint{Timestamp: testMinute(testNow), Meters: 120})
+	if rdp.Derived != nil {
+		t.Errorf("Metric only has a derived value %+v", rdp.Derived)
+	}
+	if _, ok := jsonKeys(t, rdp)["derived"]; ok {
+		t.Errorf("Derived value wasn't left out of the response")
+	}
+}
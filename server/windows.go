@@ -0,0 +1,35 @@
+package server
+
+import (
+	"context"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// Moves the windows along at the start of every minute, so new buckets exist before any updates for them arrive
+// and the oldest drop out even when there's no traffic.
+func (s *Server) runWindows(ctx context.Context) {
+	for {
+		now := s.clock.Now()
+		next := now.Truncate(time.Minute).Add(time.Minute)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-s.clock.After(next.Sub(now)):
+			s.rollWindows(ctx)
+		}
+	}
+}
+
+func (s *Server) rollWindows(ctx context.Context) {
+	s.writeMutex.Lock()
+	defer s.writeMutex.Unlock()
+
+	aged := s.clearOldStats()
+	err := s.compactMinutes(ctx, aged)
+	if err != nil {
+		logger.Warn("Failed to compact minutes", zap.Error(err))
+	}
+}
@@ -0,0 +1,183 @@
+package server
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestWindowKeysOnLeapDay(t *testing.T) {
+	now := time.Date(2024, 2, 29, 0, 30, 0, 0, time.UTC)
+
+	days := Last7Days(now)
+	expectedDays := [7]string{"2024-02-23", "2024-02-24", "2024-02-25", "2024-02-26", "2024-02-27", "2024-02-28", "2024-02-29"}
+	if days != expectedDays {
+		t.Errorf("Days are %v, expected %v", days, expectedDays)
+	}
+
+	hours := Last24Hours(now)
+	if hours[0] != "2024-02-28 01" || hours[23] != "2024-02-29 00" {
+		t.Errorf("Hours go from %s to %s", hours[0], hours[23])
+	}
+
+	years := Last4Years(now)
+	expectedYears := [4]string{"2021", "2022", "2023", "2024"}
+	if years != expectedYears {
+		t.Errorf("Years are %v, expected %v", years, expectedYears)
+	}
+}
+
+func TestWindowKeysOnMonthBoundary(t *testing.T) {
+	now := time.Date(2024, 3, 1, 0, 0, 30, 0, time.UTC)
+
+	minutes := Last60Minutes(now)
+	if minutes[0] != "2024-02-29 23:01" || minutes[59] != "2024-03-01 00:00" {
+		t.Errorf("Minutes go from %s to %s", minutes[0], minutes[59])
+	}
+
+	months := Last12Months(now)
+	expectedMonths := [12]string{
+		"2023-04", "2023-05", "2023-06", "2023-07", "2023-08", "2023-09",
+		"2023-10", "2023-11", "2023-12", "2024-01", "2024-02", "2024-03",
+	}
+	if months != expectedMonths {
+		t.Errorf("Months are %v, expected %v", months, expectedMonths)
+	}
+
+	weeks := Last5Weeks(now)
+	expectedWeeks := [5]string{"2024 week 5", "2024 week 6", "2024 week 7", "2024 week 8", "2024 week 9"}
+	if weeks != expectedWeeks {
+		t.Errorf("Weeks are %v, expected %v", weeks, expectedWeeks)
+	}
+}
+
+func TestMonthsFromEndOfMonth(t *testing.T) {
+	// Stepping a month from the 31st mustn't skip a shorter month
+	months := Last12Months(time.Date(2024, 1, 31, 12, 0, 0, 0, time.UTC))
+	if months[10] != "2023-12" || months[0] != "2023-02" {
+		t.Errorf("Months are %v", months)
+	}
+}
+
+func TestServerWindowsFollowClock(t *testing.T) {
+	now := time.Date(2024, 2, 29, 23, 59, 10, 0, time.UTC)
+	srv, _, _ := newTestServer(t, now)
+
+	var keys []string
+	for key := range srv.months {
+		keys = append(keys, key)
+	}
+	if _, ok := srv.months["2024-02"]; !ok || len(keys) != 12 {
+		t.Errorf("Months in memory are %v", keys)
+	}
+
+	if got := srv.getPeriodIds("days"); got[len(got)-1] != "2024-02-29" {
+		t.Errorf("Latest day is %s, expected 2024-02-29", got[len(got)-1])
+	}
+}
+
+func TestWindowKeysAreInUTC(t *testing.T) {
+	// Only the built-in UTC is needed, a fixed zone works without tzdata too
+	helsinki := time.FixedZone("EET", 2*60*60)
+	now := time.Date(2024, 3, 1, 1, 30, 0, 0, helsinki)
+
+	days := Last7Days(now)
+	if days[6] != "2024-02-29" {
+		t.Errorf("Latest day is %s, expected 2024-02-29 in UTC", days[6])
+	}
+}
+
+func TestWindowsAcrossDSTTransitions(t *testing.T) {
+	helsinki, err := time.LoadLocation("Europe/Helsinki")
+	if err != nil {
+		t.Skipf("No tzdata: %s", err)
+	}
+	newYork, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skipf("No tzdata: %s", err)
+	}
+
+	// Right before the clocks spring forward in Helsinki, and the hour that repeats when New York falls back
+	transitions := []time.Time{
+		time.Date(2024, 3, 31, 2, 59, 30, 0, helsinki),
+		time.Date(2024, 11, 3, 1, 59, 30, 0, newYork),
+	}
+
+	for _, now := range transitions {
+		srv, _, clock := newTestServer(t, now)
+
+		for i := 0; i < 3; i++ {
+			clock.Add(30 * time.Minute)
+			srv.rollWindows(context.Background())
+
+			for _, period := range periods {
+				ids := srv.getPeriodIds(period)
+				seen := map[string]bool{}
+				for _, id := range ids {
+					if id == "" || seen[id] {
+						t.Fatalf("%s window at %s has an empty or repeated key: %v", period, clock.Now(), ids)
+					}
+					seen[id] = true
+				}
+
+				records, _ := srv.periodRecords(period)
+				if len(records) != len(ids) {
+					t.Errorf("%s has %d records at %s, expected %d", period, len(records), clock.Now(), len(ids))
+				}
+			}
+		}
+
+		expected := testMinute(clock.Now())
+		if minutes := Last60Minutes(clock.Now()); minutes[59] != expected {
+			t.Errorf("Latest minute is %s, expected %s", minutes[59], expected)
+		}
+	}
+}
+
+func (fc *FakeClock) waiting() int {
+	fc.mutex.Lock()
+	defer fc.mutex.Unlock()
+	return len(fc.timers)
+}
+
+func TestWindowsRollOverWithoutUpdates(t *testing.T) {
+	srv, _, clock := newTestServer(t, testNow)
+	srv.start(false)
+	defer func() {
+		_ = srv.Shutdown(context.Background())
+	}()
+
+	hasMinute := func(minute string) bool {
+		srv.writeMutex.RLock()
+		defer srv.writeMutex.RUnlock()
+		_, ok := srv.minutes[minute]
+		return ok
+	}
+
+	if hasMinute("2024-03-06 12:31") || !hasMinute("2024-03-06 11:31") {
+		t.Fatalf("Minutes don't start at the clock's time")
+	}
+
+	// Only moving the clock once the worker waits for the next minute
+	deadline := time.Now().Add(5 * time.Second)
+	for clock.waiting() == 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("Windows worker isn't waiting on the clock")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	clock.Set(time.Date(2024, 3, 6, 12, 31, 0, 0, time.UTC))
+
+	for !hasMinute("2024-03-06 12:31") {
+		if time.Now().After(deadline) {
+			t.Fatalf("Minute 12:31 wasn't created")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if hasMinute("2024-03-06 11:31") {
+		t.Errorf("Minute 11:31 is still in the window")
+	}
+	if ids := srv.getPeriodIds("minutes"); ids[59] != "2024-03-06 12:31" {
+		t.Errorf("Latest minute is %s", ids[59])
+	}
+}